@@ -0,0 +1,316 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"irc/server/audit"
+	"irc/server/delivery"
+	"irc/server/model"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const adminPath = "/admin/"
+
+// UnauthorizedCode is the code of responses to admin API requests without
+// the admin token.
+const UnauthorizedCode = "unauthorized"
+
+// requireAdmin rejects requests that don't carry the admin token as a bearer
+// token. Without a token, the admin API is disabled, and answers 404 as if
+// it weren't there.
+func (s *Server) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.adminToken == "" {
+			notFound(w, "Invalid path")
+			return
+		}
+
+		token, ok := bearerToken(r)
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			errorWithCode(w, r, 401, UnauthorizedCode, fmt.Errorf("the admin API requires the admin token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bearerToken returns the token of a request's "Authorization: Bearer"
+// header. The scheme is case-insensitive, as in RFC 7235.
+func bearerToken(r *http.Request) (string, bool) {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return "", false
+	}
+	return parts[1], true
+}
+
+func (s *Server) AdminMembersHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ListMemberships(w, r, s.store, s.dispatcher)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+func (s *Server) AdminMemberHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := getAdminResourceId(r.URL, "members")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		RemoveMember(w, r, s.store, s.audit, tag)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+func (s *Server) AdminRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ListRoomBacklogs(w, s.store, s.dispatcher)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+func (s *Server) AdminBansHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ListBans(w, s.store)
+	case http.MethodPost:
+		Ban(w, r, s.store, s.audit)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+func (s *Server) AdminBanHandler(w http.ResponseWriter, r *http.Request) {
+	tag, err := getAdminResourceId(r.URL, "bans")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		Unban(w, r, s.store, s.audit, tag)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+func (s *Server) AdminNoticesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		PostServerNotice(w, r, s.store, s.audit)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// MemberStatus is a member of a room, with the status of deliveries to its
+// callback. Bots, which have no callback, have no delivery status.
+type MemberStatus struct {
+	model.Membership
+	Delivery *delivery.QueueStatus `json:"delivery,omitempty"`
+}
+
+// ListMemberships writes the members of every room, or with a tag query
+// parameter, the rooms of one member.
+func ListMemberships(w http.ResponseWriter, r *http.Request, store model.MessageProxyStore, dispatcher *delivery.Dispatcher) {
+	tag := r.URL.Query().Get("tag")
+	body := []MemberStatus{}
+	for _, membership := range store.Memberships() {
+		if tag != "" && membership.Tag != tag {
+			continue
+		}
+		status := MemberStatus{Membership: membership}
+		if !membership.Bot {
+			queue := deliveryStatus(dispatcher, membership)
+			status.Delivery = &queue
+		}
+		body = append(body, status)
+	}
+
+	res, err := json.Marshal(body)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+	w.Write(res)
+}
+
+func deliveryStatus(dispatcher *delivery.Dispatcher, membership model.Membership) delivery.QueueStatus {
+	if dispatcher == nil {
+		return delivery.QueueStatus{}
+	}
+	return dispatcher.Status(membership.Tag, membership.CallbackUrl)
+}
+
+// RemovedResponseBody lists the rooms a member was removed from.
+type RemovedResponseBody struct {
+	Rooms []int `json:"rooms"`
+}
+
+// RemoveMember removes a member from every room, telling the other members
+// why if the request has a reason query parameter.
+func RemoveMember(w http.ResponseWriter, r *http.Request, store model.MessageProxyStore, log *audit.Log, tag string) {
+	reason := "removed by the server"
+	why := r.URL.Query().Get("reason")
+	if why != "" {
+		reason += ": " + why
+	}
+
+	log.Record(r.Context(), audit.Entry{Action: audit.AdminRemove, Target: tag, Reason: why})
+	res, err := json.Marshal(RemovedResponseBody{store.RemoveMember(r.Context(), tag, reason)})
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+	w.Write(res)
+}
+
+// RoomBacklog sums the status of deliveries to the members of a room.
+// Members in several rooms with the same callback share a queue, which
+// counts towards the backlog of each of their rooms.
+type RoomBacklog struct {
+	RoomId   int    `json:"roomId"`
+	RoomName string `json:"roomName"`
+	Members  int    `json:"members"`
+	Backlog  int    `json:"backlog"`
+	Dropped  int    `json:"dropped"`
+	// Failing counts the members whose last delivery attempt failed
+	Failing int `json:"failing"`
+}
+
+func ListRoomBacklogs(w http.ResponseWriter, store model.MessageProxyStore, dispatcher *delivery.Dispatcher) {
+	metadata := store.GetMetadata()
+	backlogs := make([]RoomBacklog, len(metadata))
+	index := make(map[int]int, len(metadata))
+	for i, meta := range metadata {
+		backlogs[i] = RoomBacklog{RoomId: meta.Id, RoomName: meta.Name}
+		index[meta.Id] = i
+	}
+
+	for _, membership := range store.Memberships() {
+		i, ok := index[membership.RoomId]
+		if !ok {
+			// Created since the rooms were listed
+			continue
+		}
+		backlog := &backlogs[i]
+		backlog.Members += 1
+		if membership.Bot {
+			continue
+		}
+		status := deliveryStatus(dispatcher, membership)
+		backlog.Backlog += status.Pending
+		backlog.Dropped += status.Dropped
+		if status.Failures > 0 {
+			backlog.Failing += 1
+		}
+	}
+
+	res, err := json.Marshal(backlogs)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+	w.Write(res)
+}
+
+type BanArgs struct {
+	Tag    string `json:"tag"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Ban bans a member from every room, removing it from those it's in.
+func Ban(w http.ResponseWriter, r *http.Request, store model.MessageProxyStore, log *audit.Log) {
+	var args BanArgs
+	err := json.NewDecoder(r.Body).Decode(&args)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if args.Tag == "" {
+		badRequest(w, fmt.Errorf("a tag is required"))
+		return
+	}
+
+	// Recorded first, so it comes before the leaves the ban causes
+	log.Record(r.Context(), audit.Entry{Action: audit.AdminBan, Target: args.Tag, Reason: args.Reason})
+	rooms, err := store.Ban(r.Context(), args.Tag, args.Reason)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	res, err := json.Marshal(RemovedResponseBody{rooms})
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+	w.Write(res)
+}
+
+func ListBans(w http.ResponseWriter, store model.MessageProxyStore) {
+	res, err := json.Marshal(store.Bans())
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+	w.Write(res)
+}
+
+func Unban(w http.ResponseWriter, r *http.Request, store model.MessageProxyStore, log *audit.Log, tag string) {
+	err := store.Unban(tag)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	log.Record(r.Context(), audit.Entry{Action: audit.AdminUnban, Target: tag})
+}
+
+type ServerNoticeArgs struct {
+	Message string `json:"message"`
+}
+
+// PostServerNotice sends a notice to the members of every room.
+func PostServerNotice(w http.ResponseWriter, r *http.Request, store model.MessageProxyStore, log *audit.Log) {
+	var args ServerNoticeArgs
+	err := json.NewDecoder(r.Body).Decode(&args)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	if strings.TrimSpace(args.Message) == "" {
+		badRequest(w, fmt.Errorf("a message is required"))
+		return
+	}
+
+	log.Record(r.Context(), audit.Entry{Action: audit.AdminNotice, After: audit.State(args)})
+	err = store.PostNotice(r.Context(), args.Message)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+}
+
+// getAdminResourceId returns the ID following the resource segment of a
+// /admin/{resource}/{id} url path.
+func getAdminResourceId(url *url.URL, resource string) (string, error) {
+	segments := strings.Split(strings.TrimPrefix(url.Path, adminPath), "/")
+	if len(segments) < 2 || segments[0] != resource || segments[1] == "" {
+		return "", fmt.Errorf("url path doesn't contain an ID after %q", resource)
+	}
+	return segments[1], nil
+}
@@ -2,184 +2,168 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"irc/server/model"
+	"irc/server/requestid"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
-// TODO: refactor validations in middleware fns
-func SetRoutes() {
-	http.HandleFunc("/api/rooms", ChatRoomsHandler)
-	http.HandleFunc("/api/rooms/*", ChatRoomHandler)
-	http.HandleFunc("/api/rooms/*/members", MembersHandler)
-	http.HandleFunc("/api/rooms/*/members/*", MemberHandler)
-	http.HandleFunc("/api/rooms/*/members/*/messages", MessagesHandler)
-}
-
-func ChatRoomsHandler(w http.ResponseWriter, r *http.Request) {
-	store := model.GetChatRoomStore()
-
+func (s *Server) ChatRoomsHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		ListChatRooms(w, store)
+		ListChatRooms(w, r, s.store)
 	case http.MethodPost:
-		CreateChatRoom(w, store, r.Body)
+		CreateChatRoom(w, r, s.store)
 	default:
 		notFound(w, "Invalid HTTP Header")
 	}
 }
 
-func ChatRoomHandler(w http.ResponseWriter, r *http.Request) {
-	roomId, err := getRoomId(r.URL)
-	if err != nil {
-		badRequest(w, err)
-		return
-	}
-
-	store := model.GetChatRoomStore()
-	_, err = getChatRoom(roomId)
-	if err != nil {
-		badRequest(w, err)
-		return
-	}
+func (s *Server) ChatRoomHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
 
 	switch r.Method {
+	case http.MethodGet:
+		GetChatRoom(w, room)
+	case http.MethodPatch:
+		UpdateChatRoom(w, r, room)
 	case http.MethodDelete:
-		DeleteChatRoom(w, store, roomId)
+		DeleteChatRoom(w, r, s.store, room.GetMetadata().Id)
 	default:
 		notFound(w, "Invalid HTTP Method")
 	}
 }
 
-func MembersHandler(w http.ResponseWriter, r *http.Request) {
-	roomId, err := getRoomId(r.URL)
-	if err != nil {
-		badRequest(w, err)
-		return
-	}
-
-	room, err := getChatRoom(roomId)
-	if err != nil {
-		badRequest(w, err)
-		return
-	}
+func (s *Server) MembersHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
 
 	switch r.Method {
 	case http.MethodGet:
-		notFound(w, "List chat room members not implemented")
+		ListMembers(w, room)
 	case http.MethodPost:
-		JoinChatRoom(w, room, r.Body)
+		JoinChatRoom(w, r, room)
 	default:
 		notFound(w, "Invalid HTTP Method")
 	}
-
-	w.WriteHeader(200)
 }
 
-func MemberHandler(w http.ResponseWriter, r *http.Request) {
-	roomId, err := getRoomId(r.URL)
-	if err != nil {
-		badRequest(w, err)
-		return
-	}
+func (s *Server) MemberHandler(w http.ResponseWriter, r *http.Request) {
+	room, tag := roomFromContext(r.Context()), memberTagFromContext(r.Context())
 
-	tag, err := getMemberTag(r.URL)
-	if err != nil {
-		badRequest(w, err)
-		return
+	switch r.Method {
+	case http.MethodDelete:
+		LeaveChatRoom(w, r, room, tag)
+	default:
+		notFound(w, "Invalid HTTP Method")
 	}
+}
 
-	room, err := getChatRoom(roomId)
-	if err != nil {
-		badRequest(w, err)
-		return
+func (s *Server) MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	room, tag := roomFromContext(r.Context()), memberTagFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodPost:
+		PostMessage(w, r, room, tag)
+	default:
+		notFound(w, "Invalid HTTP Method")
 	}
+}
+
+func (s *Server) HeartbeatHandler(w http.ResponseWriter, r *http.Request) {
+	room, tag := roomFromContext(r.Context()), memberTagFromContext(r.Context())
 
 	switch r.Method {
-	case http.MethodDelete:
-		LeaveChatRoom(w, room, tag)
+	case http.MethodPost:
+		Heartbeat(w, r, room, tag)
 	default:
 		notFound(w, "Invalid HTTP Method")
 	}
 }
 
-func MessagesHandler(w http.ResponseWriter, r *http.Request) {
-	roomId, err := getRoomId(r.URL)
+type CreateChatRoomArgs struct {
+	Name    string `json:"name"`
+	Creator string `json:"creator,omitempty"`
+}
+
+type CreateChatRoomResponseBody struct {
+	RoomId  int    `json:"roomId"`
+	RoomUid string `json:"roomUid"`
+}
+
+func CreateChatRoom(w http.ResponseWriter, r *http.Request, store model.MessageProxyStore) {
+	var args CreateChatRoomArgs
+	err := json.NewDecoder(r.Body).Decode(&args)
 	if err != nil {
 		badRequest(w, err)
 		return
 	}
 
-	tag, err := getMemberTag(r.URL)
+	roomId, err := store.AddProxy(r.Context(), args.Name, args.Creator)
 	if err != nil {
 		badRequest(w, err)
 		return
 	}
 
-	room, err := getChatRoom(roomId)
+	room, err := store.GetProxy(roomId)
 	if err != nil {
-		badRequest(w, err)
+		unexpectedError(w, err)
 		return
 	}
 
-	if !room.HasJoined(tag) {
-		badRequest(w, fmt.Errorf(`"%s" hasn't joined room %+v`, tag, *room.GetMetadata()))
-	}
-
-	switch r.Method {
-	case http.MethodPost:
-		PostMessage(w, room, tag, r.Body)
-	default:
-		notFound(w, "Invalid HTTP Method")
+	body, err := json.Marshal(CreateChatRoomResponseBody{roomId, room.GetMetadata().Uid})
+	if err != nil {
+		unexpectedError(w, err)
+		return
 	}
 
-	w.WriteHeader(200)
+	w.Write(body)
 }
 
-func ListChatRooms(w http.ResponseWriter, store model.MessageProxyStore) {
-	metadata := store.GetMetadata()
-	res, err := json.Marshal(metadata)
+func GetChatRoom(w http.ResponseWriter, proxy model.MessageProxy) {
+	body, err := json.Marshal(proxy.GetMetadata())
 	if err != nil {
 		unexpectedError(w, err)
 		return
 	}
 
-	w.Write(res)
-}
-
-type CreateChatRoomArgs struct {
-	Name string `json:"name"`
+	w.Write(body)
 }
 
-type CreateChatRoomResponseBody struct {
-	RoomId int `json:"roomId"`
+// UpdateChatRoomArgs holds the room fields a member may change. Fields left
+// out of the request body are not modified.
+type UpdateChatRoomArgs struct {
+	Tag         string  `json:"tag"`
+	Topic       *string `json:"topic,omitempty"`
+	Description *string `json:"description,omitempty"`
+	Modes       *string `json:"modes,omitempty"`
 }
 
-func CreateChatRoom(w http.ResponseWriter, store model.MessageProxyStore, reqBody io.ReadCloser) {
-	var args CreateChatRoomArgs
-	err := json.NewDecoder(reqBody).Decode(&args)
+func UpdateChatRoom(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy) {
+	var args UpdateChatRoomArgs
+	err := json.NewDecoder(r.Body).Decode(&args)
 	if err != nil {
 		badRequest(w, err)
 		return
 	}
 
-	roomId, err := store.AddProxy(args.Name)
+	err = proxy.Update(r.Context(), args.Tag, model.RoomUpdate{
+		Topic:       args.Topic,
+		Description: args.Description,
+		Modes:       args.Modes,
+	})
 	if err != nil {
 		badRequest(w, err)
 		return
 	}
 
-	body, err := json.Marshal(CreateChatRoomResponseBody{roomId})
-	if err != nil {
-		unexpectedError(w, err)
-		return
-	}
-
-	w.Write(body)
+	GetChatRoom(w, proxy)
 }
 
 type JoinChatRoomArgs struct {
@@ -187,23 +171,26 @@ type JoinChatRoomArgs struct {
 	CallbackURL string `json:"callbackUrl"`
 }
 
-func JoinChatRoom(w http.ResponseWriter, proxy model.MessageProxy, reqBody io.ReadCloser) {
+func JoinChatRoom(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy) {
 	var args JoinChatRoomArgs
-	err := json.NewDecoder(reqBody).Decode(&args)
+	err := json.NewDecoder(r.Body).Decode(&args)
 	if err != nil {
 		badRequest(w, err)
 		return
 	}
 
-	err = proxy.Join(args.Tag, args.CallbackURL)
+	err = proxy.Join(r.Context(), args.Tag, args.CallbackURL)
+	if rejectedMessage(w, r, err) {
+		return
+	}
 	if err != nil {
 		badRequest(w, err)
 		return
 	}
 }
 
-func LeaveChatRoom(w http.ResponseWriter, proxy model.MessageProxy, tag string) {
-	err := proxy.Leave(tag)
+func LeaveChatRoom(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, tag string) {
+	err := proxy.Leave(r.Context(), tag)
 	if err != nil {
 		unexpectedError(w, err)
 		return
@@ -214,23 +201,113 @@ type PostMessageArgs struct {
 	Message string `json:"message"`
 }
 
-func PostMessage(w http.ResponseWriter, proxy model.MessageProxy, memberTag string, reqBody io.ReadCloser) {
+func PostMessage(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, memberTag string) {
+	bs, ok := readMessageBody(w, r)
+	if !ok {
+		return
+	}
+
 	var args PostMessageArgs
-	err := json.NewDecoder(reqBody).Decode(&args)
+	err := json.Unmarshal(bs, &args)
 	if err != nil {
 		badRequest(w, err)
 		return
 	}
 
-	err = proxy.PostMessage(memberTag, args.Message)
+	err = proxy.PostMessage(r.Context(), memberTag, args.Message)
+	if rejectedMessage(w, r, err) {
+		return
+	}
 	if err != nil {
 		unexpectedError(w, err)
 		return
 	}
 }
 
-func DeleteChatRoom(w http.ResponseWriter, store model.MessageProxyStore, id int) {
-	err := store.DeleteProxy(id)
+// readMessageBody reads the body of a request carrying a message, responding
+// with an error if it's too large or not valid UTF-8, which encoding/json
+// would quietly replace with U+FFFD.
+func readMessageBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	bs, err := ioutil.ReadAll(r.Body)
+	if isBodyTooLarge(err) {
+		bodyTooLarge(w, r, 0)
+		return nil, false
+	}
+	if err != nil {
+		badRequest(w, err)
+		return nil, false
+	}
+
+	if !utf8.Valid(bs) {
+		errorWithCode(w, r, 400, model.InvalidUTF8Code, fmt.Errorf("request body is not valid UTF-8"))
+		return nil, false
+	}
+	return bs, true
+}
+
+// rejectedMessage responds with the code of err if it's a
+// model.ValidationError or model.CommandError, reporting whether it was.
+// Messages held back for review are accepted, with the code telling why.
+func rejectedMessage(w http.ResponseWriter, r *http.Request, err error) bool {
+	var invalid *model.ValidationError
+	if errors.As(err, &invalid) {
+		status := 400
+		if invalid.Code == model.MutedCode {
+			status = 403
+		}
+		errorWithCode(w, r, status, invalid.Code, invalid)
+		return true
+	}
+	var held *model.HeldError
+	if errors.As(err, &held) {
+		heldForReview(w, held)
+		return true
+	}
+	var failed *model.CommandError
+	if errors.As(err, &failed) {
+		status := 400
+		if failed.Code == model.ForbiddenCode {
+			status = 403
+		}
+		errorWithCode(w, r, status, failed.Code, failed)
+		return true
+	}
+	return false
+}
+
+func ListMembers(w http.ResponseWriter, proxy model.MessageProxy) {
+	body, err := json.Marshal(proxy.GetMembers())
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	w.Write(body)
+}
+
+type HeartbeatArgs struct {
+	Status model.Presence `json:"status"`
+}
+
+// Heartbeat keeps a member from being marked offline and evicted. The request
+// body is optional, and the status defaults to online.
+func Heartbeat(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, memberTag string) {
+	args := HeartbeatArgs{Status: model.Online}
+	err := json.NewDecoder(r.Body).Decode(&args)
+	if err != nil && err != io.EOF {
+		badRequest(w, err)
+		return
+	}
+
+	err = proxy.Heartbeat(r.Context(), memberTag, args.Status)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+}
+
+func DeleteChatRoom(w http.ResponseWriter, r *http.Request, store model.MessageProxyStore, id int) {
+	err := store.DeleteProxy(r.Context(), id)
 	if err != nil {
 		unexpectedError(w, err)
 		return
@@ -239,29 +316,59 @@ func DeleteChatRoom(w http.ResponseWriter, store model.MessageProxyStore, id int
 
 // Helpers / Validation
 
-func getRoomId(url *url.URL) (int, error) {
+// roomPath is a /api/rooms/... url path split into the reference to a chat
+// room and the path segments following it. Rooms are referenced by integer
+// ID, by UID, or by name using a "by-name/{name}" prefix.
+type roomPath struct {
+	ref    string
+	byName bool
+	rest   []string
+}
+
+func getRoomPath(url *url.URL) (roomPath, error) {
 	urlPathParams := strings.Split(url.Path, "/")
-	if len(urlPathParams) < 4 {
-		return 0, fmt.Errorf("url path doesn't contain room ID")
+	if len(urlPathParams) < 4 || urlPathParams[3] == "" {
+		return roomPath{}, fmt.Errorf("url path doesn't contain room ID")
 	}
-	roomIdStr := urlPathParams[3]
-	roomId, err := strconv.Atoi(roomIdStr)
-	if err != nil {
-		return 0, fmt.Errorf(`chat room ID must be an integer: "%s"`, roomIdStr)
+	if urlPathParams[3] == "by-name" {
+		if len(urlPathParams) < 5 || urlPathParams[4] == "" {
+			return roomPath{}, fmt.Errorf("url path doesn't contain room name")
+		}
+		return roomPath{ref: urlPathParams[4], byName: true, rest: urlPathParams[5:]}, nil
 	}
-	return roomId, nil
+	return roomPath{ref: urlPathParams[3], rest: urlPathParams[4:]}, nil
 }
 
 func getMemberTag(url *url.URL) (string, error) {
-	urlPathParams := strings.Split(url.Path, "/")
-	if len(urlPathParams) < 6 {
-		return "", fmt.Errorf("url path doesn't contain room ID")
+	path, err := getRoomPath(url)
+	if err != nil {
+		return "", err
 	}
-	return urlPathParams[5], nil
+	if len(path.rest) < 2 || path.rest[0] != "members" {
+		return "", fmt.Errorf("url path doesn't contain member tag")
+	}
+	return path.rest[1], nil
 }
 
-func getChatRoom(id int) (model.MessageProxy, error) {
-	store := model.GetChatRoomStore()
+func getChatRoom(store model.MessageProxyStore, url *url.URL) (model.MessageProxy, error) {
+	path, err := getRoomPath(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if path.byName {
+		return store.GetProxyByName(path.ref)
+	}
+
+	id, err := strconv.Atoi(path.ref)
+	if err != nil {
+		room, err := store.GetProxyByUid(path.ref)
+		if err != nil {
+			return nil, fmt.Errorf(`chat room does not exist: "%s"`, path.ref)
+		}
+		return room, nil
+	}
+
 	room, err := store.GetProxy(id)
 	if err != nil {
 		return nil, fmt.Errorf(`chat room does not exist: "%d"`, id)
@@ -269,6 +376,10 @@ func getChatRoom(id int) (model.MessageProxy, error) {
 	return room, nil
 }
 
+func errNotJoined(tag string, proxy model.MessageProxy) error {
+	return fmt.Errorf(`"%s" hasn't joined room %+v`, tag, *proxy.GetMetadata())
+}
+
 // Status Code Helpers
 
 func badRequest(w http.ResponseWriter, err error) {
@@ -281,6 +392,31 @@ func notFound(w http.ResponseWriter, err string) {
 	fmt.Fprint(w, err)
 }
 
+// errorWithCode responds with a JSON error body, including a code clients can
+// tell rejections apart by.
+func errorWithCode(w http.ResponseWriter, r *http.Request, status int, code string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorBody{Error: err.Error(), Code: code, RequestId: requestid.FromContext(r.Context())})
+}
+
+const BodyTooLargeCode = "body_too_large"
+
+// bodyTooLarge responds 413, mentioning max if it's known.
+func bodyTooLarge(w http.ResponseWriter, r *http.Request, max int64) {
+	err := fmt.Errorf("request body too large")
+	if max > 0 {
+		err = fmt.Errorf("request body larger than the maximum of %d bytes", max)
+	}
+	errorWithCode(w, r, 413, BodyTooLargeCode, err)
+}
+
+func tooManyRequests(w http.ResponseWriter, retryAfter int) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(429)
+	fmt.Fprintf(w, "rate limit exceeded, retry in %ds", retryAfter)
+}
+
 func unexpectedError(w http.ResponseWriter, err error) {
 	w.WriteHeader(500)
 	fmt.Fprint(w, err.Error())
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"irc/server/audit"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+func (s *Server) AdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ListAuditEntries(w, r, s.audit)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+func (s *Server) AdminAuditExportHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		ExportAuditLog(w, r, s.audit)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// parseAuditFilter parses the query parameters accepted by the audit log
+// endpoints:
+//
+//	action     action, e.g. member.kick
+//	actor      tag of the member who took the action
+//	target     tag of the member it was taken on
+//	room       ID of the room it was taken in, which may since be deleted
+//	requestId  ID of the request that caused it
+//	from       RFC 3339 time of the first entry
+//	to         RFC 3339 time to read entries until, exclusive
+//	after      ID of the entry to read after, taken from the previous page
+//	limit      page size
+func parseAuditFilter(values url.Values) (audit.Filter, error) {
+	filter := audit.Filter{
+		Action:    values.Get("action"),
+		Actor:     values.Get("actor"),
+		Target:    values.Get("target"),
+		RequestId: values.Get("requestId"),
+	}
+
+	if value := values.Get("room"); value != "" {
+		room, err := strconv.Atoi(value)
+		if err != nil {
+			return filter, fmt.Errorf(`room must be a room ID: "%s"`, value)
+		}
+		filter.Room = &room
+	}
+
+	var err error
+	if filter.From, err = timeParam(values, "from"); err != nil {
+		return filter, err
+	}
+	if filter.To, err = timeParam(values, "to"); err != nil {
+		return filter, err
+	}
+
+	after, err := intParam(values, "after", 0)
+	if err != nil {
+		return filter, err
+	}
+	filter.After = int64(after)
+
+	if filter.Limit, err = intParam(values, "limit", defaultAuditLimit); err != nil {
+		return filter, err
+	}
+	if filter.Limit < 1 || filter.Limit > maxAuditLimit {
+		return filter, fmt.Errorf("limit must be between 1 and %d: %d", maxAuditLimit, filter.Limit)
+	}
+	return filter, nil
+}
+
+// ListAuditEntries writes the entries of the audit log selected by the
+// request's query parameters (see parseAuditFilter), oldest first. When
+// there are more than fit in a page, a Link header points at the next one.
+func ListAuditEntries(w http.ResponseWriter, r *http.Request, log *audit.Log) {
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	entries, more := log.Query(filter)
+	if entries == nil {
+		entries = []audit.Entry{}
+	}
+	res, err := json.Marshal(entries)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	if more {
+		nextUrl := *r.URL
+		values := nextUrl.Query()
+		values.Set("after", strconv.FormatInt(entries[len(entries)-1].Id, 10))
+		nextUrl.RawQuery = values.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextUrl.RequestURI()))
+	}
+
+	w.Write(res)
+}
+
+// ExportAuditLog writes every entry of the audit log selected by the
+// request's query parameters as JSON lines, ignoring limit.
+func ExportAuditLog(w http.ResponseWriter, r *http.Request, log *audit.Log) {
+	filter, err := parseAuditFilter(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	filter.Limit = 0
+
+	entries, _ := log.Query(filter)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	encoder := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := encoder.Encode(e); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"irc/server/model"
+	"irc/server/requestid"
+	"irc/server/transcript"
+	"log"
+	"net/http"
+	"strings"
+)
+
+func (s *Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		ExportChatRoom(w, r, room, s.logger)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// ExportChatRoom streams the room's history as a transcript. It takes the
+// query parameters:
+//
+//	format  json (the default), irclog or html
+//	from    RFC 3339 time of the first entry to export
+//	to      RFC 3339 time to export entries until, exclusive
+//	member  tag of the member exporting, who may export the secret rooms
+//	        they've joined
+//
+// Entries are written in batches, so the transcript is never held in memory
+// as a whole.
+func ExportChatRoom(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, logger *log.Logger) {
+	values := r.URL.Query()
+	format := transcript.JSON
+	if name := values.Get("format"); name != "" {
+		var err error
+		if format, err = transcript.ParseFormat(name); err != nil {
+			badRequest(w, err)
+			return
+		}
+	}
+	from, err := timeParam(values, "from")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	to, err := timeParam(values, "to")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	meta := proxy.GetMetadata()
+	if !proxy.VisibleTo(values.Get("member")) {
+		errorWithCode(w, r, 403, model.ForbiddenCode, fmt.Errorf("only members may export secret room %+v", *meta))
+		return
+	}
+
+	name := strings.TrimPrefix(meta.Name, string(model.ChatRoomNamePrefix))
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, name, format.Extension()))
+
+	writer, err := transcript.NewWriter(format, w, meta.Name)
+	if err == nil {
+		flusher, _ := w.(http.Flusher)
+		err = proxy.ReadHistory(from, to, func(batch []model.Message) error {
+			for _, message := range batch {
+				if err := writer.Write(message); err != nil {
+					return err
+				}
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if err != nil {
+		// The response has started, so the client can only be told by
+		// cutting it short
+		logger.Printf("request_id=%s exporting room %+v: %v", requestid.FromContext(r.Context()), *meta, err)
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"encoding/json"
+	"irc/server/hook"
+	"irc/server/model"
+	"net/http"
+)
+
+func (s *Server) HooksHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		ListHooks(w, r, room, r.URL.Query().Get("tag"))
+	case http.MethodPut:
+		SetHooks(w, r, room)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// SetHooksArgs replaces the hooks of a room, which run in the order given.
+// Tag is the operator replacing them.
+type SetHooksArgs struct {
+	Tag   string      `json:"tag"`
+	Hooks []hook.Spec `json:"hooks"`
+}
+
+func ListHooks(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, tag string) {
+	specs, err := proxy.GetHooks(tag)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	body, err := json.Marshal(specs)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	w.Write(body)
+}
+
+func SetHooks(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy) {
+	var args SetHooksArgs
+	err := json.NewDecoder(r.Body).Decode(&args)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	err = proxy.SetHooks(r.Context(), args.Tag, args.Hooks)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	ListHooks(w, r, proxy, args.Tag)
+}
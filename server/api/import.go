@@ -0,0 +1,106 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"irc/server/model"
+	"irc/server/transcript"
+	"net/http"
+)
+
+func (s *Server) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		ImportTranscript(w, r, s.store)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// ImportResponseBody tells what an import added to a room. Skipped counts
+// the lines of an IRC log that weren't messages or events.
+type ImportResponseBody struct {
+	RoomId  int    `json:"roomId"`
+	RoomUid string `json:"roomUid"`
+	Created bool   `json:"created"`
+	model.ImportResult
+	Skipped int `json:"skipped"`
+}
+
+// ImportTranscript imports the transcript in the request body into a room,
+// creating the room if needed. It takes the query parameters:
+//
+//	room    name of the room
+//	tag     member importing, who must be an operator of the room, or its
+//	        creator; rooms the import creates are created by them
+//	format  irclog (the default) or json
+//
+// Importing the same transcript again adds nothing; see model.ChatRoom.Import.
+func ImportTranscript(w http.ResponseWriter, r *http.Request, store model.MessageProxyStore) {
+	values := r.URL.Query()
+	name, tag := values.Get("room"), values.Get("tag")
+	if name == "" || tag == "" {
+		badRequest(w, fmt.Errorf("room and tag are required"))
+		return
+	}
+	format := transcript.IRCLog
+	if value := values.Get("format"); value != "" {
+		var err error
+		if format, err = transcript.ParseFormat(value); err != nil {
+			badRequest(w, err)
+			return
+		}
+	}
+
+	bs, err := ioutil.ReadAll(r.Body)
+	if isBodyTooLarge(err) {
+		bodyTooLarge(w, r, 0)
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	messages, skipped, err := transcript.Parse(format, bytes.NewReader(bs))
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	// The transcript is parsed first, so that one that can't be doesn't
+	// leave an empty room behind
+	body := ImportResponseBody{Skipped: skipped}
+	room, err := store.GetProxyByName(name)
+	if err != nil {
+		id, err := store.AddProxy(r.Context(), name, tag)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+		if room, err = store.GetProxy(id); err != nil {
+			unexpectedError(w, err)
+			return
+		}
+		body.Created = true
+	}
+
+	body.ImportResult, err = room.Import(tag, messages)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	meta := room.GetMetadata()
+	body.RoomId, body.RoomUid = meta.Id, meta.Uid
+	res, err := json.Marshal(body)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+	w.Write(res)
+}
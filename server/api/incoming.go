@@ -0,0 +1,216 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"irc/server/model"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+func (s *Server) IncomingWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		ListIncomingWebhooks(w, r, room, r.URL.Query().Get("tag"))
+	case http.MethodPost:
+		AddIncomingWebhook(w, r, room)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+func (s *Server) IncomingWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
+
+	id, err := getRoomResourceId(r.URL, "incoming-webhooks")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		RemoveIncomingWebhook(w, r, room, r.URL.Query().Get("tag"), id)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// HookHandler serves the secret URLs of incoming webhooks.
+func (s *Server) HookHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		PostWithWebhook(w, r, s.store, getHookToken(r.URL))
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// AddIncomingWebhookArgs names the webhook, which is the name its messages
+// are posted under. Tag is the operator adding it.
+type AddIncomingWebhookArgs struct {
+	Tag  string `json:"tag"`
+	Name string `json:"name"`
+}
+
+// AddIncomingWebhookResponseBody is the new webhook with its secret URL.
+type AddIncomingWebhookResponseBody struct {
+	model.IncomingWebhook
+	URL string `json:"url"`
+}
+
+func AddIncomingWebhook(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy) {
+	var args AddIncomingWebhookArgs
+	err := json.NewDecoder(r.Body).Decode(&args)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	hook, err := proxy.AddIncomingWebhook(r.Context(), args.Tag, args.Name)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	body, err := json.Marshal(AddIncomingWebhookResponseBody{hook, hookURL(r, hook.Token)})
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	w.Write(body)
+}
+
+func ListIncomingWebhooks(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, tag string) {
+	webhooks, err := proxy.GetIncomingWebhooks(tag)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	body, err := json.Marshal(webhooks)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	w.Write(body)
+}
+
+func RemoveIncomingWebhook(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, tag string, id string) {
+	err := proxy.RemoveIncomingWebhook(r.Context(), tag, id)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+}
+
+// IncomingWebhookArgs is the payload of an incoming webhook, as sent to Slack
+// incoming webhooks. It may be sent as JSON, or as a form with either a text
+// field or a payload field holding the JSON.
+type IncomingWebhookArgs struct {
+	Text string `json:"text"`
+}
+
+func PostWithWebhook(w http.ResponseWriter, r *http.Request, store model.MessageProxyStore, token string) {
+	room, err := store.GetProxyByWebhookToken(token)
+	if err != nil {
+		notFound(w, "Invalid webhook")
+		return
+	}
+
+	args, ok := readIncomingWebhookArgs(w, r)
+	if !ok {
+		return
+	}
+
+	err = room.PostWithWebhook(r.Context(), token, args.Text)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		// The webhook was removed in the meantime
+		notFound(w, "Invalid webhook")
+		return
+	}
+
+	fmt.Fprint(w, "ok")
+}
+
+func readIncomingWebhookArgs(w http.ResponseWriter, r *http.Request) (IncomingWebhookArgs, bool) {
+	var args IncomingWebhookArgs
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		bs, ok := readMessageBody(w, r)
+		if !ok {
+			return args, false
+		}
+		if err := json.Unmarshal(bs, &args); err != nil {
+			badRequest(w, err)
+			return args, false
+		}
+		return args, true
+	}
+
+	err := r.ParseForm()
+	if isBodyTooLarge(err) {
+		bodyTooLarge(w, r, 0)
+		return args, false
+	}
+	if err != nil {
+		badRequest(w, err)
+		return args, false
+	}
+
+	payload := r.PostForm.Get("payload")
+	if payload == "" {
+		args.Text = r.PostForm.Get("text")
+		return args, true
+	}
+	if !utf8.ValidString(payload) {
+		errorWithCode(w, r, 400, model.InvalidUTF8Code, fmt.Errorf("payload is not valid UTF-8"))
+		return args, false
+	}
+	if err := json.Unmarshal([]byte(payload), &args); err != nil {
+		badRequest(w, err)
+		return args, false
+	}
+	return args, true
+}
+
+const hooksPath = "/hooks/"
+
+func getHookToken(url *url.URL) string {
+	return strings.TrimPrefix(url.Path, hooksPath)
+}
+
+// hookURL is the secret URL of the incoming webhook with the given token, on
+// the host the request was made to.
+func hookURL(r *http.Request, token string) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return (&url.URL{Scheme: scheme, Host: r.Host, Path: hooksPath + token}).String()
+}
+
+// tokenFromPath identifies incoming webhooks to rateLimit.
+func tokenFromPath(r *http.Request) string {
+	return "hook:" + getHookToken(r.URL)
+}
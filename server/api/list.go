@@ -0,0 +1,283 @@
+package api
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"irc/server/model"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// listChatRoomsQuery holds the query parameters accepted by ListChatRooms:
+//
+//	q           case-insensitive substring of the room name
+//	prefix      case-insensitive prefix of the room name
+//	minMembers  minimum number of members
+//	maxMembers  maximum number of members
+//	mode        modes the room must have set, e.g. "ms"
+//	tag         member that must have joined the room
+//	sort        one of name, size, activity or created; "-" prefix sorts descending
+//	limit       page size
+//	cursor      opaque cursor taken from the previous page's "next" Link
+type listChatRoomsQuery struct {
+	q          string
+	prefix     string
+	minMembers int
+	maxMembers int
+	mode       string
+	tag        string
+	sort       string
+	desc       bool
+	limit      int
+	cursor     *listCursor
+}
+
+// listCursor marks the last room of a page, so the next page starts right
+// after it even if rooms were created or deleted in between.
+type listCursor struct {
+	Sort string  `json:"s"`
+	Key  sortKey `json:"k"`
+	Id   int     `json:"i"`
+}
+
+type sortKey struct {
+	Str string `json:"s,omitempty"`
+	Num int64  `json:"n,omitempty"`
+}
+
+func parseListChatRoomsQuery(values url.Values) (listChatRoomsQuery, error) {
+	query := listChatRoomsQuery{
+		q:          strings.ToLower(values.Get("q")),
+		prefix:     strings.ToLower(strings.TrimPrefix(values.Get("prefix"), string(model.ChatRoomNamePrefix))),
+		maxMembers: -1,
+		mode:       values.Get("mode"),
+		tag:        values.Get("tag"),
+		limit:      defaultListLimit,
+	}
+
+	var err error
+	if query.minMembers, err = intParam(values, "minMembers", 0); err != nil {
+		return query, err
+	}
+	if query.maxMembers, err = intParam(values, "maxMembers", -1); err != nil {
+		return query, err
+	}
+	if query.limit, err = intParam(values, "limit", defaultListLimit); err != nil {
+		return query, err
+	}
+	if query.limit < 1 || query.limit > maxListLimit {
+		return query, fmt.Errorf("limit must be between 1 and %d: %d", maxListLimit, query.limit)
+	}
+
+	query.sort = values.Get("sort")
+	if strings.HasPrefix(query.sort, "-") {
+		query.sort = query.sort[1:]
+		query.desc = true
+	}
+	switch query.sort {
+	case "", "name", "size", "activity", "created":
+	default:
+		return query, fmt.Errorf("unknown sort order: %q", values.Get("sort"))
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		query.cursor, err = decodeListCursor(cursor)
+		if err != nil || query.cursor.Sort != values.Get("sort") {
+			return query, fmt.Errorf("invalid cursor: %q", cursor)
+		}
+	}
+	return query, nil
+}
+
+func intParam(values url.Values, name string, fallback int) (int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf(`%s must be an integer: "%s"`, name, value)
+	}
+	return i, nil
+}
+
+func (q listChatRoomsQuery) matches(meta model.ProxyMetadata, store model.MessageProxyStore) bool {
+	name := strings.ToLower(strings.TrimPrefix(meta.Name, string(model.ChatRoomNamePrefix)))
+	if !strings.Contains(name, q.q) || !strings.HasPrefix(name, q.prefix) {
+		return false
+	}
+	if meta.MemberCount < q.minMembers || (q.maxMembers >= 0 && meta.MemberCount > q.maxMembers) {
+		return false
+	}
+	for _, mode := range q.mode {
+		if !strings.ContainsRune(meta.Modes, mode) {
+			return false
+		}
+	}
+	if q.tag != "" {
+		room, err := store.GetProxy(meta.Id)
+		if err != nil || !room.HasJoined(q.tag) {
+			return false
+		}
+	}
+	return true
+}
+
+func (q listChatRoomsQuery) sortKey(meta model.ProxyMetadata) sortKey {
+	switch q.sort {
+	case "name":
+		name, _ := model.NormalizeChatRoomName(meta.Name)
+		return sortKey{Str: name}
+	case "size":
+		return sortKey{Num: int64(meta.MemberCount)}
+	case "activity":
+		return sortKey{Num: meta.LastActivityAt.UnixNano()}
+	case "created":
+		return sortKey{Num: meta.CreatedAt.UnixNano()}
+	}
+	return sortKey{}
+}
+
+// less orders rooms by the query's sort key, breaking ties by room ID.
+func (q listChatRoomsQuery) less(aKey sortKey, aId int, bKey sortKey, bId int) bool {
+	if q.desc {
+		aKey, aId, bKey, bId = bKey, bId, aKey, aId
+	}
+	if aKey.Str != bKey.Str {
+		return aKey.Str < bKey.Str
+	}
+	if aKey.Num != bKey.Num {
+		return aKey.Num < bKey.Num
+	}
+	return aId < bId
+}
+
+// apply filters, sorts and pages metadata, returning the cursor for the next
+// page if there is one.
+func (q listChatRoomsQuery) apply(metadata []model.ProxyMetadata, store model.MessageProxyStore) ([]model.ProxyMetadata, *listCursor) {
+	rooms := make([]model.ProxyMetadata, 0, len(metadata))
+	keys := make(map[int]sortKey, len(metadata))
+	for _, meta := range metadata {
+		if !q.matches(meta, store) {
+			continue
+		}
+		key := q.sortKey(meta)
+		if q.cursor != nil && !q.less(q.cursor.Key, q.cursor.Id, key, meta.Id) {
+			continue
+		}
+		keys[meta.Id] = key
+		rooms = append(rooms, meta)
+	}
+
+	sort.Slice(rooms, func(i, j int) bool {
+		return q.less(keys[rooms[i].Id], rooms[i].Id, keys[rooms[j].Id], rooms[j].Id)
+	})
+
+	if len(rooms) <= q.limit {
+		return rooms, nil
+	}
+	rooms = rooms[:q.limit]
+	last := rooms[len(rooms)-1]
+	sortParam := q.sort
+	if q.desc {
+		sortParam = "-" + sortParam
+	}
+	return rooms, &listCursor{Sort: sortParam, Key: keys[last.Id], Id: last.Id}
+}
+
+func (c *listCursor) encode() string {
+	bs, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func decodeListCursor(cursor string) (*listCursor, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var c listCursor
+	if err := json.Unmarshal(bs, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+type ChatRoomSummary struct {
+	Id          int    `json:"id"`
+	Uid         string `json:"uid"`
+	Name        string `json:"name"`
+	Topic       string `json:"topic,omitempty"`
+	Modes       string `json:"modes,omitempty"`
+	MemberCount int    `json:"memberCount"`
+}
+
+// ListChatRooms writes the rooms matching the request's query parameters (see
+// listChatRoomsQuery). When there are more rooms than fit in a page, a Link
+// header points at the next one. Responses carry an ETag so polling clients
+// can send If-None-Match and get a 304 when nothing changed.
+func ListChatRooms(w http.ResponseWriter, r *http.Request, store model.MessageProxyStore) {
+	query, err := parseListChatRoomsQuery(r.URL.Query())
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	metadata, next := query.apply(store.GetMetadata(), store)
+	summaries := make([]ChatRoomSummary, 0, len(metadata))
+	for _, meta := range metadata {
+		summary := ChatRoomSummary{
+			Id:          meta.Id,
+			Uid:         meta.Uid,
+			Name:        meta.Name,
+			Modes:       meta.Modes,
+			MemberCount: meta.MemberCount,
+		}
+		if meta.Topic != nil {
+			summary.Topic = meta.Topic.Text
+		}
+		summaries = append(summaries, summary)
+	}
+
+	res, err := json.Marshal(summaries)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	if next != nil {
+		nextUrl := *r.URL
+		values := nextUrl.Query()
+		values.Set("cursor", next.encode())
+		nextUrl.RawQuery = values.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextUrl.RequestURI()))
+	}
+
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(append([]byte(w.Header().Get("Link")), res...)))
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Write(res)
+}
+
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
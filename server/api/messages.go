@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"irc/server/model"
+	"net/http"
+	"strconv"
+)
+
+func (s *Server) RoomMessageHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
+
+	rawId, err := getRoomResourceId(r.URL, "messages")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	id, err := strconv.ParseInt(rawId, 10, 64)
+	if err != nil {
+		badRequest(w, fmt.Errorf(`message ID must be a number: "%s"`, rawId))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		GetMessage(w, r, room, id)
+	case http.MethodPatch:
+		EditMessage(w, r, room, id)
+	case http.MethodDelete:
+		DeleteMessage(w, r, room, r.URL.Query().Get("tag"), id)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// GetMessage writes a message with its earlier versions, if it was edited.
+// As with exports, only members may read the messages of secret rooms, so
+// the member query parameter names the member reading.
+func GetMessage(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, id int64) {
+	if !proxy.VisibleTo(r.URL.Query().Get("member")) {
+		errorWithCode(w, r, 403, model.ForbiddenCode, fmt.Errorf("only members may read the messages of secret room %+v", *proxy.GetMetadata()))
+		return
+	}
+
+	message, err := proxy.GetMessage(id)
+	if err != nil {
+		notFound(w, err.Error())
+		return
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	w.Write(body)
+}
+
+// EditMessageArgs replaces the text of a message. Tag is the member editing
+// it: its author, or an operator.
+type EditMessageArgs struct {
+	Tag     string `json:"tag"`
+	Message string `json:"message"`
+}
+
+func EditMessage(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, id int64) {
+	bs, ok := readMessageBody(w, r)
+	if !ok {
+		return
+	}
+
+	var args EditMessageArgs
+	err := json.Unmarshal(bs, &args)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	err = proxy.EditMessage(r.Context(), args.Tag, id, args.Message)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+}
+
+func DeleteMessage(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, tag string, id int64) {
+	err := proxy.DeleteMessage(r.Context(), tag, id)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"irc/server/metrics"
+	"net/http"
+	"strconv"
+)
+
+// serverMetrics are the collectors the server records requests with.
+type serverMetrics struct {
+	httpRequests        *metrics.CounterVec
+	httpRequestDuration *metrics.HistogramVec
+	rateLimited         *metrics.CounterVec
+}
+
+// registerMetrics registers the server's request metrics, and metrics
+// describing its store.
+func (s *Server) registerMetrics() {
+	s.metrics = serverMetrics{
+		httpRequests: s.registry.NewCounterVec(
+			"irc_http_requests_total",
+			"HTTP requests served, by route, method and status code.",
+			"route", "method", "status",
+		),
+		httpRequestDuration: s.registry.NewHistogramVec(
+			"irc_http_request_duration_seconds",
+			"Time taken to serve HTTP requests, by route and method.",
+			metrics.DefaultBuckets,
+			"route", "method",
+		),
+		rateLimited: s.registry.NewCounterVec(
+			"irc_rate_limited_total",
+			"Requests rejected for exceeding a rate limit, by action and what was limited (ip or tag).",
+			"action", "key",
+		),
+	}
+
+	s.registry.NewCounterFunc("irc_messages_posted_total", "Messages posted.", func() float64 {
+		return float64(s.store.MessagesPosted())
+	})
+	s.registry.NewGaugeFunc("irc_rooms", "Chat rooms that currently exist.", func() float64 {
+		return float64(len(s.store.GetMetadata()))
+	})
+	s.registry.NewGaugeFunc("irc_members", "Members across all chat rooms.", func() float64 {
+		members := 0
+		for _, meta := range s.store.GetMetadata() {
+			members += meta.MemberCount
+		}
+		return float64(members)
+	})
+}
+
+// instrument records request counts and latencies, labelled with route.
+func (s *Server) instrument(route string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := s.clock()
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			s.metrics.httpRequests.Inc(route, r.Method, strconv.Itoa(recorder.code()))
+			s.metrics.httpRequestDuration.Observe(s.clock().Sub(start).Seconds(), route, r.Method)
+		})
+	}
+}
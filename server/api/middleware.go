@@ -0,0 +1,209 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"irc/server/model"
+	"irc/server/requestid"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Middleware wraps a handler with behaviour shared between routes.
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps handler with middleware, the first of which sees requests first.
+func Chain(handler http.Handler, middleware ...Middleware) http.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// RequestIDs makes sure every request has an ID, taken from the X-Request-ID
+// header if the client sent one. The ID is echoed in the response and stored
+// in the request context, from which it's forwarded on callbacks.
+func RequestIDs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if id == "" {
+			id = requestid.New()
+		}
+
+		w.Header().Set(requestid.Header, id)
+		next.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	})
+}
+
+// AccessLog logs a line of key=value pairs for every request served.
+func AccessLog(logger *log.Logger, clock func() time.Time) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := clock()
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			logger.Printf("request_id=%s method=%s path=%q status=%d duration=%s remote=%s",
+				requestid.FromContext(r.Context()), r.Method, redactPath(r.URL.Path), recorder.code(), clock().Sub(start), r.RemoteAddr)
+		})
+	}
+}
+
+// redactPath keeps the secret tokens of incoming webhooks out of logs.
+func redactPath(path string) string {
+	if strings.HasPrefix(path, hooksPath) {
+		return hooksPath + "REDACTED"
+	}
+	return path
+}
+
+type errorBody struct {
+	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// RecoverPanics turns a panicking handler into a 500 response with a JSON
+// error body, logging the panic and its stack trace.
+func RecoverPanics(logger *log.Logger) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				id := requestid.FromContext(r.Context())
+				logger.Printf("request_id=%s panic=%q\n%s", id, err, debug.Stack())
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(errorBody{Error: "internal server error", RequestId: id})
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// LimitBody responds 413 Request Entity Too Large to requests whose
+// Content-Length is over max bytes. Bodies of unknown length are cut off at
+// max, so handlers fail to read them; see isBodyTooLarge.
+func LimitBody(max int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > max {
+				bodyTooLarge(w, r, max)
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, max)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// isBodyTooLarge reports whether err came from reading past the limit set by
+// LimitBody. http.MaxBytesReader doesn't give the error its own type.
+func isBodyTooLarge(err error) bool {
+	return err != nil && err.Error() == "http: request body too large"
+}
+
+type contextKey int
+
+const (
+	roomContextKey contextKey = iota
+	memberTagContextKey
+)
+
+// resolveRoom looks up the chat room referenced by the request path, and
+// stores it in the request context for roomFromContext.
+func (s *Server) resolveRoom(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		room, err := getChatRoom(s.store, r.URL)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), roomContextKey, room)))
+	})
+}
+
+// resolveMember stores the member tag in the request path in the request
+// context for memberTagFromContext.
+func resolveMember(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tag, err := getMemberTag(r.URL)
+		if err != nil {
+			badRequest(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), memberTagContextKey, tag)))
+	})
+}
+
+// requireJoined rejects requests from members who haven't joined the room.
+// Must come after resolveRoom and resolveMember.
+func requireJoined(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		room, tag := roomFromContext(r.Context()), memberTagFromContext(r.Context())
+		if !room.HasJoined(tag) {
+			badRequest(w, errNotJoined(tag, room))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func roomFromContext(ctx context.Context) model.MessageProxy {
+	return ctx.Value(roomContextKey).(model.MessageProxy)
+}
+
+func memberTagFromContext(ctx context.Context) string {
+	return ctx.Value(memberTagContextKey).(string)
+}
+
+// statusRecorder remembers the status code a handler responded with.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(bs []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(bs)
+}
+
+// Flush lets handlers that stream their responses flush them through the
+// recorder.
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) code() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"irc/server/model"
+	"net/http"
+	"strconv"
+)
+
+func (s *Server) HeldMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		ListHeldMessages(w, r, room, r.URL.Query().Get("tag"))
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+func (s *Server) HeldMessageHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
+
+	rawId, err := getRoomResourceId(r.URL, "held")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+	id, err := strconv.ParseInt(rawId, 10, 64)
+	if err != nil {
+		badRequest(w, fmt.Errorf(`held message ID must be a number: "%s"`, rawId))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		DecideHeldMessage(w, r, room, id)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// HeldResponseBody answers a message held back for review, with the ID it
+// has in the room's moderation queue. Its poster is sent a
+// model.MessageApprovedEvent or model.MessageRejectedEvent with the ID as
+// HeldId once an operator decides on it.
+type HeldResponseBody struct {
+	Code   string `json:"code"`
+	Id     int64  `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// heldForReview responds 202 to a message held back for review: accepted,
+// but not posted yet.
+func heldForReview(w http.ResponseWriter, held *model.HeldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(202)
+	json.NewEncoder(w).Encode(HeldResponseBody{Code: model.HeldCode, Id: held.Id, Reason: held.Reason})
+}
+
+func ListHeldMessages(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, tag string) {
+	held, err := proxy.HeldMessages(tag)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	body, err := json.Marshal(held)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	w.Write(body)
+}
+
+// Decisions on held messages
+const (
+	Approve = "approve"
+	Reject  = "reject"
+)
+
+// DecideHeldMessageArgs approves or rejects a held message. Tag is the
+// operator deciding, and Reason, which the poster is told, is optional.
+type DecideHeldMessageArgs struct {
+	Tag      string `json:"tag"`
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func DecideHeldMessage(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, id int64) {
+	var args DecideHeldMessageArgs
+	err := json.NewDecoder(r.Body).Decode(&args)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	switch args.Decision {
+	case Approve:
+		err = proxy.Approve(r.Context(), args.Tag, id)
+	case Reject:
+		err = proxy.Reject(r.Context(), args.Tag, id, args.Reason)
+	default:
+		err = fmt.Errorf("decision must be %q or %q: %q", Approve, Reject, args.Decision)
+	}
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+}
@@ -0,0 +1,141 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"irc/server/metrics"
+	"irc/server/ratelimit"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimits configures how often rooms may be created, joined and posted to,
+// where imports count as creating rooms and edits and deletions as posts.
+// Each limit applies separately to every client IP and every member tag.
+type RateLimits struct {
+	CreateRoom ratelimit.Limit
+	Join       ratelimit.Limit
+	Post       ratelimit.Limit
+}
+
+// rateLimiter limits an action by client IP and by member tag, counting the
+// requests it rejects in limited.
+type rateLimiter struct {
+	action  string
+	byIP    *ratelimit.Limiter
+	byTag   *ratelimit.Limiter
+	limited *metrics.CounterVec
+}
+
+func newRateLimiter(action string, limit ratelimit.Limit, clock func() time.Time, limited *metrics.CounterVec) *rateLimiter {
+	return &rateLimiter{
+		action:  action,
+		byIP:    ratelimit.NewLimiter(limit, clock),
+		byTag:   ratelimit.NewLimiter(limit, clock),
+		limited: limited,
+	}
+}
+
+// rateLimit rejects requests with the given method once the client's IP, or
+// the member tag identify returns for them, runs out of tokens. Responses
+// carry the state of whichever bucket has fewest tokens left.
+func rateLimit(method string, limiter *rateLimiter, identify func(r *http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != method {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			state := limiter.byIP.Allow(clientIP(r))
+			if !state.Allowed {
+				limiter.limited.Inc(limiter.action, "ip")
+			} else if tag := identify(r); tag != "" {
+				tagState := limiter.byTag.Allow(tag)
+				if !tagState.Allowed {
+					limiter.limited.Inc(limiter.action, "tag")
+				}
+				if !tagState.Allowed || tagState.Remaining < state.Remaining {
+					state = tagState
+				}
+			}
+
+			if state.Limit > 0 {
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(state.Limit))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(state.Remaining))
+				w.Header().Set("X-RateLimit-Reset", strconv.Itoa(seconds(state.Reset)))
+			}
+			if !state.Allowed {
+				tooManyRequests(w, seconds(state.RetryAfter))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// seconds rounds d up to whole seconds, as used by Retry-After.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Identifiers for rateLimit
+
+func creatorFromBody(r *http.Request) string {
+	var args CreateChatRoomArgs
+	peekBody(r, &args)
+	return args.Creator
+}
+
+func tagFromBody(r *http.Request) string {
+	var args JoinChatRoomArgs
+	peekBody(r, &args)
+	return args.Tag
+}
+
+func editorFromBody(r *http.Request) string {
+	var args EditMessageArgs
+	peekBody(r, &args)
+	return args.Tag
+}
+
+func tagFromQuery(r *http.Request) string {
+	return r.URL.Query().Get("tag")
+}
+
+func tagFromPath(r *http.Request) string {
+	tag, _ := getMemberTag(r.URL)
+	return tag
+}
+
+// peekBody decodes the JSON request body into v, if it can, leaving the body
+// to be read again by the handler. If reading fails, the handler sees the
+// same error once it has read what was read here.
+func peekBody(r *http.Request, v interface{}) {
+	bs, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		r.Body = readCloser{io.MultiReader(bytes.NewReader(bs), r.Body), r.Body}
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(bs))
+	json.Unmarshal(bs, v)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"irc/server/health"
+	"irc/server/metrics"
+	"net/http"
+	"strings"
+)
+
+// route maps a path pattern to a handler. Patterns are matched segment by
+// segment; "{room}" matches a room ID or UID, or "by-name/{name}", and
+// "{tag}" and "{id}" match a member tag and any other ID.
+type route struct {
+	pattern    string
+	handler    http.Handler
+	middleware []Middleware
+}
+
+func (s *Server) routes() http.Handler {
+	routes := []route{
+		{"/api/rooms", http.HandlerFunc(s.ChatRoomsHandler), []Middleware{rateLimit(http.MethodPost, s.limiters.createRoom, creatorFromBody)}},
+		{"/api/rooms/{room}", http.HandlerFunc(s.ChatRoomHandler), []Middleware{s.resolveRoom}},
+		{"/api/rooms/{room}/members", http.HandlerFunc(s.MembersHandler), []Middleware{rateLimit(http.MethodPost, s.limiters.join, tagFromBody), s.resolveRoom}},
+		{"/api/rooms/{room}/members/{tag}", http.HandlerFunc(s.MemberHandler), []Middleware{s.resolveRoom, resolveMember}},
+		{"/api/rooms/{room}/members/{tag}/messages", http.HandlerFunc(s.MessagesHandler), []Middleware{rateLimit(http.MethodPost, s.limiters.post, tagFromPath), s.resolveRoom, resolveMember, requireJoined}},
+		{"/api/rooms/{room}/members/{tag}/heartbeat", http.HandlerFunc(s.HeartbeatHandler), []Middleware{s.resolveRoom, resolveMember}},
+		{"/api/rooms/{room}/messages/{id}", http.HandlerFunc(s.RoomMessageHandler), []Middleware{rateLimit(http.MethodPatch, s.limiters.post, editorFromBody), rateLimit(http.MethodDelete, s.limiters.post, tagFromQuery), s.resolveRoom}},
+		{"/api/rooms/{room}/export", http.HandlerFunc(s.ExportHandler), []Middleware{s.resolveRoom}},
+		{"/api/rooms/{room}/webhooks", http.HandlerFunc(s.WebhooksHandler), []Middleware{s.resolveRoom}},
+		{"/api/rooms/{room}/webhooks/{id}", http.HandlerFunc(s.WebhookHandler), []Middleware{s.resolveRoom}},
+		{"/api/rooms/{room}/incoming-webhooks", http.HandlerFunc(s.IncomingWebhooksHandler), []Middleware{s.resolveRoom}},
+		{"/api/rooms/{room}/incoming-webhooks/{id}", http.HandlerFunc(s.IncomingWebhookHandler), []Middleware{s.resolveRoom}},
+		{"/api/rooms/{room}/hooks", http.HandlerFunc(s.HooksHandler), []Middleware{s.resolveRoom}},
+		{"/api/rooms/{room}/held", http.HandlerFunc(s.HeldMessagesHandler), []Middleware{s.resolveRoom}},
+		{"/api/rooms/{room}/held/{id}", http.HandlerFunc(s.HeldMessageHandler), []Middleware{s.resolveRoom}},
+		{"/api/rooms/{room}/spam", http.HandlerFunc(s.SpamHandler), []Middleware{s.resolveRoom}},
+		{"/api/search", http.HandlerFunc(s.SearchHandler), nil},
+		{"/api/import", http.HandlerFunc(s.ImportHandler), []Middleware{rateLimit(http.MethodPost, s.limiters.createRoom, tagFromQuery)}},
+		{"/admin/members", http.HandlerFunc(s.AdminMembersHandler), []Middleware{s.requireAdmin}},
+		{"/admin/members/{tag}", http.HandlerFunc(s.AdminMemberHandler), []Middleware{s.requireAdmin}},
+		{"/admin/rooms", http.HandlerFunc(s.AdminRoomsHandler), []Middleware{s.requireAdmin}},
+		{"/admin/bans", http.HandlerFunc(s.AdminBansHandler), []Middleware{s.requireAdmin}},
+		{"/admin/bans/{tag}", http.HandlerFunc(s.AdminBanHandler), []Middleware{s.requireAdmin}},
+		{"/admin/notices", http.HandlerFunc(s.AdminNoticesHandler), []Middleware{s.requireAdmin}},
+		{"/admin/audit", http.HandlerFunc(s.AdminAuditHandler), []Middleware{s.requireAdmin}},
+		{"/admin/audit/export", http.HandlerFunc(s.AdminAuditExportHandler), []Middleware{s.requireAdmin}},
+		{"/hooks/{id}", http.HandlerFunc(s.HookHandler), []Middleware{rateLimit(http.MethodPost, s.limiters.post, tokenFromPath)}},
+		{"/metrics", metrics.Handler(s.registry), nil},
+		{"/healthz", health.Handler(health.NewRegistry()), nil},
+		{"/readyz", health.Handler(s.checks), nil},
+	}
+
+	router := &router{}
+	for _, route := range routes {
+		middleware := append([]Middleware{s.instrument(route.pattern)}, route.middleware...)
+		route.handler = Chain(route.handler, middleware...)
+		router.routes = append(router.routes, route)
+	}
+
+	return Chain(router, RequestIDs, AccessLog(s.logger, s.clock), RecoverPanics(s.logger), LimitBody(s.maxBody))
+}
+
+type router struct {
+	routes []route
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, route := range rt.routes {
+		if route.matches(r.URL.Path) {
+			route.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	notFound(w, "Invalid path")
+}
+
+func (rt route) matches(path string) bool {
+	patternSegments := strings.Split(rt.pattern, "/")
+	pathSegments := strings.Split(path, "/")
+
+	i := 0
+	for _, segment := range patternSegments {
+		if i >= len(pathSegments) {
+			return false
+		}
+		switch segment {
+		case "{room}":
+			if pathSegments[i] == "by-name" {
+				i += 1
+				if i >= len(pathSegments) {
+					return false
+				}
+			}
+			fallthrough
+		case "{tag}", "{id}":
+			if pathSegments[i] == "" {
+				return false
+			}
+		default:
+			if pathSegments[i] != segment {
+				return false
+			}
+		}
+		i += 1
+	}
+	return i == len(pathSegments)
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"irc/server/model"
+	"irc/server/search"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchQuery holds the query parameters accepted by Search:
+//
+//	q       words that must all appear in a message, or "quoted phrases"
+//	room    room ID, UID or name to search, instead of every room
+//	from    tag of the member who posted the message
+//	after   RFC 3339 time the message was posted after
+//	before  RFC 3339 time the message was posted before
+//	member  tag of the member searching, who may search the secret rooms
+//	        they've joined
+//	limit   page size
+//	cursor  opaque cursor taken from the previous page's "next" Link
+type searchQuery struct {
+	search.Query
+	member string
+}
+
+func parseSearchQuery(values url.Values, store model.MessageProxyStore) (searchQuery, error) {
+	query := searchQuery{member: values.Get("member")}
+	query.From = values.Get("from")
+
+	var err error
+	if query.Clauses, err = search.ParseQuery(values.Get("q")); err != nil {
+		return query, err
+	}
+
+	if ref := values.Get("room"); ref != "" {
+		room, err := findChatRoom(store, ref)
+		if err != nil {
+			return query, err
+		}
+		query.Rooms = []int{room.GetMetadata().Id}
+	}
+
+	if query.After, err = timeParam(values, "after"); err != nil {
+		return query, err
+	}
+	if query.Before, err = timeParam(values, "before"); err != nil {
+		return query, err
+	}
+
+	if query.Limit, err = intParam(values, "limit", defaultSearchLimit); err != nil {
+		return query, err
+	}
+	if query.Limit < 1 || query.Limit > maxSearchLimit {
+		return query, fmt.Errorf("limit must be between 1 and %d: %d", maxSearchLimit, query.Limit)
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		query.Cursor, err = decodeSearchCursor(cursor)
+		if err != nil {
+			return query, fmt.Errorf("invalid cursor: %q", cursor)
+		}
+	}
+	return query, nil
+}
+
+func timeParam(values url.Values, name string) (time.Time, error) {
+	value := values.Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf(`%s must be an RFC 3339 time: "%s"`, name, value)
+	}
+	return t, nil
+}
+
+// findChatRoom looks up a room by ID, name or UID, as given in a query
+// parameter.
+func findChatRoom(store model.MessageProxyStore, ref string) (model.MessageProxy, error) {
+	if id, err := strconv.Atoi(ref); err == nil {
+		return store.GetProxy(id)
+	}
+	if room, err := store.GetProxyByName(ref); err == nil {
+		return room, nil
+	}
+	room, err := store.GetProxyByUid(ref)
+	if err != nil {
+		return nil, fmt.Errorf(`chat room does not exist: "%s"`, ref)
+	}
+	return room, nil
+}
+
+func encodeSearchCursor(c *search.Cursor) string {
+	bs, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func decodeSearchCursor(cursor string) (*search.Cursor, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+	var c search.Cursor
+	if err := json.Unmarshal(bs, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// SearchResult is a message that matched a search. Highlights are the byte
+// ranges of Snippet, [start, end), that matched the query.
+type SearchResult struct {
+	RoomId     int       `json:"roomId"`
+	RoomName   string    `json:"roomName"`
+	Id         int64     `json:"id"`
+	Tag        string    `json:"tag"`
+	Message    string    `json:"message"`
+	Time       time.Time `json:"time"`
+	Snippet    string    `json:"snippet"`
+	Highlights [][2]int  `json:"highlights"`
+}
+
+func (s *Server) SearchHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		Search(w, r, s.store)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// Search writes the messages matching the request's query parameters (see
+// searchQuery), newest first. When there are more than fit in a page, a Link
+// header points at the next one.
+func Search(w http.ResponseWriter, r *http.Request, store model.MessageProxyStore) {
+	query, err := parseSearchQuery(r.URL.Query(), store)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	results, next := store.Search(query.Query, query.member)
+	body := make([]SearchResult, 0, len(results))
+	for _, result := range results {
+		room, err := store.GetProxy(result.Room)
+		if err != nil {
+			// Deleted since it was searched
+			continue
+		}
+		body = append(body, SearchResult{
+			RoomId:     result.Room,
+			RoomName:   room.GetMetadata().Name,
+			Id:         result.Id,
+			Tag:        result.Tag,
+			Message:    result.Text,
+			Time:       result.Time,
+			Snippet:    result.Snippet,
+			Highlights: result.Highlights,
+		})
+	}
+
+	res, err := json.Marshal(body)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	if next != nil {
+		nextUrl := *r.URL
+		values := nextUrl.Query()
+		values.Set("cursor", encodeSearchCursor(next))
+		nextUrl.RawQuery = values.Encode()
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, nextUrl.RequestURI()))
+	}
+
+	w.Write(res)
+}
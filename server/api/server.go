@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"irc/server/audit"
+	"irc/server/delivery"
+	"irc/server/health"
+	"irc/server/metrics"
+	"irc/server/model"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server serves the chat API for a store. It's an http.Handler, so it can be
+// mounted in another server or exercised with httptest, or run standalone
+// with Start and Shutdown.
+type Server struct {
+	store      model.MessageProxyStore
+	dispatcher *delivery.Dispatcher
+	clock      func() time.Time
+	logger     *log.Logger
+	presence   *model.PresenceConfig
+	notice     string
+	limits     RateLimits
+	maxBody    int64
+	adminToken string
+	audit      *audit.Log
+
+	handler    http.Handler
+	registry   *metrics.Registry
+	metrics    serverMetrics
+	checks     *health.Registry
+	limiters   struct{ createRoom, join, post *rateLimiter }
+	httpServer *http.Server
+	done       chan struct{}
+	stopOnce   sync.Once
+}
+
+type Option func(*Server)
+
+// WithDispatcher sets the dispatcher the store delivers events with, so that
+// Shutdown can stop it.
+func WithDispatcher(dispatcher *delivery.Dispatcher) Option {
+	return func(s *Server) {
+		s.dispatcher = dispatcher
+	}
+}
+
+// WithClock sets the source of the current time.
+func WithClock(clock func() time.Time) Option {
+	return func(s *Server) {
+		s.clock = clock
+	}
+}
+
+// WithLogger sets where access logs and errors are written. Defaults to stderr.
+func WithLogger(logger *log.Logger) Option {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithPresence makes Start monitor the presence of room members.
+func WithPresence(config model.PresenceConfig) Option {
+	return func(s *Server) {
+		s.presence = &config
+	}
+}
+
+// WithShutdownNotice makes Shutdown send a notice with the given message to
+// every member before it stops.
+func WithShutdownNotice(message string) Option {
+	return func(s *Server) {
+		s.notice = message
+	}
+}
+
+// WithRateLimits limits how often clients may create rooms, join rooms and
+// post messages. Nothing is limited by default.
+func WithRateLimits(limits RateLimits) Option {
+	return func(s *Server) {
+		s.limits = limits
+	}
+}
+
+// WithMaxBodySize caps the size of request bodies, in bytes. Defaults to
+// DefaultMaxBodySize.
+func WithMaxBodySize(max int64) Option {
+	return func(s *Server) {
+		s.maxBody = max
+	}
+}
+
+const DefaultMaxBodySize = 1 << 20
+
+// WithAuditLog sets the audit log the admin API records its actions in, and
+// reads. It should be the log the store's rooms record theirs in.
+func WithAuditLog(log *audit.Log) Option {
+	return func(s *Server) {
+		s.audit = log
+	}
+}
+
+// WithAdminToken enables the /admin API, for requests carrying token as a
+// bearer token. Members' tags aren't credentials, so the admin API has its
+// own.
+func WithAdminToken(token string) Option {
+	return func(s *Server) {
+		s.adminToken = token
+	}
+}
+
+// WithMetrics sets the registry the server registers its metrics with and
+// serves on /metrics, so that components such as the dispatcher can register
+// theirs alongside. Defaults to a registry of the server's own.
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(s *Server) {
+		s.registry = registry
+	}
+}
+
+// WithCheck adds a readiness check, reported on /readyz alongside those of
+// the store and dispatcher.
+func WithCheck(name string, checker health.Checker) Option {
+	return func(s *Server) {
+		s.checks.Register(name, checker)
+	}
+}
+
+func NewServer(store model.MessageProxyStore, opts ...Option) *Server {
+	s := &Server{
+		store:    store,
+		clock:    time.Now,
+		logger:   log.New(os.Stderr, "", log.LstdFlags),
+		registry: metrics.NewRegistry(),
+		checks:   health.NewRegistry(),
+		maxBody:  DefaultMaxBodySize,
+		done:     make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.registerMetrics()
+	s.limiters.createRoom = newRateLimiter("create_room", s.limits.CreateRoom, s.clock, s.metrics.rateLimited)
+	s.limiters.join = newRateLimiter("join", s.limits.Join, s.clock, s.metrics.rateLimited)
+	s.limiters.post = newRateLimiter("post", s.limits.Post, s.clock, s.metrics.rateLimited)
+
+	s.registerChecks()
+	s.handler = s.routes()
+	s.httpServer = &http.Server{Handler: s.handler, ErrorLog: s.logger}
+	return s
+}
+
+// registerChecks registers the server's own readiness check, which fails
+// once it's shutting down, and those of its components.
+func (s *Server) registerChecks() {
+	s.checks.Register("server", health.CheckFunc(func(ctx context.Context) error {
+		select {
+		case <-s.done:
+			return fmt.Errorf("shutting down")
+		default:
+			return nil
+		}
+	}))
+	if registrant, ok := s.store.(health.Registrant); ok {
+		registrant.RegisterChecks(s.checks)
+	}
+	if s.dispatcher != nil {
+		s.dispatcher.RegisterChecks(s.checks)
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.handler.ServeHTTP(w, r)
+}
+
+// Start listens on addr and serves requests until Shutdown is called, when
+// it returns http.ErrServerClosed.
+func (s *Server) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if s.presence != nil {
+		go s.monitorPresence(*s.presence)
+	}
+	s.logger.Printf("listening on %s", listener.Addr())
+	return s.httpServer.Serve(listener)
+}
+
+// Shutdown stops the server gracefully: it stops accepting requests, waits
+// for in-flight ones to finish, sends the shutdown notice if there is one,
+// then waits for the dispatcher to deliver everything queued before closing
+// it. It gives up waiting when ctx is done, and returns an error unless
+// every step completed.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		close(s.done)
+	})
+
+	var errs []string
+	if err := s.httpServer.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Sprintf("stopping http server: %v", err))
+	}
+
+	if s.notice != "" {
+		if err := s.store.PostNotice(ctx, s.notice); err != nil {
+			errs = append(errs, fmt.Sprintf("sending shutdown notice: %v", err))
+		}
+	}
+
+	if s.dispatcher != nil {
+		if err := s.dispatcher.Drain(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("draining deliveries: %v", err))
+		}
+		if err := s.dispatcher.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("closing dispatcher: %v", err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("shutdown incomplete: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// monitorPresence checks the presence of every room's members until Shutdown.
+func (s *Server) monitorPresence(config model.PresenceConfig) {
+	ticker := time.NewTicker(config.Timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.store.CheckPresence(config)
+		case <-s.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package api
+
+import (
+	"encoding/json"
+	"irc/server/model"
+	"net/http"
+)
+
+func (s *Server) SpamHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		ListSpamDecisions(w, r, room, r.URL.Query().Get("tag"))
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// ListSpamDecisions writes the messages the room's spam rules caught, and
+// what was done about them, for the operator with the given tag.
+func ListSpamDecisions(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, tag string) {
+	decisions, err := proxy.SpamDecisions(tag)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	body, err := json.Marshal(decisions)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	w.Write(body)
+}
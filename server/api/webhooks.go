@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"irc/server/model"
+	"irc/server/webhook"
+	"net/http"
+	"net/url"
+)
+
+func (s *Server) WebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
+
+	switch r.Method {
+	case http.MethodGet:
+		ListWebhooks(w, r, room, r.URL.Query().Get("tag"))
+	case http.MethodPost:
+		AddWebhook(w, r, room)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+func (s *Server) WebhookHandler(w http.ResponseWriter, r *http.Request) {
+	room := roomFromContext(r.Context())
+
+	id, err := getRoomResourceId(r.URL, "webhooks")
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodDelete:
+		RemoveWebhook(w, r, room, r.URL.Query().Get("tag"), id)
+	default:
+		notFound(w, "Invalid HTTP Method")
+	}
+}
+
+// AddWebhookArgs describes an outgoing webhook. Tag is the operator adding
+// it, and Template is only given with the template format.
+type AddWebhookArgs struct {
+	Tag      string         `json:"tag"`
+	URL      string         `json:"url"`
+	Format   webhook.Format `json:"format"`
+	Template string         `json:"template,omitempty"`
+}
+
+func AddWebhook(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy) {
+	var args AddWebhookArgs
+	err := json.NewDecoder(r.Body).Decode(&args)
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	hook, err := proxy.AddWebhook(r.Context(), args.Tag, args.URL, args.Format, args.Template)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	body, err := json.Marshal(hook)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	w.Write(body)
+}
+
+func ListWebhooks(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, tag string) {
+	webhooks, err := proxy.GetWebhooks(tag)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+
+	body, err := json.Marshal(webhooks)
+	if err != nil {
+		unexpectedError(w, err)
+		return
+	}
+
+	w.Write(body)
+}
+
+func RemoveWebhook(w http.ResponseWriter, r *http.Request, proxy model.MessageProxy, tag string, id string) {
+	err := proxy.RemoveWebhook(r.Context(), tag, id)
+	if rejectedMessage(w, r, err) {
+		return
+	}
+	if err != nil {
+		badRequest(w, err)
+		return
+	}
+}
+
+// getRoomResourceId returns the ID following the resource segment of a
+// /api/rooms/{room}/{resource}/{id} url path.
+func getRoomResourceId(url *url.URL, resource string) (string, error) {
+	path, err := getRoomPath(url)
+	if err != nil {
+		return "", err
+	}
+	if len(path.rest) < 2 || path.rest[0] != resource {
+		return "", fmt.Errorf("url path doesn't contain an ID after %q", resource)
+	}
+	return path.rest[1], nil
+}
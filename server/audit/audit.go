@@ -0,0 +1,224 @@
+// Package audit keeps an append-only record of who did what to rooms and
+// their members, for the server's administrators.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"irc/server/requestid"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Actions recorded in Entry.Action. Those of rooms and members are recorded
+// by rooms; the admin actions by the admin API, alongside the room actions
+// they cause, which share their request ID.
+const (
+	RoomCreate  = "room.create"
+	RoomDelete  = "room.delete"
+	RoomUpdate  = "room.update"
+	RoomMode    = "room.mode"
+	MemberJoin  = "member.join"
+	MemberLeave = "member.leave"
+	// MemberKick is recorded when Actor kicks Target out of a room.
+	MemberKick   = "member.kick"
+	MemberInvite = "member.invite"
+	// MemberRole is recorded when Actor makes Target an operator, or no
+	// longer one.
+	MemberRole = "member.role"
+	// MemberNick is recorded when Actor changes its tag to Target.
+	MemberNick = "member.nick"
+	// MemberMute is recorded when Target is muted for spamming.
+	MemberMute = "member.mute"
+	// MessageApprove and MessageReject are recorded when Actor decides on
+	// a message Target posted that was held back for review.
+	MessageApprove = "message.approve"
+	MessageReject  = "message.reject"
+	// MessageEdit and MessageDelete are recorded when Actor, an operator,
+	// edits or deletes a message Target posted.
+	MessageEdit   = "message.edit"
+	MessageDelete = "message.delete"
+	// WebhookAdd and WebhookRemove are recorded when Actor adds or removes
+	// an outgoing or incoming webhook of a room.
+	WebhookAdd    = "webhook.add"
+	WebhookRemove = "webhook.remove"
+	AdminRemove   = "admin.remove"
+	AdminBan      = "admin.ban"
+	AdminUnban    = "admin.unban"
+	AdminNotice   = "admin.notice"
+)
+
+// Room identifies the room an entry is about.
+type Room struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Entry records an action. Actor is the member who took it, if a member
+// did; actions of the admin API and of the server itself, like evicting
+// members that went quiet, have none. Before and After hold the state the
+// action changed, as JSON.
+type Entry struct {
+	Id        int64           `json:"id"`
+	Time      time.Time       `json:"time"`
+	Action    string          `json:"action"`
+	Actor     string          `json:"actor,omitempty"`
+	Target    string          `json:"target,omitempty"`
+	Room      *Room           `json:"room,omitempty"`
+	RequestId string          `json:"requestId,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+}
+
+// State encodes the state an action changed for Entry.Before or
+// Entry.After.
+func State(v interface{}) json.RawMessage {
+	bs, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return bs
+}
+
+type Config struct {
+	// MaxEntries caps how many entries are kept in memory for Query, the
+	// oldest being forgotten first.
+	MaxEntries int
+	// Writer, if set, is where every entry is appended as a line of JSON,
+	// so that the log outlives the entries kept in memory. Optional.
+	Writer io.Writer
+	// Clock is the source of the time of entries. Defaults to time.Now.
+	Clock func() time.Time
+	// Logger is where errors writing to Writer are logged. Defaults to
+	// stderr.
+	Logger *log.Logger
+}
+
+const DefaultMaxEntries = 10000
+
+// Log is an append-only log of entries, numbered from 1 in the order they
+// were recorded. It's safe for concurrent use, and a nil Log records nothing.
+type Log struct {
+	config  Config
+	encoder *json.Encoder
+
+	mu      sync.Mutex
+	entries []Entry
+	lastId  int64
+}
+
+func NewLog(config Config) *Log {
+	if config.MaxEntries <= 0 {
+		config.MaxEntries = DefaultMaxEntries
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+	if config.Logger == nil {
+		config.Logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	l := &Log{config: config}
+	if config.Writer != nil {
+		l.encoder = json.NewEncoder(config.Writer)
+	}
+	return l
+}
+
+// Record appends an entry, numbering it, and stamping it with the time and
+// the ID of the request in ctx. It returns the entry as recorded. Actions
+// are taken whether or not they can be written to Config.Writer, so errors
+// doing so are only logged.
+func (l *Log) Record(ctx context.Context, e Entry) Entry {
+	if l == nil {
+		return e
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastId += 1
+	e.Id = l.lastId
+	e.Time = l.config.Clock()
+	e.RequestId = requestid.FromContext(ctx)
+
+	l.entries = append(l.entries, e)
+	if excess := len(l.entries) - l.config.MaxEntries; excess > 0 {
+		l.entries = append([]Entry(nil), l.entries[excess:]...)
+	}
+
+	if l.encoder != nil {
+		if err := l.encoder.Encode(e); err != nil {
+			l.config.Logger.Printf("request_id=%s writing audit log entry %d: %v", e.RequestId, e.Id, err)
+		}
+	}
+	return e
+}
+
+// Filter selects entries. Zero fields select every entry: empty strings any
+// value, a nil Room any room, and zero times leave that end of the range
+// open.
+type Filter struct {
+	Action    string
+	Actor     string
+	Target    string
+	Room      *int
+	RequestId string
+	// From and To select entries recorded at or after From and before To.
+	From time.Time
+	To   time.Time
+	// After selects entries numbered after it, to read the log a page at a
+	// time.
+	After int64
+	// Limit caps the number of entries selected, with 0 for no limit.
+	Limit int
+}
+
+func (f Filter) matches(e Entry) bool {
+	switch {
+	case e.Id <= f.After:
+		return false
+	case f.Action != "" && e.Action != f.Action:
+		return false
+	case f.Actor != "" && e.Actor != f.Actor:
+		return false
+	case f.Target != "" && e.Target != f.Target:
+		return false
+	case f.Room != nil && (e.Room == nil || e.Room.Id != *f.Room):
+		return false
+	case f.RequestId != "" && e.RequestId != f.RequestId:
+		return false
+	case e.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && !e.Time.Before(f.To):
+		return false
+	}
+	return true
+}
+
+// Query returns the entries kept in memory that filter selects, oldest
+// first, and whether there are more beyond filter.Limit.
+func (l *Log) Query(filter Filter) ([]Entry, bool) {
+	if l == nil {
+		return nil, false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []Entry{}
+	for _, e := range l.entries {
+		if !filter.matches(e) {
+			continue
+		}
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			return entries, true
+		}
+		entries = append(entries, e)
+	}
+	return entries, false
+}
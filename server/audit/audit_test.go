@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"irc/server/requestid"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var at = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func ids(entries []Entry) []int64 {
+	out := []int64{}
+	for _, e := range entries {
+		out = append(out, e.Id)
+	}
+	return out
+}
+
+func TestLog(t *testing.T) {
+	now := at
+	var written bytes.Buffer
+	log := NewLog(Config{MaxEntries: 4, Writer: &written, Clock: func() time.Time { return now }})
+	ctx := requestid.NewContext(context.Background(), "req-1")
+	room := 1
+
+	log.Record(ctx, Entry{Action: RoomCreate, Actor: "alice", Room: &Room{Id: 0, Name: "general"}})
+	now = now.Add(time.Minute)
+	log.Record(context.Background(), Entry{Action: MemberJoin, Actor: "alice", Target: "alice", Room: &Room{Id: 1, Name: "random"}})
+	now = now.Add(time.Minute)
+	e := log.Record(ctx, Entry{Action: RoomMode, Actor: "alice", Room: &Room{Id: 1, Name: "random"}, Before: State(map[string]string{"modes": ""}), After: State(map[string]string{"modes": "nt"})})
+	assert.Equal(t, int64(3), e.Id)
+	assert.Equal(t, "req-1", e.RequestId)
+	assert.Equal(t, `{"modes":"nt"}`, string(e.After))
+
+	t.Run("filters", func(t *testing.T) {
+		all, more := log.Query(Filter{})
+		assert.Equal(t, []int64{1, 2, 3}, ids(all))
+		assert.False(t, more)
+
+		filtered, _ := log.Query(Filter{RequestId: "req-1"})
+		assert.Equal(t, []int64{1, 3}, ids(filtered))
+		filtered, _ = log.Query(Filter{Room: &room, Action: MemberJoin})
+		assert.Equal(t, []int64{2}, ids(filtered))
+		filtered, _ = log.Query(Filter{Actor: "bob"})
+		assert.Equal(t, []int64{}, ids(filtered))
+		filtered, _ = log.Query(Filter{From: at.Add(time.Minute), To: at.Add(2 * time.Minute)})
+		assert.Equal(t, []int64{2}, ids(filtered))
+
+		page, more := log.Query(Filter{Limit: 2})
+		assert.Equal(t, []int64{1, 2}, ids(page))
+		assert.True(t, more)
+		page, more = log.Query(Filter{Limit: 2, After: 2})
+		assert.Equal(t, []int64{3}, ids(page))
+		assert.False(t, more)
+	})
+
+	t.Run("forgets the oldest entries but writes them all", func(t *testing.T) {
+		log.Record(ctx, Entry{Action: MemberLeave})
+		log.Record(ctx, Entry{Action: RoomDelete})
+		all, _ := log.Query(Filter{})
+		assert.Equal(t, []int64{2, 3, 4, 5}, ids(all))
+
+		lines := strings.Split(strings.TrimSpace(written.String()), "\n")
+		assert.Len(t, lines, 5)
+		var first Entry
+		assert.Nil(t, json.Unmarshal([]byte(lines[0]), &first))
+		assert.Equal(t, RoomCreate, first.Action)
+		assert.Equal(t, "general", first.Room.Name)
+	})
+
+	t.Run("nil logs record nothing", func(t *testing.T) {
+		var log *Log
+		log.Record(ctx, Entry{Action: RoomCreate})
+		entries, _ := log.Query(Filter{})
+		assert.Empty(t, entries)
+	})
+}
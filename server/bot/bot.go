@@ -0,0 +1,136 @@
+// Package bot runs chat bots in-process. Bots join rooms as members without
+// a callback URL, receive their events through a model.Sink, and reply by
+// posting messages like any other member.
+package bot
+
+import (
+	"context"
+	"fmt"
+	"irc/server/model"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Bot is a room member living in the server.
+type Bot interface {
+	// Tag is the member tag the bot joins rooms as.
+	Tag() string
+	model.Sink
+}
+
+// Join makes bot a member of room.
+func Join(room model.MessageProxy, bot Bot) error {
+	return room.JoinSink(bot.Tag(), bot)
+}
+
+// JoinAll returns a store option that makes bot join every room created.
+func JoinAll(bot Bot) model.StoreOption {
+	return model.WithSink(bot.Tag(), bot)
+}
+
+// CommandPrefix starts messages that are commands, like "!help".
+const CommandPrefix = "!"
+
+// Command is a command sent to a bot, e.g. "!seen alice" from bob is
+// Command{Name: "seen", Args: []string{"alice"}, Tag: "bob"}.
+type Command struct {
+	Name string
+	Args []string
+	// Tag is the member who sent the command.
+	Tag  string
+	Room model.MessageProxy
+}
+
+// ParseCommand parses a message as a command, if it is one.
+func ParseCommand(message string) (name string, args []string, ok bool) {
+	rest := strings.TrimPrefix(message, CommandPrefix)
+	if rest == message || rest == "" || unicode.IsSpace(rune(rest[0])) {
+		return "", nil, false
+	}
+	fields := strings.Fields(rest)
+	return strings.ToLower(fields[0]), fields[1:], true
+}
+
+// CommandFunc handles a command, returning the reply to post, if any.
+type CommandFunc func(ctx context.Context, cmd Command) (string, error)
+
+// ObserverFunc is called with every event in the rooms a Router is in,
+// before any command is handled.
+type ObserverFunc func(ctx context.Context, room model.MessageProxy, event model.CallbackBody)
+
+// Router is a Bot that routes commands to their handlers, and answers !help
+// with the commands it knows.
+type Router struct {
+	tag       string
+	commands  map[string]command
+	observers []ObserverFunc
+}
+
+type command struct {
+	help    string
+	handler CommandFunc
+}
+
+func NewRouter(tag string) *Router {
+	r := &Router{tag: tag, commands: make(map[string]command)}
+	r.Handle("help", "lists the commands I know", r.help)
+	return r
+}
+
+func (r *Router) Tag() string {
+	return r.tag
+}
+
+// Handle routes the named command to handler. help describes the command in
+// answers to !help.
+func (r *Router) Handle(name string, help string, handler CommandFunc) {
+	r.commands[strings.ToLower(name)] = command{help, handler}
+}
+
+// Observe has observer called with every event, for bots that keep track of
+// what goes on in a room.
+func (r *Router) Observe(observer ObserverFunc) {
+	r.observers = append(r.observers, observer)
+}
+
+func (r *Router) Receive(ctx context.Context, room model.MessageProxy, event model.CallbackBody) {
+	for _, observe := range r.observers {
+		observe(ctx, room, event)
+	}
+
+	if event.Type != model.MessageEvent {
+		return
+	}
+	name, args, ok := ParseCommand(event.Message)
+	if !ok {
+		return
+	}
+	command, ok := r.commands[name]
+	if !ok {
+		// Other bots in the room may know it
+		return
+	}
+
+	reply, err := command.handler(ctx, Command{Name: name, Args: args, Tag: event.Tag, Room: room})
+	if err != nil {
+		reply = fmt.Sprintf("%s: %s failed: %v", event.Tag, name, err)
+	}
+	if reply != "" {
+		room.PostMessage(ctx, r.tag, reply)
+	}
+}
+
+func (r *Router) help(ctx context.Context, cmd Command) (string, error) {
+	names := make([]string, 0, len(r.commands))
+	for name := range r.commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s%s: %s", CommandPrefix, name, r.commands[name].help))
+	}
+	return strings.Join(lines, "; "), nil
+}
@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"context"
+	"irc/server/model"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// listener is a member recording what's said in a room.
+type listener chan model.CallbackBody
+
+func (l listener) Receive(ctx context.Context, room model.MessageProxy, event model.CallbackBody) {
+	if event.Type == model.MessageEvent {
+		l <- event
+	}
+}
+
+func (l listener) reply(t *testing.T) string {
+	t.Helper()
+	select {
+	case event := <-l:
+		return event.Message
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a reply")
+		return ""
+	}
+}
+
+// roomWith returns a room with bot in it, alice, and a listener for what's
+// said.
+func roomWith(t *testing.T, bot Bot) (*model.ChatRoom, listener) {
+	room := model.EmptyChatRoom(0, "room0")
+	l := make(listener, 10)
+	assert.Nil(t, Join(room, bot))
+	assert.Nil(t, room.Join(context.Background(), "alice", "alice-callback"))
+	assert.Nil(t, room.JoinSink("listener", l))
+	return room, l
+}
+
+func say(room *model.ChatRoom, l listener, tag string, message string) {
+	room.PostMessage(context.Background(), tag, message)
+	<-l
+}
+
+func TestParseCommand(t *testing.T) {
+	name, args, ok := ParseCommand("!Seen  alice ")
+	assert.True(t, ok)
+	assert.Equal(t, "seen", name)
+	assert.Equal(t, []string{"alice"}, args)
+
+	for _, message := range []string{"seen alice", "!", "! seen", ""} {
+		_, _, ok := ParseCommand(message)
+		assert.False(t, ok, message)
+	}
+}
+
+func TestRouter(t *testing.T) {
+	t.Run("routes commands", func(t *testing.T) {
+		r := NewRouter("bot")
+		r.Handle("ping", "answers pong", func(ctx context.Context, cmd Command) (string, error) {
+			return cmd.Tag + ": pong", nil
+		})
+		room, l := roomWith(t, r)
+
+		say(room, l, "alice", "!ping")
+		assert.Equal(t, "alice: pong", l.reply(t))
+
+		say(room, l, "alice", "!help")
+		assert.Equal(t, "!help: lists the commands I know; !ping: answers pong", l.reply(t))
+	})
+
+	t.Run("ignores unknown commands and chatter", func(t *testing.T) {
+		r := NewRouter("bot")
+		room, l := roomWith(t, r)
+
+		say(room, l, "alice", "!unknown")
+		say(room, l, "alice", "hello")
+		say(room, l, "alice", "!help")
+		assert.Equal(t, "!help: lists the commands I know", l.reply(t))
+	})
+}
+
+func TestBuiltinBots(t *testing.T) {
+	t.Run("echo", func(t *testing.T) {
+		room, l := roomWith(t, NewEchoBot("echobot"))
+
+		say(room, l, "alice", "!echo hello  there")
+		assert.Equal(t, "alice: hello there", l.reply(t))
+
+		say(room, l, "alice", "!echo")
+		assert.Equal(t, "alice: echo failed: usage: !echo <text>", l.reply(t))
+	})
+
+	t.Run("uptime", func(t *testing.T) {
+		now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+		clock := func() time.Time { return now }
+		b := NewUptimeBot("uptimebot", clock)
+		room, l := roomWith(t, b)
+		now = now.Add(90 * time.Minute)
+
+		say(room, l, "alice", "!uptime")
+		assert.Equal(t, "up 1h30m0s, since 2021-01-01T00:00:00Z", l.reply(t))
+	})
+
+	t.Run("seen", func(t *testing.T) {
+		room, l := roomWith(t, NewSeenBot("seenbot", time.Now))
+		room.Join(context.Background(), "bob", "bob-callback")
+
+		say(room, l, "alice", "!seen bob")
+		assert.Equal(t, "alice: bob is here, but hasn't said anything yet", l.reply(t))
+
+		say(room, l, "bob", "hi all")
+		say(room, l, "alice", "!seen bob")
+		assert.Equal(t, "alice: bob was last seen 0s ago, saying: hi all", l.reply(t))
+
+		say(room, l, "alice", "!seen carol")
+		assert.Equal(t, "alice: I haven't seen carol", l.reply(t))
+	})
+}
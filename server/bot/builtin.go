@@ -0,0 +1,103 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"irc/server/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NewEchoBot answers "!echo <text>" by repeating the text back to whoever
+// sent it.
+func NewEchoBot(tag string) *Router {
+	r := NewRouter(tag)
+	r.Handle("echo", "repeats what you say", func(ctx context.Context, cmd Command) (string, error) {
+		if len(cmd.Args) == 0 {
+			return "", fmt.Errorf("usage: %secho <text>", CommandPrefix)
+		}
+		return fmt.Sprintf("%s: %s", cmd.Tag, strings.Join(cmd.Args, " ")), nil
+	})
+	return r
+}
+
+// NewUptimeBot answers "!uptime" with how long the server has been running,
+// counted from when the bot is created.
+func NewUptimeBot(tag string, clock func() time.Time) *Router {
+	start := clock()
+	r := NewRouter(tag)
+	r.Handle("uptime", "says how long the server has been up", func(ctx context.Context, cmd Command) (string, error) {
+		return fmt.Sprintf("up %s, since %s", clock().Sub(start).Round(time.Second), start.UTC().Format(time.RFC3339)), nil
+	})
+	return r
+}
+
+// seenBot remembers when each member of each room last posted or left.
+type seenBot struct {
+	clock func() time.Time
+
+	mu   sync.Mutex
+	seen map[seenKey]sighting
+}
+
+type seenKey struct {
+	room string
+	tag  string
+}
+
+type sighting struct {
+	at      time.Time
+	message string
+	left    bool
+}
+
+// NewSeenBot answers "!seen <tag>" with when the member last posted in the
+// room, or left it.
+func NewSeenBot(tag string, clock func() time.Time) *Router {
+	b := &seenBot{clock: clock, seen: make(map[seenKey]sighting)}
+	r := NewRouter(tag)
+	r.Observe(b.observe)
+	r.Handle("seen", "says when someone last spoke, e.g. !seen alice", b.command)
+	return r
+}
+
+func (b *seenBot) observe(ctx context.Context, room model.MessageProxy, event model.CallbackBody) {
+	key := seenKey{room.GetMetadata().Uid, event.Tag}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch event.Type {
+	case model.MessageEvent:
+		b.seen[key] = sighting{at: b.clock(), message: event.Message}
+	case model.LeaveEvent:
+		b.seen[key] = sighting{at: b.clock(), left: true}
+	}
+}
+
+func (b *seenBot) command(ctx context.Context, cmd Command) (string, error) {
+	if len(cmd.Args) != 1 {
+		return "", fmt.Errorf("usage: %sseen <tag>", CommandPrefix)
+	}
+	tag := cmd.Args[0]
+	if tag == cmd.Tag {
+		return fmt.Sprintf("%s: you're right here", cmd.Tag), nil
+	}
+
+	b.mu.Lock()
+	s, ok := b.seen[seenKey{cmd.Room.GetMetadata().Uid, tag}]
+	b.mu.Unlock()
+
+	ago := b.clock().Sub(s.at).Round(time.Second)
+	switch {
+	case !ok && cmd.Room.HasJoined(tag):
+		return fmt.Sprintf("%s: %s is here, but hasn't said anything yet", cmd.Tag, tag), nil
+	case !ok:
+		return fmt.Sprintf("%s: I haven't seen %s", cmd.Tag, tag), nil
+	case s.left:
+		return fmt.Sprintf("%s: %s left %s ago", cmd.Tag, tag, ago), nil
+	default:
+		return fmt.Sprintf("%s: %s was last seen %s ago, saying: %s", cmd.Tag, tag, ago, s.message), nil
+	}
+}
@@ -0,0 +1,94 @@
+// Command irc-import imports IRC logs and JSON transcripts into the rooms of
+// a running server, through its /api/import endpoint:
+//
+//	irc-import -room general -tag alice general.log
+//
+// Files ending in .json or .jsonl are imported as JSON transcripts, and
+// anything else as IRC logs, unless -format says otherwise. "-" reads from
+// standard input. Importing the same file again adds nothing.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"irc/server/api"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	server = flag.String("server", "http://localhost:8080", "URL of the server to import into")
+	room   = flag.String("room", "", "name of the room to import into, which is created if needed")
+	tag    = flag.String("tag", "", "member importing: an operator of the room, or its creator")
+	format = flag.String("format", "", "format of the files: irclog or json (default: guessed from their names)")
+)
+
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+	if *room == "" || *tag == "" || flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: irc-import -room <room> -tag <tag> [-server <url>] [-format irclog|json] <file>...")
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, name := range flag.Args() {
+		result, err := importFile(name)
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: imported %d entries into room %d, skipped %d duplicates and %d other lines\n",
+			name, result.Imported, result.RoomId, result.Duplicates, result.Skipped)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func importFile(name string) (api.ImportResponseBody, error) {
+	var body io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return api.ImportResponseBody{}, err
+		}
+		defer f.Close()
+		body = f
+	}
+
+	values := url.Values{"room": {*room}, "tag": {*tag}, "format": {formatOf(name)}}
+	res, err := http.Post(strings.TrimRight(*server, "/")+"/api/import?"+values.Encode(), "text/plain; charset=utf-8", body)
+	if err != nil {
+		return api.ImportResponseBody{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(res.Body)
+		return api.ImportResponseBody{}, fmt.Errorf("%s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	var result api.ImportResponseBody
+	err = json.NewDecoder(res.Body).Decode(&result)
+	return result, err
+}
+
+func formatOf(name string) string {
+	if *format != "" {
+		return *format
+	}
+	switch filepath.Ext(name) {
+	case ".json", ".jsonl":
+		return "json"
+	default:
+		return "irclog"
+	}
+}
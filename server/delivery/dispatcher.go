@@ -0,0 +1,413 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"irc/server/health"
+	"irc/server/metrics"
+	"irc/server/requestid"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Recipient identifies a member's outbound queue. Deliveries to the same
+// recipient are made one at a time, in the order they were dispatched.
+type Recipient struct {
+	Tag         string `json:"tag"`
+	CallbackURL string `json:"callbackUrl"`
+}
+
+// Message is a body queued for delivery, along with the ID of the request
+// that caused it, which is forwarded to the callback.
+type Message struct {
+	Body      []byte `json:"body"`
+	RequestId string `json:"requestId,omitempty"`
+}
+
+type Config struct {
+	// RetryInterval is how long to wait before retrying a failed delivery.
+	RetryInterval time.Duration
+	// MaxQueueLength caps each recipient's queue. When full, the oldest
+	// message is dropped to make room for the new one.
+	MaxQueueLength int
+	// Timeout bounds a single delivery attempt.
+	Timeout time.Duration
+	// Spool persists queued messages so they survive a restart. Optional.
+	Spool Spool
+	// DroppedNotice builds the message telling a recipient how many messages
+	// were dropped from its queue. It's delivered ahead of the next message,
+	// unless it's nil, for recipients that wouldn't understand it.
+	DroppedNotice func(r Recipient, dropped int) []byte
+	// MaxBacklog is how many messages may be queued across all recipients
+	// before the dispatcher reports itself unhealthy.
+	MaxBacklog int
+	// Metrics is the registry the dispatcher registers its metrics with.
+	// Optional; without it they're kept but not exposed.
+	Metrics *metrics.Registry
+}
+
+const (
+	DefaultRetryInterval  = 5 * time.Second
+	DefaultMaxQueueLength = 1000
+	DefaultTimeout        = 10 * time.Second
+	DefaultMaxBacklog     = 10000
+)
+
+type Dispatcher struct {
+	config  Config
+	client  *http.Client
+	metrics dispatcherMetrics
+	done    chan struct{}
+	// closeOnce closes done, so Close may be called more than once.
+	closeOnce sync.Once
+
+	mu     sync.Mutex
+	seq    uint64
+	queues map[Recipient]*queue
+}
+
+type queue struct {
+	items   []item
+	dropped int
+	running bool
+	// dirty is set when the queue has changed since it was last saved to the
+	// spool. The recipient's goroutine saves it, so Dispatch never waits on
+	// the spool.
+	dirty bool
+	// saving serializes saves of the queue, so an older copy never
+	// overwrites a newer one.
+	saving sync.Mutex
+	// failures counts the delivery attempts that failed in a row
+	failures  int
+	lastError string
+}
+
+type item struct {
+	seq uint64
+	Message
+}
+
+// NewDispatcher returns a Dispatcher, resuming delivery of any messages left
+// in config.Spool.
+func NewDispatcher(config Config) *Dispatcher {
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = DefaultRetryInterval
+	}
+	if config.MaxQueueLength <= 0 {
+		config.MaxQueueLength = DefaultMaxQueueLength
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxBacklog <= 0 {
+		config.MaxBacklog = DefaultMaxBacklog
+	}
+	if config.Metrics == nil {
+		config.Metrics = metrics.NewRegistry()
+	}
+
+	d := &Dispatcher{
+		config:  config,
+		client:  &http.Client{Timeout: config.Timeout},
+		metrics: newDispatcherMetrics(config.Metrics),
+		done:    make(chan struct{}),
+		queues:  make(map[Recipient]*queue),
+	}
+	d.resume()
+	return d
+}
+
+// Dispatch queues body for delivery to the recipient's callback URL and
+// returns without waiting for it to be delivered. Cancelling ctx doesn't
+// cancel the delivery; only its request ID is used.
+func (d *Dispatcher) Dispatch(ctx context.Context, tag string, callbackUrl string, body []byte) {
+	r := Recipient{tag, callbackUrl}
+	message := Message{body, requestid.FromContext(ctx)}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	q, ok := d.queues[r]
+	if !ok {
+		q = &queue{}
+		d.queues[r] = q
+	}
+
+	d.seq += 1
+	q.items = append(q.items, item{d.seq, message})
+	if len(q.items) > d.config.MaxQueueLength {
+		q.items = q.items[1:]
+		q.dropped += 1
+		d.metrics.dropped.Inc()
+	}
+	q.dirty = true
+
+	if !q.running {
+		q.running = true
+		go d.run(r, q)
+	}
+}
+
+// Probe sends body to the callback URL straight away, bypassing any queued
+// messages, and returns whether it was delivered.
+func (d *Dispatcher) Probe(ctx context.Context, callbackUrl string, body []byte) error {
+	return d.send(Recipient{CallbackURL: callbackUrl}, Message{body, requestid.FromContext(ctx)})
+}
+
+// Pending returns the number of messages queued for a recipient.
+func (d *Dispatcher) Pending(tag string, callbackUrl string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if q, ok := d.queues[Recipient{tag, callbackUrl}]; ok {
+		return len(q.items)
+	}
+	return 0
+}
+
+// QueueStatus describes how deliveries to a recipient are going. Failures
+// counts the attempts that failed in a row, the last of them for LastError;
+// both are cleared once a delivery succeeds.
+type QueueStatus struct {
+	Pending   int    `json:"pending"`
+	Dropped   int    `json:"dropped,omitempty"`
+	Failures  int    `json:"failures,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+}
+
+// Status returns the status of a recipient's queue. Recipients with nothing
+// queued have a zero status, as their queues are discarded once empty.
+func (d *Dispatcher) Status(tag string, callbackUrl string) QueueStatus {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	q, ok := d.queues[Recipient{tag, callbackUrl}]
+	if !ok {
+		return QueueStatus{}
+	}
+	return QueueStatus{Pending: len(q.items), Dropped: q.dropped, Failures: q.failures, LastError: q.lastError}
+}
+
+// Backlog returns the number of messages queued across all recipients.
+func (d *Dispatcher) Backlog() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	backlog := 0
+	for _, q := range d.queues {
+		backlog += len(q.items)
+	}
+	return backlog
+}
+
+// Drain waits until every queued message has been delivered, or returns
+// ctx's error if it's done first.
+func (d *Dispatcher) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for d.Backlog() > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return fmt.Errorf("%d messages left undelivered: %w", d.Backlog(), ctx.Err())
+		}
+	}
+	return nil
+}
+
+const drainPollInterval = 10 * time.Millisecond
+
+// RegisterChecks registers a check that the backlog is below MaxBacklog, and
+// one for the spool if it can check itself.
+func (d *Dispatcher) RegisterChecks(r *health.Registry) {
+	r.Register("delivery", health.CheckFunc(func(ctx context.Context) error {
+		select {
+		case <-d.done:
+			return fmt.Errorf("dispatcher closed")
+		default:
+		}
+		if backlog := d.Backlog(); backlog > d.config.MaxBacklog {
+			return fmt.Errorf("backlog of %d messages exceeds %d", backlog, d.config.MaxBacklog)
+		}
+		return nil
+	}))
+	if checker, ok := d.config.Spool.(health.Checker); ok {
+		r.Register("spool", checker)
+	}
+}
+
+// Close stops retrying failed deliveries and flushes the spool, if there is
+// one, where undelivered messages are kept for the next Dispatcher. It may be
+// called more than once.
+func (d *Dispatcher) Close() error {
+	d.closeOnce.Do(func() {
+		close(d.done)
+	})
+	if d.config.Spool == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	queues := make(map[Recipient]*queue, len(d.queues))
+	for r, q := range d.queues {
+		queues[r] = q
+	}
+	d.mu.Unlock()
+
+	for r, q := range queues {
+		d.save(r, q)
+	}
+	return d.config.Spool.Flush()
+}
+
+// run delivers the recipient's queue in order until it's empty, waiting
+// between attempts while the callback is failing.
+func (d *Dispatcher) run(r Recipient, q *queue) {
+	for {
+		d.save(r, q)
+
+		d.mu.Lock()
+		if len(q.items) == 0 {
+			q.running = false
+			delete(d.queues, r)
+			d.mu.Unlock()
+			return
+		}
+		next, dropped := q.items[0], q.dropped
+		d.mu.Unlock()
+
+		if dropped > 0 && d.config.DroppedNotice != nil {
+			if notice := d.config.DroppedNotice(r, dropped); notice != nil {
+				if err := d.send(r, Message{Body: notice}); err != nil {
+					d.failed(q, err)
+					if !d.wait(r, q) {
+						return
+					}
+					continue
+				}
+			}
+			d.mu.Lock()
+			q.dropped -= dropped
+			q.dirty = true
+			d.mu.Unlock()
+		}
+
+		if err := d.send(r, next.Message); err != nil {
+			d.failed(q, err)
+			if !d.wait(r, q) {
+				return
+			}
+			continue
+		}
+
+		d.mu.Lock()
+		// The message may have been dropped to make room while it was in flight
+		if len(q.items) > 0 && q.items[0].seq == next.seq {
+			q.items = q.items[1:]
+		}
+		q.failures, q.lastError = 0, ""
+		q.dirty = true
+		d.mu.Unlock()
+	}
+}
+
+// failed records a failed delivery attempt for Status.
+func (d *Dispatcher) failed(q *queue, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	q.failures += 1
+	q.lastError = err.Error()
+}
+
+// wait sleeps until the next retry, returning false if the dispatcher was
+// closed in the meantime.
+func (d *Dispatcher) wait(r Recipient, q *queue) bool {
+	// Save whatever was queued during the failed attempt before sleeping
+	d.save(r, q)
+
+	select {
+	case <-time.After(d.config.RetryInterval):
+		return true
+	case <-d.done:
+		d.mu.Lock()
+		q.running = false
+		d.mu.Unlock()
+		return false
+	}
+}
+
+func (d *Dispatcher) send(r Recipient, message Message) error {
+	req, err := http.NewRequest(http.MethodPost, r.CallbackURL, bytes.NewReader(message.Body))
+	if err != nil {
+		d.metrics.failures.Inc(failureReason(err, 0))
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if message.RequestId != "" {
+		req.Header.Set(requestid.Header, message.RequestId)
+	}
+
+	start := time.Now()
+	res, err := d.client.Do(req)
+	d.metrics.duration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		d.metrics.failures.Inc(failureReason(err, 0))
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		d.metrics.failures.Inc(failureReason(nil, res.StatusCode))
+		return fmt.Errorf("callback %s responded with status %d", r.CallbackURL, res.StatusCode)
+	}
+	return nil
+}
+
+// save writes the recipient's queue to the spool if it has changed since it
+// was last saved. Must not be called with d.mu held, as the spool may be slow.
+func (d *Dispatcher) save(r Recipient, q *queue) {
+	if d.config.Spool == nil {
+		return
+	}
+
+	q.saving.Lock()
+	defer q.saving.Unlock()
+
+	d.mu.Lock()
+	if !q.dirty {
+		d.mu.Unlock()
+		return
+	}
+	messages := make([]Message, len(q.items))
+	for i, item := range q.items {
+		messages[i] = item.Message
+	}
+	dropped := q.dropped
+	q.dirty = false
+	d.mu.Unlock()
+
+	d.config.Spool.Save(r, messages, dropped)
+}
+
+func (d *Dispatcher) resume() {
+	if d.config.Spool == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, pending := range d.config.Spool.Load() {
+		q := &queue{dropped: pending.Dropped, running: true}
+		for _, message := range pending.Messages {
+			d.seq += 1
+			q.items = append(q.items, item{d.seq, message})
+		}
+		d.queues[pending.Recipient] = q
+		go d.run(pending.Recipient, q)
+	}
+}
@@ -0,0 +1,259 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const tag = "new_user"
+
+// callbackServer records the bodies it receives, failing while down is set.
+type callbackServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	down     bool
+	received []string
+}
+
+func newCallbackServer() *callbackServer {
+	s := &callbackServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.down {
+			w.WriteHeader(503)
+			return
+		}
+		bs, _ := ioutil.ReadAll(r.Body)
+		s.received = append(s.received, string(bs))
+	}))
+	return s
+}
+
+func (s *callbackServer) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *callbackServer) waitFor(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		received := append([]string(nil), s.received...)
+		s.mu.Unlock()
+		if len(received) >= n {
+			return received
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("expected %d deliveries", n)
+	return nil
+}
+
+func droppedNotice(r Recipient, dropped int) []byte {
+	return []byte(fmt.Sprintf("dropped %d", dropped))
+}
+
+func TestDispatch(t *testing.T) {
+	t.Run("delivers in order", func(t *testing.T) {
+		server := newCallbackServer()
+		defer server.Close()
+		d := NewDispatcher(Config{})
+		defer d.Close()
+
+		for i := 0; i < 5; i++ {
+			d.Dispatch(context.Background(), tag, server.URL, []byte(fmt.Sprint(i)))
+		}
+
+		assert.Equal(t, []string{"0", "1", "2", "3", "4"}, server.waitFor(t, 5))
+	})
+
+	t.Run("queues while callback is down", func(t *testing.T) {
+		server := newCallbackServer()
+		defer server.Close()
+		server.setDown(true)
+		d := NewDispatcher(Config{RetryInterval: 10 * time.Millisecond})
+		defer d.Close()
+
+		for i := 0; i < 3; i++ {
+			d.Dispatch(context.Background(), tag, server.URL, []byte(fmt.Sprint(i)))
+		}
+		time.Sleep(30 * time.Millisecond)
+		assert.Equal(t, 3, d.Pending(tag, server.URL))
+		assert.True(t, d.metrics.failures.Value("http_5xx") > 0)
+		status := d.Status(tag, server.URL)
+		assert.Equal(t, 3, status.Pending)
+		assert.True(t, status.Failures > 0)
+		assert.Contains(t, status.LastError, "responded with status 503")
+
+		server.setDown(false)
+
+		assert.Equal(t, []string{"0", "1", "2"}, server.waitFor(t, 3))
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, QueueStatus{}, d.Status(tag, server.URL))
+	})
+
+	t.Run("drops oldest when queue is full", func(t *testing.T) {
+		server := newCallbackServer()
+		defer server.Close()
+		server.setDown(true)
+		d := NewDispatcher(Config{
+			RetryInterval:  10 * time.Millisecond,
+			MaxQueueLength: 2,
+			DroppedNotice:  droppedNotice,
+		})
+		defer d.Close()
+
+		for i := 0; i < 5; i++ {
+			d.Dispatch(context.Background(), tag, server.URL, []byte(fmt.Sprint(i)))
+		}
+		assert.Equal(t, 2, d.Pending(tag, server.URL))
+
+		server.setDown(false)
+
+		assert.Equal(t, []string{"dropped 3", "3", "4"}, server.waitFor(t, 3))
+	})
+
+	t.Run("sends no notice when it's nil", func(t *testing.T) {
+		server := newCallbackServer()
+		defer server.Close()
+		server.setDown(true)
+		d := NewDispatcher(Config{
+			RetryInterval:  10 * time.Millisecond,
+			MaxQueueLength: 2,
+			DroppedNotice:  func(r Recipient, dropped int) []byte { return nil },
+		})
+		defer d.Close()
+
+		for i := 0; i < 5; i++ {
+			d.Dispatch(context.Background(), tag, server.URL, []byte(fmt.Sprint(i)))
+		}
+
+		server.setDown(false)
+
+		assert.Equal(t, []string{"3", "4"}, server.waitFor(t, 2))
+		time.Sleep(20 * time.Millisecond)
+		assert.Equal(t, QueueStatus{}, d.Status(tag, server.URL))
+	})
+}
+
+func TestDrain(t *testing.T) {
+	t.Run("waits for queued messages", func(t *testing.T) {
+		server := newCallbackServer()
+		defer server.Close()
+		server.setDown(true)
+		d := NewDispatcher(Config{RetryInterval: 10 * time.Millisecond})
+		defer d.Close()
+
+		for i := 0; i < 3; i++ {
+			d.Dispatch(context.Background(), tag, server.URL, []byte(fmt.Sprint(i)))
+		}
+		assert.Equal(t, 3, d.Backlog())
+
+		time.AfterFunc(30*time.Millisecond, func() { server.setDown(false) })
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		assert.Nil(t, d.Drain(ctx))
+		assert.Equal(t, 0, d.Backlog())
+		assert.Len(t, server.waitFor(t, 3), 3)
+	})
+
+	t.Run("gives up at the deadline", func(t *testing.T) {
+		server := newCallbackServer()
+		defer server.Close()
+		server.setDown(true)
+		d := NewDispatcher(Config{RetryInterval: time.Hour})
+		defer d.Close()
+
+		d.Dispatch(context.Background(), tag, server.URL, []byte("0"))
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+
+		err := d.Drain(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, d.Backlog())
+	})
+}
+
+func TestClose(t *testing.T) {
+	d := NewDispatcher(Config{})
+
+	assert.Nil(t, d.Close())
+	assert.Nil(t, d.Close(), "closing twice doesn't panic")
+}
+
+func TestFileSpool(t *testing.T) {
+	t.Run("resumes delivery of spooled messages", func(t *testing.T) {
+		dir := t.TempDir()
+
+		server := newCallbackServer()
+		defer server.Close()
+		server.setDown(true)
+
+		spool, err := NewFileSpool(dir)
+		assert.Nil(t, err)
+		d := NewDispatcher(Config{RetryInterval: time.Hour, Spool: spool})
+		d.Dispatch(context.Background(), tag, server.URL, []byte("0"))
+		d.Dispatch(context.Background(), tag, server.URL, []byte("1"))
+		time.Sleep(20 * time.Millisecond)
+		assert.Nil(t, d.Close())
+
+		server.setDown(false)
+
+		spool, err = NewFileSpool(dir)
+		assert.Nil(t, err)
+		d = NewDispatcher(Config{Spool: spool})
+		defer d.Close()
+
+		assert.Equal(t, []string{"0", "1"}, server.waitFor(t, 2))
+
+		time.Sleep(20 * time.Millisecond)
+		assert.Empty(t, spool.Load())
+	})
+}
+
+// blockingSpool holds up every Save until release is closed.
+type blockingSpool struct {
+	release chan struct{}
+}
+
+func (s *blockingSpool) Save(r Recipient, messages []Message, dropped int) { <-s.release }
+func (s *blockingSpool) Load() []Pending                                   { return nil }
+func (s *blockingSpool) Flush() error                                      { return nil }
+
+func TestDispatchDoesNotWaitForSpool(t *testing.T) {
+	server := newCallbackServer()
+	defer server.Close()
+
+	spool := &blockingSpool{release: make(chan struct{})}
+	d := NewDispatcher(Config{Spool: spool})
+
+	dispatched := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			d.Dispatch(context.Background(), tag, server.URL, []byte(fmt.Sprint(i)))
+		}
+		close(dispatched)
+	}()
+
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("Dispatch waited for the spool")
+	}
+
+	close(spool.release)
+	assert.Equal(t, []string{"0", "1", "2"}, server.waitFor(t, 3))
+	assert.Nil(t, d.Close())
+}
@@ -0,0 +1,47 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"irc/server/metrics"
+	"net"
+)
+
+// dispatcherMetrics are the collectors a Dispatcher records deliveries with.
+type dispatcherMetrics struct {
+	duration *metrics.HistogramVec
+	failures *metrics.CounterVec
+	dropped  *metrics.CounterVec
+}
+
+func newDispatcherMetrics(r *metrics.Registry) dispatcherMetrics {
+	return dispatcherMetrics{
+		duration: r.NewHistogramVec(
+			"irc_callback_delivery_duration_seconds",
+			"Time taken by attempts to deliver to member callbacks.",
+			metrics.DefaultBuckets,
+		),
+		failures: r.NewCounterVec(
+			"irc_callback_delivery_failures_total",
+			"Failed attempts to deliver to member callbacks, by reason.",
+			"reason",
+		),
+		dropped: r.NewCounterVec(
+			"irc_callback_messages_dropped_total",
+			"Messages dropped from full member queues.",
+		),
+	}
+}
+
+// failureReason classifies a failed delivery attempt for the failures metric.
+func failureReason(err error, status int) string {
+	if err == nil {
+		return fmt.Sprintf("http_%dxx", status/100)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return "connection"
+}
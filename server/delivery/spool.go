@@ -0,0 +1,130 @@
+package delivery
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Spool persists the messages queued for each recipient.
+type Spool interface {
+	// Save replaces everything stored for the recipient. An empty queue with
+	// nothing dropped removes the recipient from the spool.
+	Save(r Recipient, messages []Message, dropped int)
+	Load() []Pending
+	// Flush makes sure everything saved so far survives a crash.
+	Flush() error
+}
+
+// Pending is a recipient's queue as stored in a Spool.
+type Pending struct {
+	Recipient
+	Messages []Message `json:"messages"`
+	Dropped  int       `json:"dropped,omitempty"`
+}
+
+// FileSpool stores each recipient's queue as a JSON file in a directory.
+type FileSpool struct {
+	dir string
+}
+
+func NewFileSpool(dir string) (*FileSpool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileSpool{dir}, nil
+}
+
+func (s *FileSpool) Save(r Recipient, messages []Message, dropped int) {
+	path := s.path(r)
+	if len(messages) == 0 && dropped == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to remove spooled queue for %+v: %v", r, err)
+		}
+		return
+	}
+
+	bs, err := json.Marshal(Pending{r, messages, dropped})
+	if err != nil {
+		log.Printf("failed to encode spooled queue for %+v: %v", r, err)
+		return
+	}
+
+	// Write then rename, so a crash mid-write never leaves a truncated queue
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, bs, 0600); err != nil {
+		log.Printf("failed to spool queue for %+v: %v", r, err)
+		return
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.Printf("failed to spool queue for %+v: %v", r, err)
+	}
+}
+
+func (s *FileSpool) Load() []Pending {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		log.Printf("failed to list spooled queues: %v", err)
+		return nil
+	}
+
+	pending := make([]Pending, 0, len(paths))
+	for _, path := range paths {
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Printf("failed to read spooled queue %s: %v", path, err)
+			continue
+		}
+		var p Pending
+		if err := json.Unmarshal(bs, &p); err != nil {
+			log.Printf("failed to decode spooled queue %s: %v", path, err)
+			continue
+		}
+		pending = append(pending, p)
+	}
+	return pending
+}
+
+// Flush syncs every spooled queue, and the directory holding them, to disk.
+// Save doesn't, to keep delivery fast.
+func (s *FileSpool) Flush() error {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range append(paths, s.dir) {
+		if err := syncFile(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check makes sure the spool directory is still writable.
+func (s *FileSpool) Check(ctx context.Context) error {
+	f, err := ioutil.TempFile(s.dir, ".check-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func syncFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+func (s *FileSpool) path(r Recipient) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%x.json", sha1.Sum([]byte(r.Tag+"\x00"+r.CallbackURL))))
+}
@@ -0,0 +1,141 @@
+// Package health runs the checks that decide whether the server is ready to
+// serve requests, and reports their results as JSON.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Checker is implemented by components that can check their own health.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckFunc adapts a function to a Checker.
+type CheckFunc func(ctx context.Context) error
+
+func (f CheckFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Registrant is implemented by components that register their own checks,
+// like the store and the dispatcher.
+type Registrant interface {
+	RegisterChecks(r *Registry)
+}
+
+// Registry holds checks in the order they were registered.
+type Registry struct {
+	mu     sync.Mutex
+	checks []check
+}
+
+type check struct {
+	name    string
+	checker Checker
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a check. Its name identifies it in reports.
+func (r *Registry) Register(name string, checker Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checks = append(r.checks, check{name, checker})
+}
+
+const (
+	StatusOK      = "ok"
+	StatusFailing = "failing"
+)
+
+// Report is the outcome of running every check. Status is ok only if every
+// check passed.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type Result struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"durationSeconds"`
+}
+
+// Run runs every check concurrently, each bounded by timeout.
+func (r *Registry) Run(ctx context.Context, timeout time.Duration) Report {
+	r.mu.Lock()
+	checks := append([]check(nil), r.checks...)
+	r.mu.Unlock()
+
+	results := make([]Result, len(checks))
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c check) {
+			defer wg.Done()
+			results[i] = run(ctx, c, timeout)
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: results}
+	for _, result := range results {
+		if result.Status != StatusOK {
+			report.Status = StatusFailing
+		}
+	}
+	return report
+}
+
+// run runs a single check, giving up on it once ctx is done even if the
+// check itself doesn't.
+func run(ctx context.Context, c check, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- c.checker.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{Name: c.name, Status: StatusOK, Duration: time.Since(start).Seconds()}
+	if err != nil {
+		result.Status = StatusFailing
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// DefaultTimeout bounds each check run by Handler.
+const DefaultTimeout = 5 * time.Second
+
+// Handler serves the report of the registry's checks, e.g. on /readyz. It
+// responds with 503 Service Unavailable if any check fails.
+func Handler(r *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		report := r.Run(req.Context(), DefaultTimeout)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if report.Status != StatusOK {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,84 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ok(ctx context.Context) error {
+	return nil
+}
+
+func failing(ctx context.Context) error {
+	return fmt.Errorf("disk full")
+}
+
+func TestRun(t *testing.T) {
+	t.Run("all passing", func(t *testing.T) {
+		r := NewRegistry()
+		r.Register("a", CheckFunc(ok))
+		r.Register("b", CheckFunc(ok))
+
+		report := r.Run(context.Background(), time.Second)
+
+		assert.Equal(t, StatusOK, report.Status)
+		assert.Len(t, report.Checks, 2)
+		assert.Equal(t, "a", report.Checks[0].Name)
+		assert.Equal(t, "b", report.Checks[1].Name)
+	})
+
+	t.Run("one failing", func(t *testing.T) {
+		r := NewRegistry()
+		r.Register("a", CheckFunc(ok))
+		r.Register("b", CheckFunc(failing))
+
+		report := r.Run(context.Background(), time.Second)
+
+		assert.Equal(t, StatusFailing, report.Status)
+		assert.Equal(t, StatusOK, report.Checks[0].Status)
+		assert.Equal(t, StatusFailing, report.Checks[1].Status)
+		assert.Equal(t, "disk full", report.Checks[1].Error)
+	})
+
+	t.Run("check that hangs times out", func(t *testing.T) {
+		r := NewRegistry()
+		r.Register("stuck", CheckFunc(func(ctx context.Context) error {
+			select {}
+		}))
+
+		report := r.Run(context.Background(), 10*time.Millisecond)
+
+		assert.Equal(t, StatusFailing, report.Status)
+		assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks[0].Error)
+	})
+}
+
+func TestHandler(t *testing.T) {
+	t.Run("no checks", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		Handler(NewRegistry()).ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+		assert.Equal(t, 200, rr.Code)
+		assert.JSONEq(t, `{"status":"ok","checks":[]}`, rr.Body.String())
+	})
+
+	t.Run("failing check", func(t *testing.T) {
+		r := NewRegistry()
+		r.Register("spool", CheckFunc(failing))
+
+		rr := httptest.NewRecorder()
+		Handler(r).ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+
+		assert.Equal(t, 503, rr.Code)
+		var report Report
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&report))
+		assert.Equal(t, StatusFailing, report.Status)
+		assert.Equal(t, "spool", report.Checks[0].Name)
+	})
+}
@@ -0,0 +1,76 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Types of the built-in hooks
+const (
+	RedactType  = "redact"
+	ReplaceType = "replace"
+)
+
+type redactor struct {
+	pattern *regexp.Regexp
+}
+
+// NewRedactor returns a hook that replaces each of words in messages with
+// asterisks, ignoring case. Only whole words are redacted, so redacting
+// "cat" leaves "catalog" alone.
+func NewRedactor(words []string) (Hook, error) {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		quoted = append(quoted, regexp.QuoteMeta(word))
+	}
+	if len(quoted) == 0 {
+		return nil, fmt.Errorf("%s hooks need words to redact", RedactType)
+	}
+	return &redactor{regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)}, nil
+}
+
+func (r *redactor) Name() string {
+	return RedactType
+}
+
+func (r *redactor) Apply(ctx context.Context, m *Message) (Verdict, error) {
+	m.Text = r.pattern.ReplaceAllStringFunc(m.Text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+	return Verdict{}, nil
+}
+
+type replacer struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// NewReplacer returns a hook that replaces matches of the regular expression
+// pattern in messages with replacement, in which $1 stands for the first
+// submatch, as in regexp.Regexp.Expand.
+func NewReplacer(pattern string, replacement string) (Hook, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("%s hooks need a pattern", ReplaceType)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s pattern: %v", ReplaceType, err)
+	}
+	return &replacer{re, replacement}, nil
+}
+
+func (r *replacer) Name() string {
+	return ReplaceType
+}
+
+func (r *replacer) Apply(ctx context.Context, m *Message) (Verdict, error) {
+	m.Text = r.pattern.ReplaceAllString(m.Text, r.replacement)
+	return Verdict{}, nil
+}
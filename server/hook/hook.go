@@ -0,0 +1,176 @@
+// Package hook runs messages through an ordered pipeline of hooks before
+// they're posted, which may inspect, rewrite, reject or hold them back.
+package hook
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Message is a message about to be posted in a room. Hooks rewrite it by
+// changing Text.
+type Message struct {
+	RoomId   int
+	RoomName string
+	// Type is the type of event the message is posted as, e.g. "message"
+	// or "action"
+	Type string
+	// Tag is the member, or incoming webhook, posting the message
+	Tag  string
+	Text string
+}
+
+// Action is what a hook decided to do with a message.
+type Action string
+
+const (
+	// Accept passes the message, as the hook left it, on to the next hook.
+	Accept Action = ""
+	// Reject rejects the message, which the poster is told of.
+	Reject Action = "reject"
+	// Hold keeps the message from being posted, for review.
+	Hold Action = "hold"
+)
+
+// Verdict is what a hook decided to do with a message, and why.
+type Verdict struct {
+	Action Action
+	// Code tells posters why their message was rejected. Optional.
+	Code   string
+	Reason string
+	// Hook is the name of the hook that decided, set by Pipeline.Run.
+	Hook string
+}
+
+// Rejected rejects a message with the given code and reason.
+func Rejected(code string, format string, args ...interface{}) Verdict {
+	return Verdict{Action: Reject, Code: code, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Held holds a message back for the given reason.
+func Held(format string, args ...interface{}) Verdict {
+	return Verdict{Action: Hold, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Hook inspects, and possibly rewrites, a message about to be posted. Hooks
+// run with the room locked, so they should be quick. An error fails the
+// hook, and the message is rejected.
+type Hook interface {
+	Name() string
+	Apply(ctx context.Context, m *Message) (Verdict, error)
+}
+
+// Error is a hook failing.
+type Error struct {
+	Hook string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("hook %q failed: %v", e.Hook, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Pipeline is an ordered list of hooks.
+type Pipeline []Hook
+
+// Run runs a message through the hooks in order, until one rejects or holds
+// it, or fails. It returns the verdict of the last hook run, and an *Error
+// if it failed.
+func (p Pipeline) Run(ctx context.Context, m *Message) (Verdict, error) {
+	for _, hook := range p {
+		verdict, err := hook.Apply(ctx, m)
+		if err != nil {
+			return Verdict{}, &Error{Hook: hook.Name(), Err: err}
+		}
+		if verdict.Action != Accept {
+			verdict.Hook = hook.Name()
+			return verdict, nil
+		}
+	}
+	return Verdict{}, nil
+}
+
+// Spec configures a hook of a registered type, as rooms' operators do
+// through the API. The built-in types take the fields named after them;
+// hooks registered with Register may take any JSON as Config.
+type Spec struct {
+	Type string `json:"type"`
+	// Words are redacted by the "redact" hook
+	Words []string `json:"words,omitempty"`
+	// Pattern is replaced with Replacement by the "replace" hook
+	Pattern     string          `json:"pattern,omitempty"`
+	Replacement string          `json:"replacement,omitempty"`
+	Config      json.RawMessage `json:"config,omitempty"`
+}
+
+// Factory builds a hook from its spec.
+type Factory func(spec Spec) (Hook, error)
+
+var (
+	mu        sync.RWMutex
+	factories = map[string]Factory{
+		RedactType: func(spec Spec) (Hook, error) {
+			return NewRedactor(spec.Words)
+		},
+		ReplaceType: func(spec Spec) (Hook, error) {
+			return NewReplacer(spec.Pattern, spec.Replacement)
+		},
+	}
+)
+
+// Register makes hooks of the given type available to rooms, built by
+// factory. It panics if the type is already registered.
+func Register(hookType string, factory Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := factories[hookType]; ok {
+		panic(fmt.Sprintf("hook type %q is already registered", hookType))
+	}
+	factories[hookType] = factory
+}
+
+// Types lists the registered hook types, sorted.
+func Types() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	types := make([]string, 0, len(factories))
+	for hookType := range factories {
+		types = append(types, hookType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// New builds the hook spec configures.
+func New(spec Spec) (Hook, error) {
+	mu.RLock()
+	factory, ok := factories[spec.Type]
+	mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown hook type %q", spec.Type)
+	}
+	return factory(spec)
+}
+
+// NewPipeline builds the hooks specs configure, in order.
+func NewPipeline(specs []Spec) (Pipeline, error) {
+	pipeline := make(Pipeline, 0, len(specs))
+	for i, spec := range specs {
+		hook, err := New(spec)
+		if err != nil {
+			return nil, fmt.Errorf("hook %d: %v", i+1, err)
+		}
+		pipeline = append(pipeline, hook)
+	}
+	return pipeline, nil
+}
@@ -0,0 +1,106 @@
+package hook
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// funcHook adapts a function to Hook, for tests.
+type funcHook struct {
+	name  string
+	apply func(m *Message) (Verdict, error)
+}
+
+func (h funcHook) Name() string {
+	return h.name
+}
+
+func (h funcHook) Apply(ctx context.Context, m *Message) (Verdict, error) {
+	return h.apply(m)
+}
+
+func TestPipeline(t *testing.T) {
+	ctx := context.Background()
+	upper := funcHook{"upper", func(m *Message) (Verdict, error) {
+		m.Text = strings.ToUpper(m.Text)
+		return Verdict{}, nil
+	}}
+	holdQuestions := funcHook{"questions", func(m *Message) (Verdict, error) {
+		if strings.HasSuffix(m.Text, "?") {
+			return Held("questions need review"), nil
+		}
+		return Verdict{}, nil
+	}}
+	broken := funcHook{"broken", func(m *Message) (Verdict, error) {
+		return Verdict{}, fmt.Errorf("out of order")
+	}}
+	redact, err := NewRedactor([]string{"darn"})
+	assert.Nil(t, err)
+
+	t.Run("rewrites in order", func(t *testing.T) {
+		m := &Message{Text: "darn it"}
+		verdict, err := Pipeline{redact, upper}.Run(ctx, m)
+		assert.Nil(t, err)
+		assert.Equal(t, Accept, verdict.Action)
+		assert.Equal(t, "**** IT", m.Text)
+	})
+
+	t.Run("stops at the first verdict", func(t *testing.T) {
+		m := &Message{Text: "why?"}
+		verdict, err := Pipeline{holdQuestions, upper}.Run(ctx, m)
+		assert.Nil(t, err)
+		assert.Equal(t, Verdict{Action: Hold, Reason: "questions need review", Hook: "questions"}, verdict)
+		assert.Equal(t, "why?", m.Text)
+	})
+
+	t.Run("errors name the hook", func(t *testing.T) {
+		_, err := Pipeline{upper, broken}.Run(ctx, &Message{Text: "hi"})
+		assert.EqualError(t, err, `hook "broken" failed: out of order`)
+	})
+}
+
+func TestBuiltins(t *testing.T) {
+	ctx := context.Background()
+	apply := func(h Hook, text string) string {
+		m := &Message{Text: text}
+		h.Apply(ctx, m)
+		return m.Text
+	}
+
+	redact, err := New(Spec{Type: RedactType, Words: []string{"cat", "c.t"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "a *** in a catalog, ***", apply(redact, "a Cat in a catalog, c.t"))
+
+	replace, err := New(Spec{Type: ReplaceType, Pattern: `(\w+)@example\.com`, Replacement: "$1@…"})
+	assert.Nil(t, err)
+	assert.Equal(t, "mail alice@… now", apply(replace, "mail alice@example.com now"))
+
+	_, err = New(Spec{Type: RedactType})
+	assert.EqualError(t, err, "redact hooks need words to redact")
+	_, err = New(Spec{Type: ReplaceType, Pattern: "("})
+	assert.Error(t, err)
+	_, err = NewPipeline([]Spec{{Type: RedactType, Words: []string{"x"}}, {Type: "translate"}})
+	assert.EqualError(t, err, `hook 2: unknown hook type "translate"`)
+}
+
+func TestRegister(t *testing.T) {
+	Register("shout", func(spec Spec) (Hook, error) {
+		return funcHook{"shout", func(m *Message) (Verdict, error) {
+			m.Text = strings.ToUpper(m.Text)
+			return Verdict{}, nil
+		}}, nil
+	})
+	assert.Equal(t, []string{"redact", "replace", "shout"}, Types())
+
+	pipeline, err := NewPipeline([]Spec{{Type: "shout"}})
+	assert.Nil(t, err)
+	m := &Message{Text: "hi"}
+	pipeline.Run(context.Background(), m)
+	assert.Equal(t, "HI", m.Text)
+
+	assert.Panics(t, func() { Register("shout", nil) })
+}
@@ -1,14 +1,187 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
 	"irc/server/api"
+	"irc/server/audit"
+	"irc/server/bot"
+	"irc/server/delivery"
+	"irc/server/hook"
+	"irc/server/metrics"
 	"irc/server/model"
+	"irc/server/ratelimit"
+	"irc/server/spam"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	addr            = flag.String("addr", ":8080", "address to listen on")
+	spoolDir        = flag.String("spool", "", "directory to persist undelivered messages in (default: keep them in memory)")
+	presenceTimeout = flag.Duration("presence-timeout", model.DefaultPresenceConfig.Timeout, "mark members offline after this long without a heartbeat")
+	evictAfter      = flag.Duration("evict-after", model.DefaultPresenceConfig.EvictAfter, "remove members from rooms after this long without a heartbeat")
+	probe           = flag.Bool("probe", model.DefaultPresenceConfig.Probe, "probe the callbacks of members that stop sending heartbeats")
+	maxBacklog      = flag.Int("max-backlog", delivery.DefaultMaxBacklog, "report not ready while more messages than this are waiting to be delivered")
+	createRoomLimit = flag.Int("create-room-limit", 10, "rooms each client IP or creator may create per minute (0 for no limit)")
+	joinLimit       = flag.Int("join-limit", 30, "rooms each client IP or member tag may join per minute (0 for no limit)")
+	postLimit       = flag.Int("post-limit", 60, "messages each client IP or member tag may post per minute (0 for no limit)")
+	maxBodySize     = flag.Int64("max-body-size", api.DefaultMaxBodySize, "maximum size of request bodies, in bytes")
+	maxMessage      = flag.Int("max-message-length", model.DefaultMessagePolicy.MaxLength, "maximum length of messages, in bytes")
+	controlChars    = flag.String("control-chars", string(model.DefaultMessagePolicy.ControlChars), "what to do with control characters in messages: reject, strip or replace")
+	splitMessages   = flag.Bool("split-messages", model.DefaultMessagePolicy.Split, "split messages longer than the maximum length into parts, rather than rejecting them")
+	maxParts        = flag.Int("max-message-parts", model.DefaultMessagePolicy.MaxParts, "maximum number of parts a message may be split into")
+	historyLimit    = flag.Int("history-limit", model.DefaultHistoryLimit, "messages and events each room keeps, for search and export")
+	editWindow      = flag.Duration("edit-window", model.DefaultEditWindow, "how long after posting a message its author may edit or delete it (operators always may)")
+	spamCheck       = flag.Bool("spam", true, "check posted messages for floods and spam")
+	maxDuplicates   = flag.Int("spam-max-duplicates", spam.DefaultConfig.MaxDuplicates, "times a member may post the same message per minute (0 for no limit)")
+	maxBurst        = flag.Int("spam-max-burst", spam.DefaultConfig.MaxBurst, "messages that may be posted in a room per 10 seconds (0 for no limit)")
+	maxMentions     = flag.Int("spam-max-mentions", spam.DefaultConfig.MaxMentions, "members a message may mention (0 for no limit)")
+	maxLinks        = flag.Int("spam-max-links", spam.DefaultConfig.MaxLinks, "links a message may have (0 for no limit)")
+	linkDelay       = flag.Duration("spam-link-delay", spam.DefaultConfig.NewMemberLinkDelay, "how long members must have been in a room before posting links")
+	spamActions     = flag.String("spam-actions", "", "comma separated rule=action pairs overriding what's done with spam, e.g. links=kick; rules are duplicate, burst, mentions and links, actions drop, warn, mute and kick")
+	muteFor         = flag.Duration("spam-mute-for", spam.DefaultConfig.MuteFor, "how long the mute action mutes spammers")
+	messageHooks    = flag.String("message-hooks", "", "JSON file listing the specs of the hooks every room runs messages through, before its own")
+	bots            = flag.String("bots", "", "comma separated built-in bots to add to every room: echo, seen, uptime")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for queued messages to be delivered when shutting down")
+	auditLog        = flag.String("audit-log", "", "file to append the audit log to, as JSON lines (default: keep it in memory only)")
+	auditLimit      = flag.Int("audit-limit", audit.DefaultMaxEntries, "audit log entries kept in memory, for the admin API")
+	adminToken      = flag.String("admin-token", "", "bearer token of the /admin API, which is disabled without one (default: $IRC_ADMIN_TOKEN, which keeps it out of process listings)")
+	shutdownNotice  = flag.String("shutdown-notice", "server shutting down", "notice sent to every member when shutting down (empty to send none)")
 )
 
 func main() {
-	model.InitChatRoomStore()
-	api.SetRoutes()
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	flag.Parse()
+
+	policy := model.MessagePolicy{MaxLength: *maxMessage, Split: *splitMessages, MaxParts: *maxParts}
+	var err error
+	policy.ControlChars, err = model.ParseControlChars(*controlChars)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	registry := metrics.NewRegistry()
+	config := delivery.Config{DroppedNotice: model.DroppedNotice, MaxBacklog: *maxBacklog, Metrics: registry}
+	if *spoolDir != "" {
+		spool, err := delivery.NewFileSpool(*spoolDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		config.Spool = spool
+	}
+	dispatcher := delivery.NewDispatcher(config)
+
+	auditConfig := audit.Config{MaxEntries: *auditLimit}
+	if *auditLog != "" {
+		f, err := os.OpenFile(*auditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		auditConfig.Writer = f
+	}
+	auditor := audit.NewLog(auditConfig)
+
+	storeOpts := []model.StoreOption{model.WithDispatcher(dispatcher), model.WithMessagePolicy(policy), model.WithHistoryLimit(*historyLimit), model.WithEditWindow(*editWindow), model.WithAuditLog(auditor)}
+	if *spamCheck {
+		spamConfig := spam.DefaultConfig
+		spamConfig.MaxDuplicates = *maxDuplicates
+		spamConfig.MaxBurst = *maxBurst
+		spamConfig.MaxMentions = *maxMentions
+		spamConfig.MaxLinks = *maxLinks
+		spamConfig.NewMemberLinkDelay = *linkDelay
+		spamConfig.MuteFor = *muteFor
+		actions, err := spam.ParseActions(*spamActions)
+		if err != nil {
+			log.Fatal(err)
+		}
+		spamConfig.Actions = make(map[spam.Rule]spam.Action)
+		for rule, action := range spam.DefaultConfig.Actions {
+			spamConfig.Actions[rule] = action
+		}
+		for rule, action := range actions {
+			spamConfig.Actions[rule] = action
+		}
+		storeOpts = append(storeOpts, model.WithSpamConfig(spamConfig))
+	}
+	if *messageHooks != "" {
+		bs, err := ioutil.ReadFile(*messageHooks)
+		if err != nil {
+			log.Fatal(err)
+		}
+		var specs []hook.Spec
+		if err := json.Unmarshal(bs, &specs); err != nil {
+			log.Fatalf("reading %s: %v", *messageHooks, err)
+		}
+		pipeline, err := hook.NewPipeline(specs)
+		if err != nil {
+			log.Fatalf("reading %s: %v", *messageHooks, err)
+		}
+		storeOpts = append(storeOpts, model.WithMessageHooks(pipeline...))
+	}
+	for _, name := range strings.Split(*bots, ",") {
+		switch name {
+		case "":
+		case "echo":
+			storeOpts = append(storeOpts, bot.JoinAll(bot.NewEchoBot("echobot")))
+		case "seen":
+			storeOpts = append(storeOpts, bot.JoinAll(bot.NewSeenBot("seenbot", time.Now)))
+		case "uptime":
+			storeOpts = append(storeOpts, bot.JoinAll(bot.NewUptimeBot("uptimebot", time.Now)))
+		default:
+			log.Fatalf("unknown bot %q", name)
+		}
+	}
+
+	if *adminToken == "" {
+		*adminToken = os.Getenv("IRC_ADMIN_TOKEN")
+	}
+
+	store := model.NewChatRoomStore(storeOpts...)
+	server := api.NewServer(store,
+		api.WithDispatcher(dispatcher),
+		api.WithMetrics(registry),
+		api.WithPresence(model.PresenceConfig{Timeout: *presenceTimeout, EvictAfter: *evictAfter, Probe: *probe}),
+		api.WithShutdownNotice(*shutdownNotice),
+		api.WithMaxBodySize(*maxBodySize),
+		api.WithAdminToken(*adminToken),
+		api.WithAuditLog(auditor),
+		api.WithRateLimits(api.RateLimits{
+			CreateRoom: ratelimit.PerMinute(*createRoomLimit),
+			Join:       ratelimit.PerMinute(*joinLimit),
+			Post:       ratelimit.PerMinute(*postLimit),
+		}),
+	)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Start(*addr)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-errs:
+		if err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	case sig := <-signals:
+		log.Printf("received %s, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Print(err)
+		cancel()
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpose(t *testing.T) {
+	t.Run("counter", func(t *testing.T) {
+		r := NewRegistry()
+		c := r.NewCounterVec("requests_total", "Requests served.", "route", "status")
+		c.Inc("/b", "200")
+		c.Inc("/a", "404")
+		c.Add(2, "/b", "200")
+
+		var buf bytes.Buffer
+		r.Expose(&buf)
+
+		assert.Equal(t, `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a",status="404"} 1
+requests_total{route="/b",status="200"} 3
+`, buf.String())
+		assert.Equal(t, float64(3), c.Value("/b", "200"))
+	})
+
+	t.Run("gauge", func(t *testing.T) {
+		r := NewRegistry()
+		r.NewGaugeFunc("rooms", "Chat rooms.", func() float64 { return 4 })
+
+		var buf bytes.Buffer
+		r.Expose(&buf)
+
+		assert.Equal(t, "# HELP rooms Chat rooms.\n# TYPE rooms gauge\nrooms 4\n", buf.String())
+	})
+
+	t.Run("counter func", func(t *testing.T) {
+		r := NewRegistry()
+		r.NewCounterFunc("messages_total", "Messages posted.", func() float64 { return 7 })
+
+		var buf bytes.Buffer
+		r.Expose(&buf)
+
+		assert.Equal(t, "# HELP messages_total Messages posted.\n# TYPE messages_total counter\nmessages_total 7\n", buf.String())
+	})
+
+	t.Run("histogram", func(t *testing.T) {
+		r := NewRegistry()
+		h := r.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "route")
+		h.Observe(0.05, "/a")
+		h.Observe(0.5, "/a")
+		h.Observe(5, "/a")
+
+		var buf bytes.Buffer
+		r.Expose(&buf)
+
+		assert.Equal(t, `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 1
+latency_seconds_bucket{route="/a",le="1"} 2
+latency_seconds_bucket{route="/a",le="+Inf"} 3
+latency_seconds_sum{route="/a"} 5.55
+latency_seconds_count{route="/a"} 3
+`, buf.String())
+	})
+
+	t.Run("escapes label values", func(t *testing.T) {
+		r := NewRegistry()
+		r.NewCounterVec("messages_total", "Messages.", "room").Inc("a\"b\\c\nd")
+
+		var buf bytes.Buffer
+		r.Expose(&buf)
+
+		assert.Contains(t, buf.String(), `messages_total{room="a\"b\\c\nd"} 1`)
+	})
+}
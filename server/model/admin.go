@@ -0,0 +1,146 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"irc/server/audit"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Membership is a member of a room as the server's administrators see it,
+// callback URL included.
+type Membership struct {
+	RoomId   int    `json:"roomId"`
+	RoomName string `json:"roomName"`
+	MemberInfo
+	CallbackUrl string `json:"callbackUrl,omitempty"`
+}
+
+// Ban keeps a member out of every room of the server until it's lifted.
+type Ban struct {
+	Tag       string    `json:"tag"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// banList holds the bans of a store, shared with its rooms so they can turn
+// banned members away. A nil banList bans nobody.
+type banList struct {
+	mu   sync.Mutex
+	bans map[string]Ban
+}
+
+func newBanList() *banList {
+	return &banList{bans: make(map[string]Ban)}
+}
+
+func (b *banList) banned(tag string) bool {
+	if b == nil {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.bans[tag]
+	return ok
+}
+
+// Memberships lists the members of every room, ordered by tag and then room.
+func (s *ChatRoomStore) Memberships() []Membership {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var memberships []Membership
+	for _, room := range s.chatRooms {
+		room.mu.Lock()
+		for tag, member := range room.members {
+			info := MemberInfo{tag, member.presence(), member.lastSeen, member.operator, member.sink != nil}
+			memberships = append(memberships, Membership{room.Id, room.Name, info, member.callbackUrl})
+		}
+		room.mu.Unlock()
+	}
+	sort.Slice(memberships, func(i, j int) bool {
+		a, b := memberships[i], memberships[j]
+		return a.Tag < b.Tag || (a.Tag == b.Tag && a.RoomId < b.RoomId)
+	})
+	return memberships
+}
+
+// RemoveMember removes the member with the given tag from every room, as an
+// IRC server kills a client, telling the members of each room why. It
+// returns the IDs of the rooms the member was removed from.
+func (s *ChatRoomStore) RemoveMember(ctx context.Context, tag string, reason string) []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rooms := []int{}
+	for id, room := range s.chatRooms {
+		if room.expel(ctx, tag, reason) {
+			rooms = append(rooms, id)
+		}
+	}
+	sort.Ints(rooms)
+	return rooms
+}
+
+// Ban bans the member with the given tag from every room, removing it from
+// those it's in, whose IDs it returns.
+func (s *ChatRoomStore) Ban(ctx context.Context, tag string, reason string) ([]int, error) {
+	if tag == "" {
+		return nil, fmt.Errorf("a tag is required")
+	}
+
+	s.bans.mu.Lock()
+	s.bans.bans[tag] = Ban{Tag: tag, Reason: reason, CreatedAt: s.clock()}
+	s.bans.mu.Unlock()
+
+	leave := "banned"
+	if reason != "" {
+		leave += ": " + reason
+	}
+	return s.RemoveMember(ctx, tag, leave), nil
+}
+
+// Unban lifts the ban of the member with the given tag.
+func (s *ChatRoomStore) Unban(tag string) error {
+	s.bans.mu.Lock()
+	defer s.bans.mu.Unlock()
+
+	if _, ok := s.bans.bans[tag]; !ok {
+		return fmt.Errorf(`"%s" isn't banned`, tag)
+	}
+	delete(s.bans.bans, tag)
+	return nil
+}
+
+// Bans lists the bans in force, ordered by tag.
+func (s *ChatRoomStore) Bans() []Ban {
+	s.bans.mu.Lock()
+	defer s.bans.mu.Unlock()
+
+	bans := make([]Ban, 0, len(s.bans.bans))
+	for _, ban := range s.bans.bans {
+		bans = append(bans, ban)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Tag < bans[j].Tag
+	})
+	return bans
+}
+
+// expel removes a member from the room, reporting whether it was there. The
+// member hears of it too, before it's removed.
+func (c *ChatRoom) expel(ctx context.Context, tag string, reason string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.hasJoined(tag) {
+		return false
+	}
+	c.logAction(ctx, audit.Entry{Action: audit.MemberLeave, Target: tag, Reason: reason, Before: c.memberState(tag)})
+	c.broadcast(ctx, "", CallbackBody{Type: LeaveEvent, Tag: tag, Reason: reason})
+	c.removeMember(tag)
+	return true
+}
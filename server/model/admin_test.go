@@ -0,0 +1,61 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerAdmin(t *testing.T) {
+	ctx := context.Background()
+	dispatcher := newFakeDispatcher()
+	store := NewChatRoomStore(WithDispatcher(dispatcher))
+	store.AddProxy(ctx, "first", "")
+	store.AddProxy(ctx, "second", "")
+	for id, tags := range map[int][]string{0: {"alice", "bob"}, 1: {"bob", "carol"}} {
+		room, _ := store.GetProxy(id)
+		for _, tag := range tags {
+			room.Join(ctx, tag, tag+"-callback")
+		}
+	}
+
+	var memberships []string
+	for _, m := range store.Memberships() {
+		memberships = append(memberships, m.Tag+"@"+m.RoomName+"="+m.CallbackUrl)
+	}
+	assert.Equal(t, []string{"alice@first=alice-callback", "bob@first=bob-callback", "bob@second=bob-callback", "carol@second=carol-callback"}, memberships)
+
+	t.Run("remove member", func(t *testing.T) {
+		assert.Equal(t, []int{1}, store.RemoveMember(ctx, "carol", "removed by the server"))
+		assert.Equal(t, []int{}, store.RemoveMember(ctx, "carol", "removed by the server"))
+		assert.Equal(t, CallbackBody{Type: LeaveEvent, Tag: "carol", Reason: "removed by the server"}, dispatcher.received("carol")[0])
+
+		room, _ := store.GetProxy(1)
+		assert.Nil(t, room.Join(ctx, "carol", "carol-callback"), "removed members may come back")
+	})
+
+	t.Run("ban", func(t *testing.T) {
+		rooms, err := store.Ban(ctx, "bob", "spam")
+		assert.Nil(t, err)
+		assert.Equal(t, []int{0, 1}, rooms)
+		assert.Equal(t, CallbackBody{Type: LeaveEvent, Tag: "bob", Reason: "banned: spam"}, dispatcher.received("alice")[0])
+
+		room, _ := store.GetProxy(0)
+		assert.Equal(t, ForbiddenCode, commandCodeOf(room.Join(ctx, "bob", "bob-callback")))
+		assert.Equal(t, ForbiddenCode, commandCodeOf(room.PostMessage(ctx, "alice", "/nick bob")), "banned tags may not be taken either")
+		assert.True(t, room.HasJoined("alice"))
+		id, _ := store.AddProxy(ctx, "third", "")
+		room, _ = store.GetProxy(id)
+		assert.Equal(t, ForbiddenCode, commandCodeOf(room.Join(ctx, "bob", "bob-callback")), "bans cover rooms created later")
+
+		bans := store.Bans()
+		assert.Len(t, bans, 1)
+		assert.Equal(t, "spam", bans[0].Reason)
+
+		assert.Nil(t, store.Unban("bob"))
+		assert.EqualError(t, store.Unban("bob"), `"bob" isn't banned`)
+		assert.Nil(t, room.Join(ctx, "bob", "bob-callback"))
+		assert.Empty(t, store.Bans())
+	})
+}
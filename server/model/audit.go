@@ -0,0 +1,58 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"irc/server/audit"
+)
+
+// WithAuditLog makes the store's rooms record, in log, who created and
+// deleted them, changed their topic, description and modes, added and
+// removed their webhooks, and decided on, edited and deleted others'
+// messages; and who joined, left, changed tag, was invited to, kicked or
+// muted from, or made an operator of them.
+func WithAuditLog(log *audit.Log) StoreOption {
+	return func(s *ChatRoomStore) {
+		s.audit = log
+	}
+}
+
+// logAction records an action taken in the room in the audit log, if it has
+// one. Must be called with c.mu held.
+func (c *ChatRoom) logAction(ctx context.Context, e audit.Entry) {
+	e.Room = &audit.Room{Id: c.Id, Name: c.Name}
+	c.audit.Record(ctx, e)
+}
+
+// memberState is the state of a member kept in the audit log. Must be
+// called with c.mu held.
+func (c *ChatRoom) memberState(tag string) json.RawMessage {
+	member, ok := c.members[tag]
+	if !ok {
+		return nil
+	}
+	return audit.State(struct {
+		CallbackUrl string `json:"callbackUrl,omitempty"`
+		Operator    bool   `json:"operator"`
+		Bot         bool   `json:"bot,omitempty"`
+	}{member.callbackUrl, member.operator, member.sink != nil})
+}
+
+func tagState(tag string) json.RawMessage {
+	return audit.State(map[string]string{"tag": tag})
+}
+
+func topicState(topic *Topic) json.RawMessage {
+	if topic == nil {
+		return audit.State(map[string]string{"topic": ""})
+	}
+	return audit.State(map[string]string{"topic": topic.Text})
+}
+
+func modesState(modes string) json.RawMessage {
+	return audit.State(map[string]string{"modes": modes})
+}
+
+func descriptionState(description string) json.RawMessage {
+	return audit.State(map[string]string{"description": description})
+}
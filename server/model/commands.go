@@ -0,0 +1,215 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"irc/server/audit"
+	"strings"
+	"unicode"
+)
+
+// CommandPrefix starts messages that are commands, like "/me waves". Posting
+// a message that starts with it takes two, so "//me" posts "/me".
+const CommandPrefix = "/"
+
+// Codes identifying why a command failed
+const (
+	UnknownCommandCode   = "unknown_command"
+	InvalidArgumentsCode = "invalid_arguments"
+	ForbiddenCode        = "forbidden"
+)
+
+// CommandError explains why a command couldn't be run.
+type CommandError struct {
+	Code    string
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return e.Message
+}
+
+func commandError(code string, format string, args ...interface{}) *CommandError {
+	return &CommandError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// forbidden is the error for members without the permissions to do something.
+func forbidden(format string, args ...interface{}) *CommandError {
+	return commandError(ForbiddenCode, format, args...)
+}
+
+func isCommand(message string) bool {
+	return strings.HasPrefix(message, CommandPrefix) && !strings.HasPrefix(message, CommandPrefix+CommandPrefix)
+}
+
+// unescapeCommand turns "//text" into "/text".
+func unescapeCommand(message string) string {
+	if strings.HasPrefix(message, CommandPrefix+CommandPrefix) {
+		return message[len(CommandPrefix):]
+	}
+	return message
+}
+
+// command runs with the arguments split into fields, and the text following
+// the command name as is, for commands taking free text. Commands run with
+// c.mu held.
+type command struct {
+	usage   string
+	minArgs int
+	run     func(c *ChatRoom, ctx context.Context, tag string, args []string, text string) error
+}
+
+// errUsage is returned by commands given the wrong arguments
+var errUsage = errors.New("usage")
+
+var commands = map[string]command{
+	"me":     {"/me <action>", 1, (*ChatRoom).me},
+	"topic":  {"/topic <topic>", 1, (*ChatRoom).topic},
+	"nick":   {"/nick <new tag>", 1, (*ChatRoom).nick},
+	"kick":   {"/kick <tag> [reason]", 1, (*ChatRoom).kick},
+	"mode":   {"/mode <changes>, or /mode +o|-o <tag>", 1, (*ChatRoom).mode},
+	"invite": {"/invite <tag>", 1, (*ChatRoom).invite},
+}
+
+// runCommand runs a message starting with CommandPrefix as a command, as the
+// member with the given tag. Must be called with c.mu held.
+func (c *ChatRoom) runCommand(ctx context.Context, tag string, message string) error {
+	line := strings.TrimPrefix(message, CommandPrefix)
+	name := line
+	text := ""
+	if i := strings.IndexAny(line, " \t"); i >= 0 {
+		name, text = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	command, ok := commands[strings.ToLower(name)]
+	if !ok {
+		return commandError(UnknownCommandCode, "unknown command %s%s", CommandPrefix, name)
+	}
+	args := strings.Fields(text)
+	if len(args) < command.minArgs {
+		return commandError(InvalidArgumentsCode, "usage: %s", command.usage)
+	}
+
+	if !c.hasJoined(tag) {
+		return fmt.Errorf(`"%s" hasn't joined room %+v`, tag, c.ProxyMetadata)
+	}
+
+	c.seen(ctx, tag)
+	err := command.run(c, ctx, tag, args, text)
+	if err == errUsage {
+		return commandError(InvalidArgumentsCode, "usage: %s", command.usage)
+	}
+	return err
+}
+
+func (c *ChatRoom) me(ctx context.Context, tag string, args []string, text string) error {
+	return c.submit(ctx, ActionEvent, tag, "", text)
+}
+
+func (c *ChatRoom) topic(ctx context.Context, tag string, args []string, text string) error {
+	return c.setTopic(ctx, tag, text)
+}
+
+func (c *ChatRoom) nick(ctx context.Context, tag string, args []string, text string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	newTag := args[0]
+	if !validTag(newTag) {
+		return commandError(InvalidArgumentsCode, `"%s" is not a valid tag`, newTag)
+	}
+	if _, ok := c.members[newTag]; ok {
+		return commandError(InvalidArgumentsCode, `"%s" is already in room %+v`, newTag, c.ProxyMetadata)
+	}
+	if c.isIncomingWebhook(newTag) {
+		return commandError(InvalidArgumentsCode, `"%s" is the name of a webhook of room %+v`, newTag, c.ProxyMetadata)
+	}
+	// Banned tags may not be taken, just as they may not join
+	if c.bans.banned(newTag) {
+		return forbidden(`"%s" is banned from this server`, newTag)
+	}
+	// Nor may the creator's, which would bring the creator's rights with it
+	if newTag == c.Creator {
+		return forbidden(`"%s" is the creator of room %+v`, newTag, c.ProxyMetadata)
+	}
+
+	c.logAction(ctx, audit.Entry{Action: audit.MemberNick, Actor: tag, Target: newTag, Before: tagState(tag), After: tagState(newTag)})
+	c.members[newTag] = c.members[tag]
+	delete(c.members, tag)
+	// The creator's rights follow them, rather than staying with a tag anyone
+	// may now join as
+	if tag == c.Creator {
+		c.Creator = newTag
+	}
+	c.renameHeld(tag, newTag)
+	return c.broadcast(ctx, "", CallbackBody{Type: NickEvent, Tag: tag, Target: newTag})
+}
+
+// validTag reports whether a member may take tag, which must be usable as a
+// segment of the /members/{tag} URL paths.
+func validTag(tag string) bool {
+	return tag != "" && !strings.ContainsAny(tag, "/?#%") && strings.IndexFunc(tag, unicode.IsControl) < 0
+}
+
+func (c *ChatRoom) kick(ctx context.Context, tag string, args []string, text string) error {
+	if err := c.requireOperator(tag); err != nil {
+		return err
+	}
+	target := args[0]
+	if !c.hasJoined(target) {
+		return commandError(InvalidArgumentsCode, `"%s" is not in room %+v`, target, c.ProxyMetadata)
+	}
+
+	reason := "kicked by " + tag
+	if why := strings.TrimSpace(strings.TrimPrefix(text, target)); why != "" {
+		reason += ": " + why
+	}
+	c.logAction(ctx, audit.Entry{Action: audit.MemberKick, Actor: tag, Target: target, Reason: reason, Before: c.memberState(target)})
+	// The kicked member hears of it too, before it's removed
+	err := c.broadcast(ctx, "", CallbackBody{Type: LeaveEvent, Tag: target, Reason: reason})
+	c.removeMember(target)
+	return err
+}
+
+func (c *ChatRoom) mode(ctx context.Context, tag string, args []string, text string) error {
+	if err := c.requireOperator(tag); err != nil {
+		return err
+	}
+
+	if args[0] == "+o" || args[0] == "-o" {
+		if len(args) != 2 {
+			return errUsage
+		}
+		target, ok := c.members[args[1]]
+		if !ok {
+			return commandError(InvalidArgumentsCode, `"%s" is not in room %+v`, args[1], c.ProxyMetadata)
+		}
+		before := c.memberState(args[1])
+		target.operator = args[0] == "+o"
+		c.logAction(ctx, audit.Entry{Action: audit.MemberRole, Actor: tag, Target: args[1], Before: before, After: c.memberState(args[1])})
+		return c.broadcast(ctx, "", CallbackBody{Type: ModeEvent, Tag: tag, Modes: args[0], Target: args[1]})
+	}
+
+	if len(args) != 1 {
+		return errUsage
+	}
+	return c.applyModes(ctx, tag, args[0])
+}
+
+func (c *ChatRoom) invite(ctx context.Context, tag string, args []string, text string) error {
+	if c.hasMode(InviteOnlyMode) && !c.isOperator(tag) {
+		return forbidden("only operators may invite to invite-only room %+v", c.ProxyMetadata)
+	}
+	if len(args) != 1 {
+		return errUsage
+	}
+	target := args[0]
+	if c.hasJoined(target) {
+		return commandError(InvalidArgumentsCode, `"%s" is already in room %+v`, target, c.ProxyMetadata)
+	}
+
+	c.invited[target] = true
+	c.logAction(ctx, audit.Entry{Action: audit.MemberInvite, Actor: tag, Target: target})
+	return c.broadcast(ctx, "", CallbackBody{Type: InviteEvent, Tag: tag, Target: target})
+}
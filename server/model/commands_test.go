@@ -0,0 +1,179 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func commandCodeOf(err error) string {
+	if failed, ok := err.(*CommandError); ok {
+		return failed.Code
+	}
+	return ""
+}
+
+func newCommandRoom() (*ChatRoom, *fakeDispatcher) {
+	dispatcher := newFakeDispatcher()
+	room := newChatRoom(roomId, roomName, time.Now, dispatcher)
+	room.Creator = "alice"
+	room.Join(context.Background(), "alice", "alice-callback")
+	room.Join(context.Background(), "bob", "bob-callback")
+	return room, dispatcher
+}
+
+func TestCommands(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("unknown commands and bad arguments", func(t *testing.T) {
+		room, dispatcher := newCommandRoom()
+
+		err := room.PostMessage(ctx, "alice", "/dance")
+		assert.Equal(t, UnknownCommandCode, commandCodeOf(err))
+		err = room.PostMessage(ctx, "alice", "/kick")
+		assert.Equal(t, InvalidArgumentsCode, commandCodeOf(err))
+		assert.EqualError(t, err, "usage: /kick <tag> [reason]")
+		err = room.PostMessage(ctx, "alice", "/nick al ice")
+		assert.Equal(t, InvalidArgumentsCode, commandCodeOf(err))
+
+		assert.Empty(t, dispatcher.received("bob"))
+	})
+
+	t.Run("escaped commands are posted as messages", func(t *testing.T) {
+		room, dispatcher := newCommandRoom()
+
+		err := room.PostMessage(ctx, "alice", "//me is not a command")
+		assert.Nil(t, err)
+		assert.Equal(t, []CallbackBody{{Type: MessageEvent, Id: 1, Tag: "alice", Message: "/me is not a command"}}, dispatcher.received("bob"))
+	})
+
+	t.Run("me", func(t *testing.T) {
+		room, dispatcher := newCommandRoom()
+
+		err := room.PostMessage(ctx, "alice", "/me waves")
+		assert.Nil(t, err)
+		assert.Equal(t, []CallbackBody{{Type: ActionEvent, Id: 1, Tag: "alice", Message: "waves"}}, dispatcher.received("bob"))
+		assert.Empty(t, dispatcher.received("alice"))
+	})
+
+	t.Run("topic", func(t *testing.T) {
+		room, _ := newCommandRoom()
+
+		err := room.PostMessage(ctx, "bob", "/topic release day")
+		assert.Nil(t, err)
+		assert.Equal(t, "release day", room.Topic.Text)
+
+		room.SetModes(ctx, "alice", "+t")
+		err = room.PostMessage(ctx, "bob", "/topic bob's day")
+		assert.Equal(t, ForbiddenCode, commandCodeOf(err))
+		err = room.PostMessage(ctx, "alice", "/topic alice's day")
+		assert.Nil(t, err)
+		assert.Equal(t, "alice's day", room.Topic.Text)
+	})
+
+	t.Run("nick", func(t *testing.T) {
+		room, dispatcher := newCommandRoom()
+
+		err := room.PostMessage(ctx, "alice", "/nick bob")
+		assert.Equal(t, InvalidArgumentsCode, commandCodeOf(err))
+
+		err = room.PostMessage(ctx, "alice", "/nick ali/ce")
+		assert.Equal(t, InvalidArgumentsCode, commandCodeOf(err))
+		err = room.PostMessage(ctx, "alice", "/nick ali?ce")
+		assert.Equal(t, InvalidArgumentsCode, commandCodeOf(err))
+
+		err = room.PostMessage(ctx, "alice", "/nick alicia")
+		assert.Nil(t, err)
+		assert.False(t, room.HasJoined("alice"))
+		assert.True(t, room.HasJoined("alicia"))
+		assert.True(t, room.IsOperator("alicia"))
+		assert.Equal(t, []CallbackBody{{Type: NickEvent, Tag: "alice", Target: "alicia"}}, dispatcher.received("bob"))
+	})
+
+	t.Run("creator's rights follow a nick", func(t *testing.T) {
+		room, _ := newCommandRoom()
+		room.SetModes(ctx, "alice", "+i")
+
+		assert.Nil(t, room.PostMessage(ctx, "alice", "/nick alicia"))
+		assert.Equal(t, "alicia", room.Creator)
+		assert.NotNil(t, room.Join(ctx, "alice", "mallory-callback"))
+
+		room.SetModes(ctx, "alicia", "-i")
+		assert.Nil(t, room.Join(ctx, "alice", "mallory-callback"))
+		assert.False(t, room.IsOperator("alice"))
+		_, err := room.Import("alice", nil)
+		assert.Equal(t, ForbiddenCode, commandCodeOf(err))
+
+		// Nor may anyone take the creator's tag while they're away
+		assert.Nil(t, room.Leave(ctx, "alicia"))
+		err = room.PostMessage(ctx, "bob", "/nick alicia")
+		assert.Equal(t, ForbiddenCode, commandCodeOf(err))
+	})
+
+	t.Run("kick", func(t *testing.T) {
+		room, dispatcher := newCommandRoom()
+
+		err := room.PostMessage(ctx, "bob", "/kick alice")
+		assert.Equal(t, ForbiddenCode, commandCodeOf(err))
+		err = room.PostMessage(ctx, "alice", "/kick carol")
+		assert.Equal(t, InvalidArgumentsCode, commandCodeOf(err))
+
+		err = room.PostMessage(ctx, "alice", "/kick bob too loud")
+		assert.Nil(t, err)
+		assert.False(t, room.HasJoined("bob"))
+		kicked := CallbackBody{Type: LeaveEvent, Tag: "bob", Reason: "kicked by alice: too loud"}
+		assert.Equal(t, []CallbackBody{kicked}, dispatcher.received("bob"))
+		assert.Equal(t, []CallbackBody{kicked}, dispatcher.received("alice"))
+	})
+
+	t.Run("mode", func(t *testing.T) {
+		room, dispatcher := newCommandRoom()
+
+		err := room.PostMessage(ctx, "bob", "/mode +m")
+		assert.Equal(t, ForbiddenCode, commandCodeOf(err))
+		err = room.PostMessage(ctx, "alice", "/mode +x")
+		assert.Equal(t, InvalidArgumentsCode, commandCodeOf(err))
+
+		err = room.PostMessage(ctx, "alice", "/mode +mt")
+		assert.Nil(t, err)
+		assert.Equal(t, "mt", room.Modes)
+
+		err = room.PostMessage(ctx, "alice", "/mode +o bob")
+		assert.Nil(t, err)
+		assert.True(t, room.IsOperator("bob"))
+		err = room.PostMessage(ctx, "bob", "/mode -o alice")
+		assert.Nil(t, err)
+		assert.False(t, room.IsOperator("alice"))
+
+		assert.Equal(t, []CallbackBody{
+			{Type: ModeEvent, Tag: "alice", Modes: "mt"},
+			{Type: ModeEvent, Tag: "alice", Modes: "+o", Target: "bob"},
+			{Type: ModeEvent, Tag: "bob", Modes: "-o", Target: "alice"},
+		}, dispatcher.received("bob"))
+	})
+
+	t.Run("invite", func(t *testing.T) {
+		room, dispatcher := newCommandRoom()
+		room.SetModes(ctx, "alice", "+i")
+
+		err := room.Join(ctx, "carol", "carol-callback")
+		assert.NotNil(t, err)
+		err = room.PostMessage(ctx, "bob", "/invite carol")
+		assert.Equal(t, ForbiddenCode, commandCodeOf(err))
+		err = room.PostMessage(ctx, "alice", "/invite bob")
+		assert.Equal(t, InvalidArgumentsCode, commandCodeOf(err))
+
+		err = room.PostMessage(ctx, "alice", "/invite carol")
+		assert.Nil(t, err)
+		assert.Equal(t, []CallbackBody{
+			{Type: ModeEvent, Tag: "alice", Modes: "i"},
+			{Type: InviteEvent, Tag: "alice", Target: "carol"},
+		}, dispatcher.received("bob"))
+
+		assert.Nil(t, room.Join(ctx, "carol", "carol-callback"))
+		room.Leave(ctx, "carol")
+		assert.NotNil(t, room.Join(ctx, "carol", "carol-callback"), "invites are used up by joining")
+	})
+}
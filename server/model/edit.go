@@ -0,0 +1,171 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"irc/server/audit"
+	"irc/server/hook"
+	"irc/server/search"
+	"time"
+)
+
+// DefaultEditWindow is how long after posting a message its author may edit
+// or delete it by default.
+const DefaultEditWindow = 15 * time.Minute
+
+// WithEditWindow sets how long after posting a message its author may edit
+// or delete it. Operators may do so at any time. With a zero window, only
+// operators may. Defaults to DefaultEditWindow.
+func WithEditWindow(window time.Duration) StoreOption {
+	return func(s *ChatRoomStore) {
+		s.editWindow = window
+	}
+}
+
+// Edit is an earlier version of an edited message: the text it had until
+// EditedBy edited it at EditedAt.
+type Edit struct {
+	Text     string    `json:"text"`
+	EditedBy string    `json:"editedBy"`
+	EditedAt time.Time `json:"editedAt"`
+}
+
+// GetMessage returns the message, or action, with the given ID, with its
+// edits, if the room still keeps it.
+func (c *ChatRoom) GetMessage(id int64) (Message, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i, ok := c.findMessage(id)
+	if !ok {
+		return Message{}, errNoMessage(id, c.ProxyMetadata)
+	}
+	return c.history[i], nil
+}
+
+// EditMessage replaces the text of the message, or action, with the given
+// ID, keeping the text it had among its edits, and tells every member but
+// the one editing it. Edited messages go through the room's hooks and
+// message policy like posted ones, but may not be split.
+func (c *ChatRoom) EditMessage(ctx context.Context, tag string, id int64, text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i, err := c.changeableMessage(tag, id)
+	if err != nil {
+		return err
+	}
+	m := &c.history[i]
+	// Edits would skip the review posted messages get
+	if c.moderated(tag) {
+		return forbidden("only operators may edit messages in moderated room %+v", c.ProxyMetadata)
+	}
+
+	text, verdict, err := c.runHooks(ctx, m.Type, tag, text)
+	if err != nil {
+		return err
+	}
+	if verdict.Action == hook.Hold {
+		return invalidMessage(RejectedCode, "edit rejected by hook %q, which would hold it for review: %s", verdict.Hook, verdict.Reason)
+	}
+	parts, err := c.policy.Apply(text)
+	if err != nil {
+		return err
+	}
+	if len(parts) > 1 {
+		return invalidMessage(MessageTooLongCode, "message is %d bytes long, more than the maximum of %d", len(text), c.policy.MaxLength)
+	}
+	decision, err := c.checkSpam(ctx, tag, parts[0])
+	if err != nil {
+		return err
+	}
+	c.warnSpam(ctx, decision)
+
+	before := m.Text
+	edits := append([]Edit(nil), m.Edits...)
+	m.Edits = append(edits, Edit{Text: m.Text, EditedBy: tag, EditedAt: c.clock()})
+	m.Text = parts[0]
+	if c.index != nil {
+		c.index.Add(search.Document{Room: c.Id, Id: m.Id, Tag: m.Tag, Text: m.Text, Time: m.Time})
+	}
+	if tag != m.Tag {
+		c.logAction(ctx, audit.Entry{Action: audit.MessageEdit, Actor: tag, Target: m.Tag, Before: textState(m.Id, before), After: textState(m.Id, m.Text)})
+	}
+	return c.broadcast(ctx, tag, CallbackBody{Type: MessageEditedEvent, Id: m.Id, Tag: tag, Target: m.Tag, Message: m.Text})
+}
+
+// DeleteMessage removes the message, or action, with the given ID from the
+// history and search, and tells every member but the one deleting it.
+func (c *ChatRoom) DeleteMessage(ctx context.Context, tag string, id int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i, err := c.changeableMessage(tag, id)
+	if err != nil {
+		return err
+	}
+	m := c.history[i]
+
+	c.history = append(c.history[:i:i], c.history[i+1:]...)
+	if c.index != nil {
+		c.index.Remove(c.Id, m.Id)
+	}
+	if tag != m.Tag {
+		c.logAction(ctx, audit.Entry{Action: audit.MessageDelete, Actor: tag, Target: m.Tag, Before: textState(m.Id, m.Text)})
+	}
+	return c.broadcast(ctx, tag, CallbackBody{Type: MessageDeletedEvent, Id: m.Id, Tag: tag, Target: m.Tag})
+}
+
+// changeableMessage finds the message, or action, with the given ID for the
+// member with the given tag to edit or delete, which operators may always
+// do, and its author only within the room's edit window. Messages posted
+// with webhooks, and imported ones, have no author. Must be called with
+// c.mu held.
+func (c *ChatRoom) changeableMessage(tag string, id int64) (int, error) {
+	if !c.hasJoined(tag) {
+		return 0, fmt.Errorf(`"%s" hasn't joined room %+v`, tag, c.ProxyMetadata)
+	}
+	i, ok := c.findMessage(id)
+	if !ok {
+		return 0, errNoMessage(id, c.ProxyMetadata)
+	}
+
+	m := c.history[i]
+	switch {
+	case c.isOperator(tag):
+		return i, nil
+	case m.Webhook != "":
+		return 0, forbidden("only operators may change the messages of webhooks in room %+v", c.ProxyMetadata)
+	case m.author == "" || m.author != c.members[tag].id:
+		return 0, forbidden("only operators may change the messages of others in room %+v", c.ProxyMetadata)
+	case c.clock().Sub(m.Time) >= c.editWindow:
+		return 0, forbidden("messages may only be changed within %s of being posted", c.editWindow)
+	}
+	return i, nil
+}
+
+// findMessage finds the index in the history of the message, or action,
+// with the given ID. Must be called with c.mu held.
+func (c *ChatRoom) findMessage(id int64) (int, bool) {
+	// Imported messages are numbered out of order, but recent ones are the
+	// likeliest to change
+	for i := len(c.history) - 1; i >= 0; i-- {
+		m := c.history[i]
+		if m.Id == id && (m.Type == MessageEvent || m.Type == ActionEvent) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func errNoMessage(id int64, room ProxyMetadata) error {
+	return fmt.Errorf("message %d does not exist in room %+v", id, room)
+}
+
+func textState(id int64, text string) json.RawMessage {
+	return audit.State(struct {
+		Id   int64  `json:"id"`
+		Text string `json:"text"`
+	}{id, text})
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"context"
+	"irc/server/search"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditMessages(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	dispatcher := newFakeDispatcher()
+	store := NewChatRoomStore(WithDispatcher(dispatcher), WithClock(clock.Now), WithEditWindow(time.Minute))
+	id, _ := store.AddProxy(ctx, roomName, "alice")
+	room, _ := store.GetProxy(id)
+	room.Join(ctx, "alice", "alice-callback")
+	room.Join(ctx, "bob", "bob-callback")
+	room.Join(ctx, "carol", "carol-callback")
+	room.PostMessage(ctx, "bob", "helo")
+	room.PostMessage(ctx, "bob", "/me typos")
+	room.PostMessage(ctx, "carol", "hi")
+	searchFor := func(text string) []int64 {
+		clauses, err := search.ParseQuery(text)
+		assert.Nil(t, err)
+		results, _ := store.Search(search.Query{Clauses: clauses}, "")
+		ids := []int64{}
+		for _, result := range results {
+			ids = append(ids, result.Id)
+		}
+		return ids
+	}
+
+	t.Run("authors edit their messages", func(t *testing.T) {
+		clock.Advance(time.Second)
+		assert.Nil(t, room.EditMessage(ctx, "bob", 1, "hello"))
+		assert.Nil(t, room.EditMessage(ctx, "bob", 1, "hello!"))
+		events := dispatcher.received("carol")
+		assert.Equal(t, CallbackBody{Type: MessageEditedEvent, Id: 1, Tag: "bob", Target: "bob", Message: "hello!"}, events[len(events)-1])
+
+		message, err := room.GetMessage(1)
+		assert.Nil(t, err)
+		assert.Equal(t, "hello!", message.Text)
+		assert.Equal(t, []Edit{
+			{Text: "helo", EditedBy: "bob", EditedAt: clock.Now()},
+			{Text: "hello", EditedBy: "bob", EditedAt: clock.Now()},
+		}, message.Edits)
+		assert.Equal(t, []int64{1}, searchFor("hello"))
+		assert.Empty(t, searchFor("helo"))
+	})
+
+	t.Run("only operators change others' messages", func(t *testing.T) {
+		assert.Equal(t, ForbiddenCode, commandCodeOf(room.EditMessage(ctx, "carol", 1, "mine now")))
+		assert.Equal(t, ForbiddenCode, commandCodeOf(room.DeleteMessage(ctx, "carol", 1)))
+		assert.Nil(t, room.EditMessage(ctx, "alice", 2, "fixed typos"))
+		message, _ := room.GetMessage(2)
+		assert.Equal(t, ActionEvent, message.Type)
+		assert.Equal(t, "fixed typos", message.Text)
+	})
+
+	t.Run("authors only have the edit window", func(t *testing.T) {
+		clock.Advance(time.Minute)
+		assert.Equal(t, ForbiddenCode, commandCodeOf(room.EditMessage(ctx, "carol", 3, "hey")))
+		assert.Equal(t, ForbiddenCode, commandCodeOf(room.DeleteMessage(ctx, "carol", 3)))
+		assert.Nil(t, room.DeleteMessage(ctx, "alice", 3), "operators always may")
+	})
+
+	t.Run("deleted messages are forgotten", func(t *testing.T) {
+		events := dispatcher.received("bob")
+		assert.Equal(t, CallbackBody{Type: MessageDeletedEvent, Id: 3, Tag: "alice", Target: "carol"}, events[len(events)-1])
+		_, err := room.GetMessage(3)
+		assert.EqualError(t, err, "message 3 does not exist in room {Id:0 Name:"+roomName+"}")
+		assert.Empty(t, searchFor("hi"))
+		assert.Error(t, room.EditMessage(ctx, "alice", 3, "back"))
+
+		var texts []string
+		room.ReadHistory(time.Time{}, time.Time{}, func(messages []Message) error {
+			for _, m := range messages {
+				if m.Id != 0 {
+					texts = append(texts, m.Text)
+				}
+			}
+			return nil
+		})
+		assert.Equal(t, []string{"hello!", "fixed typos"}, texts)
+	})
+
+	t.Run("edits are validated", func(t *testing.T) {
+		assert.Equal(t, EmptyMessageCode, codeOf(room.EditMessage(ctx, "alice", 1, " ")))
+		_, err := room.GetMessage(99)
+		assert.Error(t, err)
+	})
+
+	t.Run("tags don't make authors", func(t *testing.T) {
+		assert.Nil(t, room.PostMessage(ctx, "carol", "bye"))
+		assert.Nil(t, room.Leave(ctx, "carol"))
+		assert.Nil(t, room.Join(ctx, "carol", "mallory-callback"))
+		assert.Equal(t, ForbiddenCode, commandCodeOf(room.EditMessage(ctx, "carol", 4, "mine now")), "another member may take a departed author's tag")
+
+		hook, _ := room.AddIncomingWebhook(ctx, "alice", "Deploy Bot")
+		assert.Nil(t, room.PostWithWebhook(ctx, hook.Token, "deployed"))
+		message, _ := room.GetMessage(5)
+		assert.Equal(t, hook.Id, message.Webhook)
+		assert.NotNil(t, room.Join(ctx, "Deploy Bot", "mallory-callback"), "members may not take a webhook's name")
+		assert.Nil(t, room.RemoveIncomingWebhook(ctx, "alice", hook.Id))
+		assert.Nil(t, room.Join(ctx, "Deploy Bot", "mallory-callback"))
+		assert.Equal(t, ForbiddenCode, commandCodeOf(room.DeleteMessage(ctx, "Deploy Bot", 5)), "members may not change webhooks' messages")
+		assert.Nil(t, room.DeleteMessage(ctx, "alice", 5))
+	})
+}
@@ -0,0 +1,279 @@
+package model
+
+import (
+	"fmt"
+	"irc/server/search"
+	"sort"
+	"time"
+)
+
+// DefaultHistoryLimit is how many messages and events each room keeps by
+// default.
+const DefaultHistoryLimit = 1000
+
+// Message is a message, action or other event kept in a room's history.
+// Rooms number their messages and actions from 1, in the order they're
+// posted, and each part of a split message gets its own ID; other events
+// have none.
+type Message struct {
+	Id     int64     `json:"id,omitempty"`
+	Type   string    `json:"type"`
+	Tag    string    `json:"tag,omitempty"`
+	Text   string    `json:"text,omitempty"`
+	Target string    `json:"target,omitempty"`
+	Modes  string    `json:"modes,omitempty"`
+	Time   time.Time `json:"time"`
+	Part   int       `json:"part,omitempty"`
+	Parts  int       `json:"parts,omitempty"`
+	// Imported is set on entries imported from another server's logs.
+	Imported bool `json:"imported,omitempty"`
+	// Edits are the earlier versions of an edited message, oldest first.
+	Edits []Edit `json:"edits,omitempty"`
+	// Webhook is the ID of the incoming webhook a message was posted with,
+	// if it was posted with one.
+	Webhook string `json:"webhook,omitempty"`
+
+	// seq orders every entry of the history, so it can be read in batches
+	seq int64
+	// author is the id of the member who posted a message, which tags don't
+	// tell apart. Messages posted with webhooks and imported ones have none.
+	author string
+}
+
+// historyEvents are the event types broadcast to members that are kept in
+// the history. Joins, leaves and mode changes that members aren't sent are
+// recorded where they happen.
+var historyEvents = map[string]bool{
+	MessageEvent: true,
+	ActionEvent:  true,
+	NoticeEvent:  true,
+	TopicEvent:   true,
+	LeaveEvent:   true,
+	NickEvent:    true,
+	ModeEvent:    true,
+	InviteEvent:  true,
+}
+
+// remember records a broadcast event in the history if it's one of
+// historyEvents. Must be called with c.mu held.
+func (c *ChatRoom) remember(body CallbackBody) {
+	if !historyEvents[body.Type] {
+		return
+	}
+
+	message := Message{
+		Id:      body.Id,
+		Type:    body.Type,
+		Tag:     body.Tag,
+		Text:    body.Message,
+		Target:  body.Target,
+		Modes:   body.Modes,
+		Time:    c.clock(),
+		Part:    body.Part,
+		Parts:   body.Parts,
+		Webhook: body.Webhook,
+	}
+	if member, ok := c.members[body.Tag]; ok && body.Webhook == "" {
+		message.author = member.id
+	}
+	if body.Time != nil {
+		message.Time = *body.Time
+	}
+	switch {
+	case body.Topic != nil:
+		message.Tag, message.Text, message.Time = body.Topic.SetBy, body.Topic.Text, body.Topic.SetAt
+	case body.Reason != "":
+		message.Text = body.Reason
+	}
+	c.record(message)
+}
+
+// record adds an entry to the history, and messages to the search index,
+// forgetting the oldest entries beyond the room's history limit. Must be
+// called with c.mu held.
+func (c *ChatRoom) record(message Message) {
+	c.lastSeq += 1
+	message.seq = c.lastSeq
+	c.history = append(c.history, message)
+	if c.index != nil && message.Id != 0 {
+		c.index.Add(search.Document{Room: c.Id, Id: message.Id, Tag: message.Tag, Text: message.Text, Time: message.Time})
+	}
+
+	c.forget()
+}
+
+// forget forgets the oldest entries beyond the room's history limit. Must be
+// called with c.mu held.
+func (c *ChatRoom) forget() {
+	excess := len(c.history) - c.historyLimit
+	if excess <= 0 {
+		return
+	}
+	if c.index != nil {
+		for _, old := range c.history[:excess] {
+			if old.Id != 0 {
+				c.index.Remove(c.Id, old.Id)
+			}
+		}
+	}
+	c.history = append([]Message(nil), c.history[excess:]...)
+}
+
+// ImportResult counts the entries an import added to a room's history, and
+// those it skipped because the history already had them.
+type ImportResult struct {
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+}
+
+// historyKey identifies an entry of the history by what it says and when,
+// to tell whether an imported entry is already there.
+type historyKey struct {
+	kind, tag, text, target, modes string
+	time                           int64
+}
+
+func keyOf(m Message) historyKey {
+	return historyKey{m.Type, m.Tag, m.Text, m.Target, m.Modes, m.Time.UnixNano()}
+}
+
+// Import merges entries from another server's logs into the history, in
+// time order. Entries the history already has are skipped, so importing the
+// same log twice adds it once. Imported messages and actions are numbered
+// and indexed for search like posted ones, but nobody is sent them, and
+// they're held to the room's message policy like posted ones too: the
+// import is rejected if any would be. Only operators and the room's
+// creator, who may not have joined yet, may import. History read while
+// importing may be read twice.
+func (c *ChatRoom) Import(tag string, messages []Message) (ImportResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if (tag != c.Creator || tag == "") && !c.isOperator(tag) {
+		return ImportResult{}, forbidden(`"%s" is not an operator of room %+v`, tag, c.ProxyMetadata)
+	}
+	messages, err := c.checkImport(messages)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	// Counted rather than flagged, so that a line said twice in the same
+	// minute is imported twice, but only once however many times it's
+	// imported
+	existing := make(map[historyKey]int, len(c.history))
+	for _, m := range c.history {
+		existing[keyOf(m)] += 1
+	}
+
+	var result ImportResult
+	merged := append([]Message(nil), c.history...)
+	for _, m := range messages {
+		key := keyOf(m)
+		if existing[key] > 0 {
+			existing[key] -= 1
+			result.Duplicates += 1
+			continue
+		}
+		m.Imported = true
+		merged = append(merged, m)
+		result.Imported += 1
+	}
+	if result.Imported == 0 {
+		return result, nil
+	}
+
+	// Entries already in the history come first among those at the same time
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Time.Before(merged[j].Time)
+	})
+	for i := range merged {
+		m := &merged[i]
+		// Only the entries just imported are unnumbered
+		if m.seq == 0 && (m.Type == MessageEvent || m.Type == ActionEvent) {
+			c.lastMessageId += 1
+			m.Id = c.lastMessageId
+			if c.index != nil {
+				c.index.Add(search.Document{Room: c.Id, Id: m.Id, Tag: m.Tag, Text: m.Text, Time: m.Time})
+			}
+		}
+		c.lastSeq += 1
+		m.seq = c.lastSeq
+	}
+	c.history = merged
+	c.forget()
+	return result, nil
+}
+
+// checkImport checks the types of imported entries, and runs the text of
+// messages and actions through the room's message policy, splitting those
+// it splits into parts. Must be called with c.mu held.
+func (c *ChatRoom) checkImport(messages []Message) ([]Message, error) {
+	applied := make([]Message, 0, len(messages))
+	for i, m := range messages {
+		if !historyEvents[m.Type] && m.Type != JoinEvent {
+			return nil, fmt.Errorf("unknown event type %q", m.Type)
+		}
+		if m.Type != MessageEvent && m.Type != ActionEvent {
+			applied = append(applied, m)
+			continue
+		}
+
+		parts, err := c.policy.Apply(m.Text)
+		if invalid, ok := err.(*ValidationError); ok {
+			return nil, invalidMessage(invalid.Code, "entry %d: %s", i+1, invalid.Message)
+		}
+		for j, part := range parts {
+			m.Text = part
+			if len(parts) > 1 {
+				m.Part, m.Parts = j+1, len(parts)
+			}
+			applied = append(applied, m)
+		}
+	}
+	return applied, nil
+}
+
+// historyBatch is how many entries ReadHistory copies at a time
+const historyBatch = 100
+
+// ReadHistory calls fn with the entries of the history at or after from and
+// before to, oldest first, in batches. The room isn't locked while fn runs,
+// so it may take its time, e.g. writing to a slow client; entries posted
+// meanwhile are read too, and those forgotten meanwhile are skipped. A zero
+// from or to leaves that end open. It stops at the first error fn returns.
+func (c *ChatRoom) ReadHistory(from time.Time, to time.Time, fn func([]Message) error) error {
+	var after int64
+	for {
+		batch := c.historyBatch(after, from, to)
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+		after = batch[len(batch)-1].seq
+	}
+}
+
+// historyBatch copies up to historyBatch entries following the one numbered
+// after, in the time range [from, to).
+func (c *ChatRoom) historyBatch(after int64, from time.Time, to time.Time) []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := sort.Search(len(c.history), func(i int) bool {
+		return c.history[i].seq > after
+	})
+
+	var batch []Message
+	for _, message := range c.history[start:] {
+		if len(batch) == historyBatch {
+			break
+		}
+		if message.Time.Before(from) || (!to.IsZero() && !message.Time.Before(to)) {
+			continue
+		}
+		batch = append(batch, message)
+	}
+	return batch
+}
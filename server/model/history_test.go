@@ -0,0 +1,140 @@
+package model
+
+import (
+	"context"
+	"irc/server/search"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+	ctx := context.Background()
+	store := NewChatRoomStore(WithDispatcher(newFakeDispatcher()), WithHistoryLimit(2))
+	id, _ := store.AddProxy(ctx, roomName, "")
+	room := store.chatRooms[id]
+	room.Join(ctx, "alice", "alice-callback")
+
+	query := func(text string) []int64 {
+		clauses, err := search.ParseQuery(text)
+		assert.Nil(t, err)
+		results, _ := store.Search(search.Query{Clauses: clauses}, "")
+		ids := []int64{}
+		for _, result := range results {
+			ids = append(ids, result.Id)
+		}
+		return ids
+	}
+
+	room.PostMessage(ctx, "alice", "first message")
+	room.PostMessage(ctx, "alice", "second message")
+	room.PostMessage(ctx, "alice", "/me posts a third message")
+
+	assert.Equal(t, []int64{2, 3}, []int64{room.history[0].Id, room.history[1].Id})
+	assert.Equal(t, ActionEvent, room.history[1].Type)
+	assert.Equal(t, []int64{3, 2}, query("message"), "forgotten messages can't be found")
+	assert.Empty(t, query("first"))
+
+	store.DeleteProxy(ctx, id)
+	assert.Empty(t, query("message"))
+}
+
+func TestReadHistory(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	start := clock.now
+	room := newChatRoom(roomId, roomName, clock.Now, newFakeDispatcher())
+	room.Join(ctx, "alice", "alice-callback")
+	room.Join(ctx, "bob", "bob-callback")
+	for i := 0; i < 2*historyBatch; i++ {
+		clock.Advance(time.Second)
+		room.PostMessage(ctx, "alice", "hello")
+	}
+	room.SetTopic(ctx, "bob", "greetings")
+	room.Leave(ctx, "bob")
+
+	var batches []int
+	var read []Message
+	err := room.ReadHistory(time.Time{}, time.Time{}, func(batch []Message) error {
+		batches = append(batches, len(batch))
+		read = append(read, batch...)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []int{historyBatch, historyBatch, 4}, batches)
+	assert.Equal(t, Message{Type: JoinEvent, Tag: "alice", Time: start, seq: 1}, read[0])
+	assert.Equal(t, int64(2*historyBatch), read[2*historyBatch+1].Id)
+	assert.Equal(t, []string{TopicEvent, LeaveEvent}, []string{read[len(read)-2].Type, read[len(read)-1].Type})
+	assert.Equal(t, "greetings", read[len(read)-2].Text)
+
+	read = nil
+	room.ReadHistory(start.Add(10*time.Second), start.Add(13*time.Second), func(batch []Message) error {
+		read = append(read, batch...)
+		return nil
+	})
+	assert.Equal(t, []int64{10, 11, 12}, []int64{read[0].Id, read[1].Id, read[2].Id})
+	assert.Len(t, read, 3)
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)}
+	store := NewChatRoomStore(WithDispatcher(newFakeDispatcher()), WithClock(clock.Now))
+	id, _ := store.AddProxy(ctx, roomName, "alice")
+	room := store.chatRooms[id]
+	room.Join(ctx, "alice", "alice-callback")
+	room.Join(ctx, "bob", "bob-callback")
+	room.PostMessage(ctx, "alice", "posted here")
+
+	at := func(minutes int) time.Time {
+		return clock.now.Add(time.Duration(minutes) * time.Minute)
+	}
+	log := []Message{
+		{Type: JoinEvent, Tag: "carol", Time: at(-10)},
+		{Type: MessageEvent, Tag: "carol", Text: "imported", Time: at(-5)},
+		{Type: MessageEvent, Tag: "carol", Text: "imported", Time: at(-5)},
+		{Type: ActionEvent, Tag: "carol", Text: "leaves", Time: at(5)},
+	}
+
+	_, err := room.Import("bob", log)
+	assert.Equal(t, ForbiddenCode, commandCodeOf(err))
+	_, err = room.Import("alice", []Message{{Type: "bogus", Time: at(0)}})
+	assert.EqualError(t, err, `unknown event type "bogus"`)
+
+	result, err := room.Import("alice", log)
+	assert.Nil(t, err)
+	assert.Equal(t, ImportResult{Imported: 4}, result)
+	result, _ = room.Import("alice", log)
+	assert.Equal(t, ImportResult{Duplicates: 4}, result, "importing twice adds nothing")
+
+	var types, texts []string
+	var ids []int64
+	for _, m := range room.history {
+		types, texts, ids = append(types, m.Type), append(texts, m.Text), append(ids, m.Id)
+	}
+	assert.Equal(t, []string{JoinEvent, MessageEvent, MessageEvent, JoinEvent, JoinEvent, MessageEvent, ActionEvent}, types)
+	assert.Equal(t, []string{"", "imported", "imported", "", "", "posted here", "leaves"}, texts)
+	assert.Equal(t, []int64{0, 2, 3, 0, 0, 1, 4}, ids)
+	assert.True(t, room.history[1].Imported)
+	assert.False(t, room.history[5].Imported)
+
+	room.PostMessage(ctx, "alice", "after the import")
+	assert.Equal(t, int64(5), room.history[len(room.history)-1].Id)
+
+	clauses, _ := search.ParseQuery("imported")
+	results, _ := store.Search(search.Query{Clauses: clauses}, "")
+	assert.Len(t, results, 2)
+
+	t.Run("message policy", func(t *testing.T) {
+		_, err := room.Import("alice", []Message{{Type: MessageEvent, Tag: "carol", Text: strings.Repeat("a", 5000), Time: at(10)}})
+		assert.Equal(t, MessageTooLongCode, codeOf(err))
+		assert.EqualError(t, err, "entry 1: message is 5000 bytes long, more than the maximum of 4000")
+
+		result, err := room.Import("alice", []Message{{Type: MessageEvent, Tag: "carol", Text: "a\r\nb", Time: at(10)}})
+		assert.Nil(t, err)
+		assert.Equal(t, ImportResult{Imported: 1}, result)
+		assert.Equal(t, "a b", room.history[len(room.history)-1].Text, "control characters are replaced as when posting")
+	})
+}
@@ -0,0 +1,100 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"irc/server/audit"
+	"irc/server/hook"
+)
+
+// Codes identifying why hooks kept a message from being posted
+const (
+	// RejectedCode rejects a message a hook rejected without a code of
+	// its own.
+	RejectedCode = "rejected"
+	// HookFailedCode rejects a message a hook failed on.
+	HookFailedCode = "hook_failed"
+)
+
+// WithMessageHooks runs the messages posted in every room through hooks,
+// before the room's own.
+func WithMessageHooks(hooks ...hook.Hook) StoreOption {
+	return func(s *ChatRoomStore) {
+		s.hooks = append(s.hooks, hooks...)
+	}
+}
+
+// runHooks runs a message about to be posted as the given event type
+// through the store's hooks, then the room's. It returns the message as
+// they rewrote it, and the verdict of the hook that held it back, if one
+// did, or an error if one of them rejected it or failed. Must be called
+// with c.mu held.
+func (c *ChatRoom) runHooks(ctx context.Context, eventType string, tag string, text string) (string, hook.Verdict, error) {
+	if len(c.serverHooks) == 0 && len(c.hooks) == 0 {
+		return text, hook.Verdict{}, nil
+	}
+
+	m := &hook.Message{RoomId: c.Id, RoomName: c.Name, Type: eventType, Tag: tag, Text: text}
+	for _, pipeline := range []hook.Pipeline{c.serverHooks, c.hooks} {
+		verdict, err := pipeline.Run(ctx, m)
+		var failed *hook.Error
+		if errors.As(err, &failed) {
+			return "", verdict, invalidMessage(HookFailedCode, "%v", failed)
+		}
+		if err != nil {
+			return "", verdict, err
+		}
+
+		switch verdict.Action {
+		case hook.Reject:
+			code := verdict.Code
+			if code == "" {
+				code = RejectedCode
+			}
+			return "", verdict, invalidMessage(code, "message rejected by hook %q: %s", verdict.Hook, verdict.Reason)
+		case hook.Hold:
+			return m.Text, verdict, nil
+		}
+	}
+	return m.Text, hook.Verdict{}, nil
+}
+
+// SetHooks replaces the room's hooks with those specs configure, which only
+// its operators may do.
+func (c *ChatRoom) SetHooks(ctx context.Context, tag string, specs []hook.Spec) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.requireOperator(tag); err != nil {
+		return err
+	}
+	pipeline, err := hook.NewPipeline(specs)
+	if err != nil {
+		return err
+	}
+
+	c.logAction(ctx, audit.Entry{Action: audit.RoomUpdate, Actor: tag, Before: hooksState(c.hookSpecs), After: hooksState(specs)})
+	c.hooks = pipeline
+	c.hookSpecs = append([]hook.Spec{}, specs...)
+	return nil
+}
+
+// GetHooks lists the specs of the room's hooks, in the order they run, which
+// only its operators may see.
+func (c *ChatRoom) GetHooks(tag string) ([]hook.Spec, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.requireOperator(tag); err != nil {
+		return nil, err
+	}
+	return append([]hook.Spec{}, c.hookSpecs...), nil
+}
+
+func hooksState(specs []hook.Spec) json.RawMessage {
+	if specs == nil {
+		specs = []hook.Spec{}
+	}
+	return audit.State(map[string][]hook.Spec{"hooks": specs})
+}
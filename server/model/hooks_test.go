@@ -0,0 +1,86 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"irc/server/hook"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// verdictHook gives every message the same verdict, or error.
+type verdictHook struct {
+	verdict hook.Verdict
+	err     error
+}
+
+func (h verdictHook) Name() string {
+	return "verdict"
+}
+
+func (h verdictHook) Apply(ctx context.Context, m *hook.Message) (hook.Verdict, error) {
+	return h.verdict, h.err
+}
+
+func TestMessageHooks(t *testing.T) {
+	ctx := context.Background()
+	dispatcher := newFakeDispatcher()
+	store := NewChatRoomStore(WithDispatcher(dispatcher), WithMessageHooks(verdictHook{}))
+	id, _ := store.AddProxy(ctx, roomName, "alice")
+	room, _ := store.GetProxy(id)
+	room.Join(ctx, "alice", "alice-callback")
+	room.Join(ctx, "bob", "bob-callback")
+
+	t.Run("operators configure hooks", func(t *testing.T) {
+		specs := []hook.Spec{
+			{Type: hook.RedactType, Words: []string{"darn"}},
+			{Type: hook.ReplaceType, Pattern: `\bu\b`, Replacement: "you"},
+		}
+		assert.Equal(t, ForbiddenCode, commandCodeOf(room.SetHooks(ctx, "bob", specs)))
+		assert.EqualError(t, room.SetHooks(ctx, "alice", []hook.Spec{{Type: "translate"}}), `hook 1: unknown hook type "translate"`)
+		assert.Nil(t, room.SetHooks(ctx, "alice", specs))
+
+		got, err := room.GetHooks("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, specs, got)
+	})
+
+	t.Run("messages are rewritten before they're posted", func(t *testing.T) {
+		assert.Nil(t, room.PostMessage(ctx, "bob", "darn u"))
+		assert.Nil(t, room.PostMessage(ctx, "bob", "/me says darn"))
+		events := dispatcher.received("alice")
+		assert.Equal(t, "**** you", events[0].Message)
+		assert.Equal(t, "says ****", events[1].Message)
+
+		var texts []string
+		room.ReadHistory(time.Time{}, time.Time{}, func(messages []Message) error {
+			for _, m := range messages {
+				texts = append(texts, m.Text)
+			}
+			return nil
+		})
+		assert.NotContains(t, strings.Join(texts, " "), "darn", "the history keeps messages as rewritten")
+	})
+
+	t.Run("rejections", func(t *testing.T) {
+		chat := room.(*ChatRoom)
+		for hookVerdict, code := range map[verdictHook]string{
+			{verdict: hook.Rejected("", "no")}:           RejectedCode,
+			{verdict: hook.Rejected("off_topic", "no")}:  "off_topic",
+			{err: fmt.Errorf("moderation service down")}: HookFailedCode,
+		} {
+			chat.mu.Lock()
+			chat.serverHooks = hook.Pipeline{hookVerdict}
+			chat.mu.Unlock()
+			assert.Equal(t, code, codeOf(room.PostMessage(ctx, "bob", "hello")))
+		}
+		chat.mu.Lock()
+		chat.serverHooks = hook.Pipeline{verdictHook{verdict: hook.Held("links need review")}}
+		chat.mu.Unlock()
+		assert.IsType(t, &HeldError{}, room.PostMessage(ctx, "bob", "hello"))
+		assert.Len(t, dispatcher.received("alice"), 2, "rejected and held messages aren't posted")
+	})
+}
@@ -0,0 +1,164 @@
+package model
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"irc/server/audit"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// IncomingWebhook lets anyone holding its token post into a room, under the
+// webhook's display name, without joining it.
+type IncomingWebhook struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// Token is only shown when the webhook is created.
+	Token     string    `json:"token,omitempty"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+const MaxWebhookNameLength = 50
+
+func (c *ChatRoom) AddIncomingWebhook(ctx context.Context, tag string, name string) (IncomingWebhook, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.requireOperator(tag); err != nil {
+		return IncomingWebhook{}, err
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > MaxWebhookNameLength || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return IncomingWebhook{}, fmt.Errorf("webhook name must be 1 to %d characters, without control characters: %q", MaxWebhookNameLength, name)
+	}
+	// Its messages would pass for the member's
+	if c.hasJoined(name) {
+		return IncomingWebhook{}, fmt.Errorf(`webhook name "%s" is the tag of a member of room %+v`, name, c.ProxyMetadata)
+	}
+
+	hook := &IncomingWebhook{
+		Id:        newUid(),
+		Name:      name,
+		Token:     newToken(),
+		CreatedBy: tag,
+		CreatedAt: c.clock(),
+	}
+	c.incoming = append(c.incoming, hook)
+	c.logAction(ctx, audit.Entry{Action: audit.WebhookAdd, Actor: tag, After: hook.state()})
+	return *hook, nil
+}
+
+// state is the webhook as kept in the audit log, without its token.
+func (hook *IncomingWebhook) state() json.RawMessage {
+	return audit.State(map[string]string{"id": hook.Id, "name": hook.Name, "kind": "incoming"})
+}
+
+// GetIncomingWebhooks lists the room's incoming webhooks, without their
+// tokens.
+func (c *ChatRoom) GetIncomingWebhooks(tag string) ([]IncomingWebhook, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.requireOperator(tag); err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]IncomingWebhook, 0, len(c.incoming))
+	for _, hook := range c.incoming {
+		listed := *hook
+		listed.Token = ""
+		webhooks = append(webhooks, listed)
+	}
+	return webhooks, nil
+}
+
+func (c *ChatRoom) RemoveIncomingWebhook(ctx context.Context, tag string, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.requireOperator(tag); err != nil {
+		return err
+	}
+
+	for i, hook := range c.incoming {
+		if hook.Id == id {
+			c.logAction(ctx, audit.Entry{Action: audit.WebhookRemove, Actor: tag, Before: hook.state()})
+			c.incoming = append(c.incoming[:i], c.incoming[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf(`webhook does not exist: "%s"`, id)
+}
+
+// PostWithWebhook posts message to every member under the display name of
+// the webhook with the given token.
+func (c *ChatRoom) PostWithWebhook(ctx context.Context, token string, message string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	hook, ok := c.incomingWebhook(token)
+	if !ok {
+		return errInvalidToken
+	}
+
+	return c.submit(ctx, MessageEvent, hook.Name, hook.Id, message)
+}
+
+var errInvalidToken = fmt.Errorf("invalid webhook token")
+
+// incomingWebhook finds the webhook with the given token, comparing tokens
+// in constant time. Must be called with c.mu held.
+func (c *ChatRoom) incomingWebhook(token string) (*IncomingWebhook, bool) {
+	for _, hook := range c.incoming {
+		if subtle.ConstantTimeCompare([]byte(hook.Token), []byte(token)) == 1 {
+			return hook, true
+		}
+	}
+	return nil, false
+}
+
+// isIncomingWebhook reports whether name is the name of one of the room's
+// incoming webhooks, which members may not take as their tag, as their
+// messages would pass for the webhook's. Must be called with c.mu held.
+func (c *ChatRoom) isIncomingWebhook(name string) bool {
+	for _, hook := range c.incoming {
+		if hook.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetProxyByWebhookToken finds the room with an incoming webhook with the
+// given token.
+func (s *ChatRoomStore) GetProxyByWebhookToken(token string) (MessageProxy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, room := range s.chatRooms {
+		room.mu.Lock()
+		_, ok := room.incomingWebhook(token)
+		room.mu.Unlock()
+		if ok {
+			return room, nil
+		}
+	}
+	return nil, errInvalidToken
+}
+
+// newToken returns a random, URL safe secret.
+func newToken() string {
+	var b [24]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Errorf("failed to generate webhook token: %v", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
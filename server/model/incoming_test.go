@@ -0,0 +1,117 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncomingWebhooks(t *testing.T) {
+	ctx := context.Background()
+	newStore := func() (*ChatRoomStore, *ChatRoom, *fakeDispatcher) {
+		dispatcher := newFakeDispatcher()
+		store := NewChatRoomStore(WithDispatcher(dispatcher))
+		store.AddProxy(context.Background(), roomName, "alice")
+		room := store.chatRooms[0]
+		room.Join(context.Background(), "alice", "alice-callback")
+		room.Join(context.Background(), "bob", "bob-callback")
+		return store, room, dispatcher
+	}
+
+	t.Run("posts to everyone under the webhook's name", func(t *testing.T) {
+		store, room, dispatcher := newStore()
+
+		hook, err := room.AddIncomingWebhook(ctx, "alice", "CI")
+		assert.Nil(t, err)
+		assert.NotEmpty(t, hook.Token)
+
+		found, err := store.GetProxyByWebhookToken(hook.Token)
+		assert.Nil(t, err)
+		assert.Equal(t, room, found)
+
+		err = room.PostWithWebhook(context.Background(), hook.Token, "build passed")
+		assert.Nil(t, err)
+
+		expected := []CallbackBody{{Type: MessageEvent, Id: 1, Tag: "CI", Message: "build passed", Webhook: hook.Id}}
+		assert.Equal(t, expected, dispatcher.received("alice"))
+		assert.Equal(t, expected, dispatcher.received("bob"))
+	})
+
+	t.Run("only operators manage webhooks", func(t *testing.T) {
+		_, room, _ := newStore()
+
+		_, err := room.AddIncomingWebhook(ctx, "bob", "CI")
+		assert.NotNil(t, err)
+
+		hook, err := room.AddIncomingWebhook(ctx, "alice", "CI")
+		assert.Nil(t, err)
+
+		_, err = room.GetIncomingWebhooks("bob")
+		assert.NotNil(t, err)
+		err = room.RemoveIncomingWebhook(ctx, "bob", hook.Id)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("listing hides tokens", func(t *testing.T) {
+		_, room, _ := newStore()
+		hook, _ := room.AddIncomingWebhook(ctx, "alice", "CI")
+
+		webhooks, err := room.GetIncomingWebhooks("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, []IncomingWebhook{{Id: hook.Id, Name: "CI", CreatedBy: "alice", CreatedAt: hook.CreatedAt}}, webhooks)
+	})
+
+	t.Run("revoked tokens stop working", func(t *testing.T) {
+		store, room, _ := newStore()
+		hook, _ := room.AddIncomingWebhook(ctx, "alice", "CI")
+
+		err := room.RemoveIncomingWebhook(ctx, "alice", hook.Id)
+		assert.Nil(t, err)
+
+		_, err = store.GetProxyByWebhookToken(hook.Token)
+		assert.NotNil(t, err)
+		err = room.PostWithWebhook(context.Background(), hook.Token, "build passed")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("invalid names", func(t *testing.T) {
+		_, room, _ := newStore()
+
+		for _, name := range []string{"", "  ", "new\nline", strings.Repeat("a", 51), "bob"} {
+			_, err := room.AddIncomingWebhook(ctx, "alice", name)
+			assert.NotNil(t, err, name)
+		}
+	})
+
+	t.Run("members can't take a webhook's name", func(t *testing.T) {
+		_, room, _ := newStore()
+		room.AddIncomingWebhook(ctx, "alice", "CI")
+
+		assert.NotNil(t, room.Join(ctx, "CI", "mallory-callback"))
+		assert.False(t, room.HasJoined("CI"))
+
+		err := room.PostMessage(ctx, "bob", "/nick CI")
+		assert.Equal(t, InvalidArgumentsCode, commandCodeOf(err))
+		assert.True(t, room.HasJoined("bob"))
+	})
+
+	t.Run("messages are validated", func(t *testing.T) {
+		_, room, _ := newStore()
+		hook, _ := room.AddIncomingWebhook(ctx, "alice", "CI")
+
+		err := room.PostWithWebhook(context.Background(), hook.Token, "")
+		assert.Equal(t, EmptyMessageCode, codeOf(err))
+	})
+}
+
+func TestNewToken(t *testing.T) {
+	tokens := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		token := newToken()
+		assert.Len(t, token, 32)
+		assert.False(t, tokens[token])
+		tokens[token] = true
+	}
+}
@@ -1,29 +1,147 @@
 package model
 
+import (
+	"context"
+	"fmt"
+	"irc/server/hook"
+	"irc/server/search"
+	"irc/server/spam"
+	"irc/server/webhook"
+	"time"
+)
+
 type MessageProxyStore interface {
 	GetMetadata() []ProxyMetadata
-	AddProxy(name string) (int, error)
+	AddProxy(ctx context.Context, name string, creator string) (int, error)
 	GetProxy(id int) (MessageProxy, error)
-	DeleteProxy(id int) error
+	GetProxyByUid(uid string) (MessageProxy, error)
+	GetProxyByName(name string) (MessageProxy, error)
+	DeleteProxy(ctx context.Context, id int) error
+	CheckPresence(config PresenceConfig)
+	PostNotice(ctx context.Context, message string) error
+	GetProxyByWebhookToken(token string) (MessageProxy, error)
+	Search(query search.Query, member string) ([]search.Result, *search.Cursor)
+	MessagesPosted() int64
+	ServerAdmin
+}
+
+// ServerAdmin inspects and controls the members of every room, which only
+// the server's administrators may do.
+type ServerAdmin interface {
+	Memberships() []Membership
+	RemoveMember(ctx context.Context, tag string, reason string) []int
+	Ban(ctx context.Context, tag string, reason string) ([]int, error)
+	Unban(tag string) error
+	Bans() []Ban
 }
 
 type MessageProxy interface {
 	GetMetadata() *ProxyMetadata
+	SetTopic(ctx context.Context, tag string, topic string) error
+	SetDescription(ctx context.Context, tag string, description string) error
+	SetModes(ctx context.Context, tag string, changes string) error
+	Update(ctx context.Context, tag string, update RoomUpdate) error
+	IsOperator(tag string) bool
 	Subscribable
 	Broadcaster
+	PresenceTracker
+	WebhookRegistry
+	History
+	SpamDecisions(tag string) ([]spam.Decision, error)
+	MessageHooks
+	ModerationQueue
+	MessageEditor
 }
 
 type Subscribable interface {
-	Join(tag string, callbackUrl string) error
-	Leave(tag string) error
+	Join(ctx context.Context, tag string, callbackUrl string) error
+	JoinSink(tag string, sink Sink) error
+	Leave(ctx context.Context, tag string) error
 	HasJoined(tag string) bool
 }
 
+type PresenceTracker interface {
+	GetMembers() []MemberInfo
+	Heartbeat(ctx context.Context, tag string, status Presence) error
+}
+
+// WebhookRegistry manages the outgoing and incoming webhooks of a room, which
+// only its operators may do.
+type WebhookRegistry interface {
+	AddWebhook(ctx context.Context, tag string, url string, format webhook.Format, template string) (Webhook, error)
+	GetWebhooks(tag string) ([]Webhook, error)
+	RemoveWebhook(ctx context.Context, tag string, id string) error
+	AddIncomingWebhook(ctx context.Context, tag string, name string) (IncomingWebhook, error)
+	GetIncomingWebhooks(tag string) ([]IncomingWebhook, error)
+	RemoveIncomingWebhook(ctx context.Context, tag string, id string) error
+	PostWithWebhook(ctx context.Context, token string, message string) error
+}
+
+// MessageHooks configures the hooks a room runs messages through before
+// they're posted, which only its operators may do.
+type MessageHooks interface {
+	SetHooks(ctx context.Context, tag string, specs []hook.Spec) error
+	GetHooks(tag string) ([]hook.Spec, error)
+}
+
+// ModerationQueue holds back messages for review by a room's operators, who
+// alone may see them and decide whether they're posted.
+type ModerationQueue interface {
+	HeldMessages(tag string) ([]HeldMessage, error)
+	Approve(ctx context.Context, tag string, id int64) error
+	Reject(ctx context.Context, tag string, id int64, reason string) error
+}
+
+// MessageEditor changes messages once they're posted, which their authors
+// may do for a while, and operators always.
+type MessageEditor interface {
+	GetMessage(id int64) (Message, error)
+	EditMessage(ctx context.Context, tag string, id int64, text string) error
+	DeleteMessage(ctx context.Context, tag string, id int64) error
+}
+
+// History reads the messages and events a room keeps.
+type History interface {
+	ReadHistory(from time.Time, to time.Time, fn func([]Message) error) error
+	VisibleTo(tag string) bool
+	Import(tag string, messages []Message) (ImportResult, error)
+}
+
 type Broadcaster interface {
-	PostMessage(tag string, message string) error
+	PostMessage(ctx context.Context, tag string, message string) error
+}
+
+// Dispatcher delivers event bodies to member callback URLs. Dispatch queues
+// the body and returns right away, while Probe waits for the callback's
+// response. The context carries the ID of the request that caused the
+// delivery, if any.
+type Dispatcher interface {
+	Dispatch(ctx context.Context, tag string, callbackUrl string, body []byte)
+	Probe(ctx context.Context, callbackUrl string, body []byte) error
 }
 
 type ProxyMetadata struct {
-	Id   int    `json:"id"`
-	Name string `json:"name"`
+	Id          int       `json:"id"`
+	Uid         string    `json:"uid"`
+	Name        string    `json:"name"`
+	Topic       *Topic    `json:"topic,omitempty"`
+	Description string    `json:"description,omitempty"`
+	Modes       string    `json:"modes,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	Creator     string    `json:"creator,omitempty"`
+	MemberCount int       `json:"memberCount"`
+	// LastActivityAt is when a message was last posted or the topic last changed.
+	LastActivityAt time.Time `json:"lastActivityAt"`
+}
+
+// String keeps error messages and logs terse; the full metadata is only
+// interesting when serialized for clients.
+func (m ProxyMetadata) String() string {
+	return fmt.Sprintf("{Id:%d Name:%s}", m.Id, m.Name)
+}
+
+type Topic struct {
+	Text  string    `json:"text"`
+	SetBy string    `json:"setBy"`
+	SetAt time.Time `json:"setAt"`
 }
@@ -0,0 +1,158 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ControlChars is what a MessagePolicy does with control characters, such as
+// CR and LF, which would break consumers that expect one message per line.
+type ControlChars string
+
+const (
+	// RejectControlChars rejects messages that contain control characters.
+	RejectControlChars ControlChars = "reject"
+	// StripControlChars removes control characters from messages.
+	StripControlChars ControlChars = "strip"
+	// ReplaceControlChars replaces each run of control characters with a
+	// space. It's what the zero ControlChars does.
+	ReplaceControlChars ControlChars = "replace"
+)
+
+// ParseControlChars parses the name of a ControlChars policy.
+func ParseControlChars(name string) (ControlChars, error) {
+	switch policy := ControlChars(name); policy {
+	case RejectControlChars, StripControlChars, ReplaceControlChars:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown control character policy %q", name)
+	}
+}
+
+// MessagePolicy decides which messages may be posted. Lengths are in bytes of
+// UTF-8.
+type MessagePolicy struct {
+	MaxLength    int
+	ControlChars ControlChars
+	// Split splits messages longer than MaxLength into up to MaxParts parts,
+	// delivered in order, rather than rejecting them.
+	Split    bool
+	MaxParts int
+}
+
+var DefaultMessagePolicy = MessagePolicy{
+	MaxLength:    4000,
+	ControlChars: ReplaceControlChars,
+	Split:        false,
+	MaxParts:     4,
+}
+
+// Codes identifying why a message was rejected
+const (
+	EmptyMessageCode   = "empty_message"
+	InvalidUTF8Code    = "invalid_utf8"
+	ControlCharsCode   = "control_characters"
+	MessageTooLongCode = "message_too_long"
+)
+
+// ValidationError explains why a message was rejected.
+type ValidationError struct {
+	Code    string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func invalidMessage(code string, format string, args ...interface{}) *ValidationError {
+	return &ValidationError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Apply validates and normalizes message, returning the parts to deliver:
+// just the one unless it's split.
+func (p MessagePolicy) Apply(message string) ([]string, error) {
+	if !utf8.ValidString(message) {
+		return nil, invalidMessage(InvalidUTF8Code, "message is not valid UTF-8")
+	}
+
+	message, err := p.controlChars(message)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(message) == "" {
+		return nil, invalidMessage(EmptyMessageCode, "message is empty")
+	}
+
+	if p.MaxLength <= 0 || len(message) <= p.MaxLength {
+		return []string{message}, nil
+	}
+	if !p.Split {
+		return nil, invalidMessage(MessageTooLongCode, "message is %d bytes long, more than the maximum of %d", len(message), p.MaxLength)
+	}
+
+	parts := split(message, p.MaxLength)
+	if p.MaxParts > 0 && len(parts) > p.MaxParts {
+		return nil, invalidMessage(MessageTooLongCode, "message would be split into %d parts, more than the maximum of %d", len(parts), p.MaxParts)
+	}
+	return parts, nil
+}
+
+func (p MessagePolicy) controlChars(message string) (string, error) {
+	if strings.IndexFunc(message, unicode.IsControl) < 0 {
+		return message, nil
+	}
+
+	switch p.ControlChars {
+	case RejectControlChars:
+		return "", invalidMessage(ControlCharsCode, "message contains control characters")
+	case StripControlChars:
+		return strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) {
+				return -1
+			}
+			return r
+		}, message), nil
+	default:
+		var b strings.Builder
+		inControl := false
+		for _, r := range message {
+			if unicode.IsControl(r) {
+				if !inControl {
+					b.WriteRune(' ')
+				}
+				inControl = true
+				continue
+			}
+			inControl = false
+			b.WriteRune(r)
+		}
+		return b.String(), nil
+	}
+}
+
+// split cuts message into parts of at most max bytes, at the last space
+// before the limit if there is one, and otherwise between runes.
+func split(message string, max int) []string {
+	var parts []string
+	for len(message) > max {
+		cut := strings.LastIndexByte(message[:max+1], ' ')
+		if cut <= 0 {
+			cut = max
+			for cut > 0 && !utf8.RuneStart(message[cut]) {
+				cut -= 1
+			}
+			if cut == 0 {
+				_, cut = utf8.DecodeRuneInString(message)
+			}
+		}
+		parts = append(parts, strings.TrimRight(message[:cut], " "))
+		message = strings.TrimLeft(message[cut:], " ")
+	}
+	if message != "" {
+		parts = append(parts, message)
+	}
+	return parts
+}
@@ -0,0 +1,110 @@
+package model
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func codeOf(err error) string {
+	if invalid, ok := err.(*ValidationError); ok {
+		return invalid.Code
+	}
+	return ""
+}
+
+func TestMessagePolicy(t *testing.T) {
+	t.Run("valid messages pass unchanged", func(t *testing.T) {
+		for _, message := range []string{"hello", "héllo wörld", "emoji 🎉", strings.Repeat("a", 4000)} {
+			parts, err := DefaultMessagePolicy.Apply(message)
+			assert.Nil(t, err, message)
+			assert.Equal(t, []string{message}, parts)
+		}
+	})
+
+	t.Run("rejections", func(t *testing.T) {
+		policy := MessagePolicy{MaxLength: 10, ControlChars: RejectControlChars}
+		rejected := map[string]string{
+			"":              EmptyMessageCode,
+			"  ":            EmptyMessageCode,
+			"bad \xff utf8": InvalidUTF8Code,
+			"line\r\nbreak": ControlCharsCode,
+			"bell\a":        ControlCharsCode,
+			"eleven chars":  MessageTooLongCode,
+		}
+		for message, code := range rejected {
+			_, err := policy.Apply(message)
+			assert.Equal(t, code, codeOf(err), message)
+		}
+	})
+
+	t.Run("control characters", func(t *testing.T) {
+		strip := MessagePolicy{ControlChars: StripControlChars}
+		parts, err := strip.Apply("line\r\nbreak\a")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"linebreak"}, parts)
+
+		replace := MessagePolicy{ControlChars: ReplaceControlChars}
+		parts, err = replace.Apply("line\r\nbreak\ttab")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"line break tab"}, parts)
+
+		_, err = replace.Apply("\r\n")
+		assert.Equal(t, EmptyMessageCode, codeOf(err))
+	})
+
+	t.Run("splits long messages", func(t *testing.T) {
+		policy := MessagePolicy{MaxLength: 10, Split: true, MaxParts: 3}
+
+		parts, err := policy.Apply("the quick brown fox jumps")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"the quick", "brown fox", "jumps"}, parts)
+
+		parts, err = policy.Apply("abcdefghijklmnop")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"abcdefghij", "klmnop"}, parts)
+
+		_, err = policy.Apply(strings.Repeat("a", 31))
+		assert.Equal(t, MessageTooLongCode, codeOf(err))
+	})
+
+	t.Run("splits between runes", func(t *testing.T) {
+		policy := MessagePolicy{MaxLength: 5, Split: true}
+
+		parts, err := policy.Apply("ééééé")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"éé", "éé", "é"}, parts)
+	})
+}
+
+func TestParseControlChars(t *testing.T) {
+	policy, err := ParseControlChars("strip")
+	assert.Nil(t, err)
+	assert.Equal(t, StripControlChars, policy)
+
+	_, err = ParseControlChars("ignore")
+	assert.NotNil(t, err)
+}
+
+func TestPostSplitMessage(t *testing.T) {
+	dispatcher := newFakeDispatcher()
+	room := newChatRoom(roomId, roomName, time.Now, dispatcher)
+	room.policy = MessagePolicy{MaxLength: 5, Split: true}
+	room.Join(context.Background(), "alice", "alice-callback")
+	room.Join(context.Background(), "bob", "bob-callback")
+
+	err := room.PostMessage(context.Background(), "alice", "hello world")
+	assert.Nil(t, err)
+
+	assert.Equal(t, []CallbackBody{
+		{Type: MessageEvent, Id: 1, Tag: "alice", Message: "hello", Part: 1, Parts: 2},
+		{Type: MessageEvent, Id: 2, Tag: "alice", Message: "world", Part: 2, Parts: 2},
+	}, dispatcher.received("bob"))
+
+	err = room.PostMessage(context.Background(), "alice", "")
+	assert.Equal(t, EmptyMessageCode, codeOf(err))
+	assert.Len(t, dispatcher.received("bob"), 2)
+}
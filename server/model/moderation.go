@@ -0,0 +1,178 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"irc/server/audit"
+	"time"
+)
+
+// Codes identifying why a message was held back rather than posted
+const (
+	// HeldCode tells posters their message was held back for review.
+	HeldCode = "held"
+	// QueueFullCode rejects a message that would be held back, when the
+	// room's moderation queue is full.
+	QueueFullCode = "moderation_queue_full"
+)
+
+// MaxHeldMessages caps how many messages wait in a room's moderation queue.
+const MaxHeldMessages = 500
+
+// HeldMessage is a message, or action, held back for review by a hook or
+// because the room is moderated, waiting for an operator to approve or
+// reject it. Rooms number their held messages from 1, apart from the
+// messages they post.
+type HeldMessage struct {
+	Id   int64     `json:"id"`
+	Type string    `json:"type"`
+	Tag  string    `json:"tag"`
+	Text string    `json:"text"`
+	Time time.Time `json:"time"`
+	// Reason is why it was held, and Hook the hook that held it, if one did.
+	Reason string `json:"reason"`
+	Hook   string `json:"hook,omitempty"`
+	// Webhook is the ID of the incoming webhook it was posted with, if it
+	// was posted with one.
+	Webhook string `json:"webhook,omitempty"`
+
+	parts []string
+}
+
+// HeldError tells posters their message was held back for review rather than
+// posted. The message is in the moderation queue as Id.
+type HeldError struct {
+	Id     int64
+	Reason string
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("message held for review: %s", e.Reason)
+}
+
+// moderated reports whether the messages of the member with the given tag
+// are held back for review because the room has ModeratedMode set, which
+// holds back those of every member but operators and bots. Must be called
+// with c.mu held.
+func (c *ChatRoom) moderated(tag string) bool {
+	member, ok := c.members[tag]
+	return ok && c.hasMode(ModeratedMode) && !member.operator && member.sink == nil
+}
+
+// hold adds a message about to be posted to the moderation queue, rather
+// than posting it. Must be called with c.mu held.
+func (c *ChatRoom) hold(eventType string, from string, webhook string, text string, parts []string, reason string, hook string) error {
+	if len(c.held) >= MaxHeldMessages {
+		return invalidMessage(QueueFullCode, "the moderation queue of room %+v is full", c.ProxyMetadata)
+	}
+
+	c.lastHeldId += 1
+	c.held = append(c.held, HeldMessage{
+		Id:      c.lastHeldId,
+		Type:    eventType,
+		Tag:     from,
+		Text:    text,
+		Time:    c.clock(),
+		Reason:  reason,
+		Hook:    hook,
+		Webhook: webhook,
+		parts:   parts,
+	})
+	return &HeldError{Id: c.lastHeldId, Reason: reason}
+}
+
+// dropHeld drops the held messages of the member with the given tag, once
+// it's no longer in the room, so that they can't be posted in its name.
+// Must be called with c.mu held.
+func (c *ChatRoom) dropHeld(tag string) {
+	held := c.held[:0]
+	for _, m := range c.held {
+		if m.Tag != tag || m.Webhook != "" {
+			held = append(held, m)
+		}
+	}
+	c.held = held
+}
+
+// renameHeld moves the held messages of the member with the given tag to
+// its new tag. Must be called with c.mu held.
+func (c *ChatRoom) renameHeld(tag string, newTag string) {
+	for i := range c.held {
+		if c.held[i].Tag == tag && c.held[i].Webhook == "" {
+			c.held[i].Tag = newTag
+		}
+	}
+}
+
+// HeldMessages lists the messages in the room's moderation queue, oldest
+// first, which only its operators may see.
+func (c *ChatRoom) HeldMessages(tag string) ([]HeldMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.requireOperator(tag); err != nil {
+		return nil, err
+	}
+	return append([]HeldMessage{}, c.held...), nil
+}
+
+// Approve posts the held message with the given ID, with the time it was
+// sent at, and tells its poster. Only operators may approve messages.
+func (c *ChatRoom) Approve(ctx context.Context, tag string, id int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	held, err := c.takeHeld(tag, id)
+	if err != nil {
+		return err
+	}
+
+	c.logAction(ctx, audit.Entry{Action: audit.MessageApprove, Actor: tag, Target: held.Tag, After: audit.State(held)})
+	if err := c.post(ctx, held.Type, held.Tag, held.Webhook, held.parts, held.Time); err != nil {
+		return err
+	}
+	// Told the ID of the first part, if it was split
+	posted := c.lastMessageId - int64(len(held.parts)) + 1
+	return c.tellPoster(ctx, held, CallbackBody{Type: MessageApprovedEvent, Id: posted, HeldId: held.Id, Tag: tag, Message: held.Text})
+}
+
+// Reject drops the held message with the given ID, telling its poster why.
+// Only operators may reject messages.
+func (c *ChatRoom) Reject(ctx context.Context, tag string, id int64, reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	held, err := c.takeHeld(tag, id)
+	if err != nil {
+		return err
+	}
+
+	c.logAction(ctx, audit.Entry{Action: audit.MessageReject, Actor: tag, Target: held.Tag, Reason: reason, Before: audit.State(held)})
+	return c.tellPoster(ctx, held, CallbackBody{Type: MessageRejectedEvent, HeldId: held.Id, Tag: tag, Message: held.Text, Reason: reason})
+}
+
+// tellPoster sends body to the member who posted a held message, unless it
+// was posted with a webhook, which has no member to tell. Must be called
+// with c.mu held.
+func (c *ChatRoom) tellPoster(ctx context.Context, held HeldMessage, body CallbackBody) error {
+	if held.Webhook != "" {
+		return nil
+	}
+	return c.send(ctx, held.Tag, body)
+}
+
+// takeHeld removes the held message with the given ID from the moderation
+// queue, for the operator with the given tag to decide on. Must be called
+// with c.mu held.
+func (c *ChatRoom) takeHeld(tag string, id int64) (HeldMessage, error) {
+	if err := c.requireOperator(tag); err != nil {
+		return HeldMessage{}, err
+	}
+	for i, held := range c.held {
+		if held.Id == id {
+			c.held = append(c.held[:i:i], c.held[i+1:]...)
+			return held, nil
+		}
+	}
+	return HeldMessage{}, fmt.Errorf("held message does not exist: %d", id)
+}
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"context"
+	"irc/server/hook"
+	"irc/server/spam"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModerationQueue(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	sent := clock.now
+	dispatcher := newFakeDispatcher()
+	store := NewChatRoomStore(WithDispatcher(dispatcher), WithClock(clock.Now))
+	id, _ := store.AddProxy(ctx, roomName, "alice")
+	room, _ := store.GetProxy(id)
+	room.Join(ctx, "alice", "alice-callback")
+	room.Join(ctx, "bob", "bob-callback")
+	room.Join(ctx, "carol", "carol-callback")
+	assert.Nil(t, room.SetModes(ctx, "alice", "+m"))
+
+	var held *HeldError
+	err := room.PostMessage(ctx, "bob", "first")
+	assert.IsType(t, held, err)
+	assert.Equal(t, &HeldError{Id: 1, Reason: "the room is moderated"}, err)
+	assert.IsType(t, held, room.PostMessage(ctx, "bob", "/me waves"))
+	assert.Nil(t, room.PostMessage(ctx, "alice", "operators aren't held"))
+	assert.Len(t, dispatcher.received("carol"), 2)
+
+	t.Run("operators list held messages", func(t *testing.T) {
+		messages, err := room.HeldMessages("alice")
+		assert.Nil(t, err)
+		assert.Equal(t, []HeldMessage{
+			{Id: 1, Type: MessageEvent, Tag: "bob", Text: "first", Time: sent, Reason: "the room is moderated", parts: []string{"first"}},
+			{Id: 2, Type: ActionEvent, Tag: "bob", Text: "waves", Time: sent, Reason: "the room is moderated", parts: []string{"waves"}},
+		}, messages)
+
+		_, err = room.HeldMessages("bob")
+		assert.Equal(t, ForbiddenCode, commandCodeOf(err))
+		assert.Equal(t, ForbiddenCode, commandCodeOf(room.Approve(ctx, "bob", 1)))
+	})
+
+	t.Run("approve", func(t *testing.T) {
+		clock.Advance(time.Minute)
+		assert.Nil(t, room.Approve(ctx, "alice", 1))
+		assert.EqualError(t, room.Approve(ctx, "alice", 1), "held message does not exist: 1")
+
+		events := dispatcher.received("carol")
+		approved := events[len(events)-1]
+		assert.Equal(t, "first", approved.Message)
+		assert.Equal(t, int64(2), approved.Id)
+		assert.Equal(t, sent, *approved.Time, "approved messages keep the time they were sent at")
+		assert.Equal(t, CallbackBody{Type: MessageApprovedEvent, Id: 2, HeldId: 1, Tag: "alice", Message: "first"}, dispatcher.received("bob")[2])
+
+		var times []time.Time
+		room.ReadHistory(time.Time{}, time.Time{}, func(messages []Message) error {
+			for _, m := range messages {
+				if m.Text == "first" {
+					times = append(times, m.Time)
+				}
+			}
+			return nil
+		})
+		assert.Equal(t, []time.Time{sent}, times)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		assert.Nil(t, room.Reject(ctx, "alice", 2, "off topic"))
+		assert.Equal(t, CallbackBody{Type: MessageRejectedEvent, HeldId: 2, Tag: "alice", Message: "waves", Reason: "off topic"}, dispatcher.received("bob")[3])
+		assert.Len(t, dispatcher.received("carol"), 3, "rejected messages aren't posted")
+
+		messages, _ := room.HeldMessages("alice")
+		assert.Empty(t, messages)
+	})
+
+	t.Run("hooks hold messages in any room", func(t *testing.T) {
+		assert.Nil(t, room.SetModes(ctx, "alice", "-m"))
+		room.(*ChatRoom).mu.Lock()
+		room.(*ChatRoom).serverHooks = hook.Pipeline{verdictHook{verdict: hook.Held("links need review")}}
+		room.(*ChatRoom).mu.Unlock()
+
+		assert.Equal(t, &HeldError{Id: 3, Reason: "links need review"}, room.PostMessage(ctx, "alice", "see http://example.com"))
+		messages, _ := room.HeldMessages("alice")
+		assert.Equal(t, "verdict", messages[0].Hook)
+	})
+
+	t.Run("spam warnings are sent for held messages", func(t *testing.T) {
+		room.(*ChatRoom).mu.Lock()
+		room.(*ChatRoom).spam = spam.NewFilter(spam.Config{MaxMentions: 1, Actions: map[spam.Rule]spam.Action{spam.Mentions: spam.Warn}})
+		room.(*ChatRoom).mu.Unlock()
+
+		assert.IsType(t, held, room.PostMessage(ctx, "bob", "alice, carol: look"))
+		events := dispatcher.received("bob")
+		assert.Equal(t, CallbackBody{Type: NoticeEvent, Message: "warning: mentioned 2 members"}, events[len(events)-1])
+	})
+
+	t.Run("held messages follow their poster", func(t *testing.T) {
+		assert.IsType(t, held, room.PostMessage(ctx, "carol", "hold me"))
+		assert.Nil(t, room.PostMessage(ctx, "bob", "/nick robert"))
+		assert.Nil(t, room.Leave(ctx, "carol"))
+
+		messages, _ := room.HeldMessages("alice")
+		var tags []string
+		for _, m := range messages {
+			tags = append(tags, m.Tag)
+		}
+		assert.Equal(t, []string{"alice", "robert"}, tags, "messages of members who left are dropped")
+	})
+}
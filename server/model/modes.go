@@ -0,0 +1,79 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"irc/server/audit"
+	"sort"
+	"strings"
+)
+
+// Chat room modes, named after the IRC channel modes they mirror.
+const (
+	InviteOnlyMode = 'i'
+	ModeratedMode  = 'm'
+	SecretMode     = 's'
+	TopicLockMode  = 't'
+)
+
+const chatRoomModes = "imst"
+
+// hasMode reports whether the room has mode set. Must be called with c.mu
+// held.
+func (c *ChatRoom) hasMode(mode rune) bool {
+	return strings.ContainsRune(c.Modes, mode)
+}
+
+// VisibleTo reports whether the member with the given tag may search or
+// export the room's history: anyone may, unless the room is secret.
+func (c *ChatRoom) VisibleTo(tag string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return !c.hasMode(SecretMode) || c.hasJoined(tag)
+}
+
+// applyModes applies mode changes such as "+m-t" to the room on behalf of
+// actor, records them in the audit log and tells the members. Must be called
+// with c.mu held.
+func (c *ChatRoom) applyModes(ctx context.Context, actor string, changes string) error {
+	modes, err := applyModeChanges(c.Modes, changes)
+	if err != nil {
+		return commandError(InvalidArgumentsCode, "%v", err)
+	}
+	c.logAction(ctx, audit.Entry{Action: audit.RoomMode, Actor: actor, Before: modesState(c.Modes), After: modesState(modes)})
+	c.Modes = modes
+	return c.broadcast(ctx, "", CallbackBody{Type: ModeEvent, Tag: actor, Modes: modes})
+}
+
+// applyModeChanges applies IRC-style mode changes such as "+m-t" to a set of
+// modes and returns the resulting set with its letters sorted.
+func applyModeChanges(modes string, changes string) (string, error) {
+	set := make(map[rune]bool)
+	for _, mode := range modes {
+		set[mode] = true
+	}
+
+	adding := true
+	for _, c := range changes {
+		switch {
+		case c == '+':
+			adding = true
+		case c == '-':
+			adding = false
+		case strings.ContainsRune(chatRoomModes, c):
+			set[c] = adding
+		default:
+			return "", fmt.Errorf("unknown chat room mode: %q", c)
+		}
+	}
+
+	result := make([]string, 0, len(set))
+	for mode, on := range set {
+		if on {
+			result = append(result, string(mode))
+		}
+	}
+	sort.Strings(result)
+	return strings.Join(result, ""), nil
+}
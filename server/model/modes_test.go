@@ -0,0 +1,29 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyModeChanges(t *testing.T) {
+	cases := []struct {
+		modes    string
+		changes  string
+		expected string
+	}{
+		{"", "+m", "m"},
+		{"", "ts", "st"},
+		{"mt", "-m", "t"},
+		{"m", "+s-m+i", "is"},
+		{"s", "-s", ""},
+	}
+	for _, c := range cases {
+		modes, err := applyModeChanges(c.modes, c.changes)
+		assert.Nil(t, err)
+		assert.Equal(t, c.expected, modes)
+	}
+
+	_, err := applyModeChanges("", "+x")
+	assert.NotNil(t, err)
+}
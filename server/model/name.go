@@ -0,0 +1,57 @@
+package model
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Chat room names follow IRC channel naming: an optional '#' prefix followed
+// by up to MaxChatRoomNameLength letters, digits, '-', '_' or '.'. Names are
+// compared case-insensitively and without their prefix, so "#General" and
+// "general" refer to the same room.
+const (
+	ChatRoomNamePrefix    = '#'
+	MaxChatRoomNameLength = 50
+)
+
+// NormalizeChatRoomName validates a chat room name and returns the canonical
+// form used to compare and look up rooms by name.
+func NormalizeChatRoomName(name string) (string, error) {
+	trimmed := strings.TrimPrefix(name, string(ChatRoomNamePrefix))
+	if trimmed == "" {
+		return "", fmt.Errorf("chat room name can't be empty: %q", name)
+	}
+	if utf8.RuneCountInString(trimmed) > MaxChatRoomNameLength {
+		return "", fmt.Errorf("chat room name can't be longer than %d characters: %q", MaxChatRoomNameLength, name)
+	}
+	for _, r := range trimmed {
+		if !isChatRoomNameChar(r) {
+			return "", fmt.Errorf(`chat room name may only contain letters, digits, '-', '_' and '.': %q`, name)
+		}
+	}
+	return strings.ToLower(trimmed), nil
+}
+
+func isChatRoomNameChar(r rune) bool {
+	switch {
+	case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		return true
+	case r == '-', r == '_', r == '.':
+		return true
+	}
+	return false
+}
+
+// newUid returns a random (version 4) UUID. Unlike room IDs, which restart
+// from zero with every new store, UIDs are never handed out twice.
+func newUid() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Errorf("failed to generate chat room uid: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeChatRoomName(t *testing.T) {
+	valid := map[string]string{
+		"general":         "general",
+		"#General":        "general",
+		"dev-ops_2.0":     "dev-ops_2.0",
+		"#" + long(50):    long(50),
+		"#" + long(1):     long(1),
+		"MiXeD-Case.Room": "mixed-case.room",
+	}
+	for name, expected := range valid {
+		normalized, err := NormalizeChatRoomName(name)
+		assert.Nil(t, err, name)
+		assert.Equal(t, expected, normalized)
+	}
+
+	invalid := []string{"", "#", "two words", "comma,separated", "colon:", "bell\a", "slash/", long(51), "##double"}
+	for _, name := range invalid {
+		_, err := NormalizeChatRoomName(name)
+		assert.NotNil(t, err, name)
+	}
+}
+
+func TestNewUid(t *testing.T) {
+	uids := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		uid := newUid()
+		assert.Len(t, uid, 36)
+		assert.False(t, uids[uid])
+		uids[uid] = true
+	}
+}
+
+func long(n int) string {
+	return strings.Repeat("a", n)
+}
@@ -0,0 +1,197 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"irc/server/audit"
+	"sort"
+	"sync"
+	"time"
+)
+
+type Presence string
+
+const (
+	Online  Presence = "online"
+	Away    Presence = "away"
+	Offline Presence = "offline"
+)
+
+type member struct {
+	// id tells apart members who take the same tag, one after the other
+	id          string
+	callbackUrl string
+	// status is the presence the member last reported: Online or Away
+	status Presence
+	// offline is set once the member misses PresenceConfig.Timeout
+	offline  bool
+	lastSeen time.Time
+	joinedAt time.Time
+	// mutedUntil is set when the member is muted for spamming
+	mutedUntil time.Time
+	// operator members may manage the room, e.g. its webhooks
+	operator bool
+	// sink is set for members without a callback URL, see JoinSink
+	sink *sinkQueue
+}
+
+func (m *member) presence() Presence {
+	if m.offline {
+		return Offline
+	}
+	return m.status
+}
+
+type MemberInfo struct {
+	Tag      string    `json:"tag"`
+	Presence Presence  `json:"presence"`
+	LastSeen time.Time `json:"lastSeen"`
+	Operator bool      `json:"operator,omitempty"`
+	Bot      bool      `json:"bot,omitempty"`
+}
+
+// PresenceConfig controls how members that stop sending heartbeats are
+// handled. Members are marked offline after Timeout and removed from the
+// room after EvictAfter. With Probe set, the callbacks of members that have
+// been quiet for half of Timeout are sent a PingEvent, and a successful
+// response counts as a heartbeat.
+type PresenceConfig struct {
+	Timeout    time.Duration
+	EvictAfter time.Duration
+	Probe      bool
+}
+
+var DefaultPresenceConfig = PresenceConfig{
+	Timeout:    time.Minute,
+	EvictAfter: 5 * time.Minute,
+	Probe:      true,
+}
+
+func (c *ChatRoom) GetMembers() []MemberInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	members := make([]MemberInfo, 0, len(c.members))
+	for tag, member := range c.members {
+		members = append(members, MemberInfo{tag, member.presence(), member.lastSeen, member.operator, member.sink != nil})
+	}
+	sortMembers(members)
+	return members
+}
+
+func sortMembers(members []MemberInfo) {
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Tag < members[j].Tag
+	})
+}
+
+// Heartbeat records that the member is still around, with the given status.
+func (c *ChatRoom) Heartbeat(ctx context.Context, tag string, status Presence) error {
+	if status != Online && status != Away {
+		return fmt.Errorf("presence must be %q or %q: %q", Online, Away, status)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	member, ok := c.members[tag]
+	if !ok {
+		return fmt.Errorf(`"%s" hasn't joined room %+v`, tag, c.ProxyMetadata)
+	}
+
+	before := member.presence()
+	member.status = status
+	c.seen(ctx, tag)
+	if before != member.presence() {
+		c.broadcastPresence(ctx, tag, member)
+	}
+	return nil
+}
+
+// seen marks the member as heard from now, bringing it back online if it
+// had gone offline. Must be called with c.mu held.
+func (c *ChatRoom) seen(ctx context.Context, tag string) {
+	member, ok := c.members[tag]
+	if !ok {
+		return
+	}
+
+	member.lastSeen = c.clock()
+	if member.offline {
+		member.offline = false
+		c.broadcastPresence(ctx, tag, member)
+	}
+}
+
+func (c *ChatRoom) broadcastPresence(ctx context.Context, tag string, member *member) {
+	c.broadcast(ctx, tag, CallbackBody{Type: PresenceEvent, Tag: tag, Presence: member.presence()})
+}
+
+// checkPresence marks members offline or evicts them according to config,
+// then probes the callbacks of quiet members if config.Probe is set.
+func (c *ChatRoom) checkPresence(config PresenceConfig) {
+	type probe struct{ tag, id, callbackUrl string }
+	var probes []probe
+	ctx := context.Background()
+
+	c.mu.Lock()
+	now := c.clock()
+	for tag, member := range c.members {
+		if member.sink != nil {
+			continue
+		}
+		idle := now.Sub(member.lastSeen)
+		switch {
+		case idle >= config.EvictAfter:
+			c.logAction(ctx, audit.Entry{Action: audit.MemberLeave, Target: tag, Reason: "timeout", Before: c.memberState(tag)})
+			c.removeMember(tag)
+			c.broadcast(ctx, tag, CallbackBody{Type: LeaveEvent, Tag: tag, Reason: "timeout"})
+		case idle >= config.Timeout && !member.offline:
+			member.offline = true
+			c.broadcastPresence(ctx, tag, member)
+			fallthrough
+		case idle >= config.Timeout/2:
+			if config.Probe && c.dispatcher != nil {
+				probes = append(probes, probe{tag, member.id, member.callbackUrl})
+			}
+		}
+	}
+	c.mu.Unlock()
+
+	// Probes are slow when callbacks are down, so don't hold the lock meanwhile
+	body, _ := json.Marshal(CallbackBody{Type: PingEvent})
+	var wg sync.WaitGroup
+	for _, p := range probes {
+		wg.Add(1)
+		go func(p probe) {
+			defer wg.Done()
+			if err := c.dispatcher.Probe(ctx, p.callbackUrl, body); err != nil {
+				return
+			}
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// The member may have left and rejoined meanwhile, which makes it a
+			// new member that the probe says nothing about
+			if member, ok := c.members[p.tag]; ok && member.id == p.id {
+				c.seen(ctx, p.tag)
+			}
+		}(p)
+	}
+	wg.Wait()
+}
+
+// CheckPresence marks quiet members of every room offline, or evicts them,
+// according to config.
+func (s *ChatRoomStore) CheckPresence(config PresenceConfig) {
+	s.mu.RLock()
+	rooms := make([]*ChatRoom, 0, len(s.chatRooms))
+	for _, room := range s.chatRooms {
+		rooms = append(rooms, room)
+	}
+	s.mu.RUnlock()
+
+	for _, room := range rooms {
+		room.checkPresence(config)
+	}
+}
@@ -0,0 +1,217 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDispatcher records dispatched events and raw bodies by recipient tag,
+// and answers probes of the callbacks in reachable, calling onProbe first if
+// it's set.
+type fakeDispatcher struct {
+	mu        sync.Mutex
+	events    map[string][]CallbackBody
+	bodies    map[string][]string
+	reachable map[string]bool
+	onProbe   func(callbackUrl string)
+}
+
+func newFakeDispatcher() *fakeDispatcher {
+	return &fakeDispatcher{
+		events:    make(map[string][]CallbackBody),
+		bodies:    make(map[string][]string),
+		reachable: make(map[string]bool),
+	}
+}
+
+func (d *fakeDispatcher) Dispatch(ctx context.Context, tag string, callbackUrl string, body []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var event CallbackBody
+	json.Unmarshal(body, &event)
+	d.events[tag] = append(d.events[tag], event)
+	d.bodies[tag] = append(d.bodies[tag], string(body))
+}
+
+func (d *fakeDispatcher) Probe(ctx context.Context, callbackUrl string, body []byte) error {
+	if d.onProbe != nil {
+		d.onProbe(callbackUrl)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.reachable[callbackUrl] {
+		return fmt.Errorf("unreachable: %s", callbackUrl)
+	}
+	return nil
+}
+
+func (d *fakeDispatcher) receivedBodies(tag string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]string(nil), d.bodies[tag]...)
+}
+
+func (d *fakeDispatcher) received(tag string) []CallbackBody {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]CallbackBody(nil), d.events[tag]...)
+}
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var presenceConfig = PresenceConfig{Timeout: time.Minute, EvictAfter: 5 * time.Minute}
+
+func roomWithPresence(dispatcher *fakeDispatcher) (*ChatRoom, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	room := newChatRoom(roomId, roomName, clock.Now, dispatcher)
+	room.Join(context.Background(), "alice", "alice-callback")
+	room.Join(context.Background(), "bob", "bob-callback")
+	return room, clock
+}
+
+func presenceOf(room *ChatRoom, tag string) Presence {
+	for _, member := range room.GetMembers() {
+		if member.Tag == tag {
+			return member.Presence
+		}
+	}
+	return ""
+}
+
+func TestHeartbeat(t *testing.T) {
+	t.Run("non-member heartbeat", func(t *testing.T) {
+		room, _ := roomWithPresence(newFakeDispatcher())
+
+		err := room.Heartbeat(context.Background(), userName, Online)
+		assert.NotNil(t, err)
+	})
+
+	t.Run("away and back", func(t *testing.T) {
+		dispatcher := newFakeDispatcher()
+		room, _ := roomWithPresence(dispatcher)
+
+		err := room.Heartbeat(context.Background(), "alice", Away)
+		assert.Nil(t, err)
+		assert.Equal(t, Away, presenceOf(room, "alice"))
+
+		err = room.Heartbeat(context.Background(), "alice", Online)
+		assert.Nil(t, err)
+		assert.Equal(t, Online, presenceOf(room, "alice"))
+
+		assert.Equal(t, []CallbackBody{
+			{Type: PresenceEvent, Tag: "alice", Presence: Away},
+			{Type: PresenceEvent, Tag: "alice", Presence: Online},
+		}, dispatcher.received("bob"))
+		assert.Empty(t, dispatcher.received("alice"))
+	})
+
+	t.Run("rejects unknown status", func(t *testing.T) {
+		room, _ := roomWithPresence(newFakeDispatcher())
+
+		err := room.Heartbeat(context.Background(), "alice", Offline)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestCheckPresence(t *testing.T) {
+	t.Run("quiet members go offline, then are evicted", func(t *testing.T) {
+		dispatcher := newFakeDispatcher()
+		room, clock := roomWithPresence(dispatcher)
+
+		clock.Advance(2 * time.Minute)
+		room.Heartbeat(context.Background(), "bob", Online)
+		room.checkPresence(presenceConfig)
+
+		assert.Equal(t, Offline, presenceOf(room, "alice"))
+		assert.Equal(t, Online, presenceOf(room, "bob"))
+
+		clock.Advance(3 * time.Minute)
+		room.Heartbeat(context.Background(), "bob", Online)
+		room.checkPresence(presenceConfig)
+
+		assert.False(t, room.HasJoined("alice"))
+		assert.Equal(t, []CallbackBody{
+			{Type: PresenceEvent, Tag: "alice", Presence: Offline},
+			{Type: LeaveEvent, Tag: "alice", Reason: "timeout"},
+		}, dispatcher.received("bob"))
+	})
+
+	t.Run("heartbeat brings offline member back", func(t *testing.T) {
+		dispatcher := newFakeDispatcher()
+		room, clock := roomWithPresence(dispatcher)
+
+		clock.Advance(2 * time.Minute)
+		room.checkPresence(presenceConfig)
+		assert.Equal(t, Offline, presenceOf(room, "alice"))
+
+		room.Heartbeat(context.Background(), "alice", Online)
+		assert.Equal(t, Online, presenceOf(room, "alice"))
+		assert.Equal(t, PresenceEvent, dispatcher.received("bob")[1].Type)
+		assert.Equal(t, Online, dispatcher.received("bob")[1].Presence)
+	})
+
+	t.Run("reachable callbacks count as heartbeats", func(t *testing.T) {
+		dispatcher := newFakeDispatcher()
+		dispatcher.reachable["alice-callback"] = true
+		room, clock := roomWithPresence(dispatcher)
+		config := presenceConfig
+		config.Probe = true
+
+		for i := 0; i < 10; i++ {
+			clock.Advance(40 * time.Second)
+			room.checkPresence(config)
+		}
+
+		assert.Equal(t, Online, presenceOf(room, "alice"))
+		assert.False(t, room.HasJoined("bob"))
+	})
+
+	t.Run("probe doesn't count for a member who rejoined meanwhile", func(t *testing.T) {
+		dispatcher := newFakeDispatcher()
+		dispatcher.reachable["alice-callback"] = true
+		room, clock := roomWithPresence(dispatcher)
+		config := presenceConfig
+		config.Probe = true
+
+		clock.Advance(2 * time.Minute)
+		dispatcher.onProbe = func(callbackUrl string) {
+			if callbackUrl != "alice-callback" {
+				return
+			}
+			room.Leave(context.Background(), "alice")
+			room.Join(context.Background(), "alice", "alice-callback")
+			clock.Advance(2 * time.Minute)
+		}
+		room.checkPresence(config)
+
+		room.mu.Lock()
+		defer room.mu.Unlock()
+		assert.Equal(t, 2*time.Minute, clock.Now().Sub(room.members["alice"].lastSeen))
+	})
+}
@@ -1,15 +1,53 @@
 package model
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"irc/server/audit"
+	"irc/server/delivery"
+	"irc/server/hook"
+	"irc/server/search"
+	"irc/server/spam"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ChatRoom struct {
 	ProxyMetadata
-	members map[string]string
+	mu         sync.Mutex
+	members    map[string]*member
+	clock      func() time.Time
+	dispatcher Dispatcher
+	policy     MessagePolicy
+	webhooks   []*Webhook
+	incoming   []*IncomingWebhook
+	// invited holds who may join while the room is invite-only
+	invited map[string]bool
+	bans    *banList
+	audit   *audit.Log
+	// spam is nil unless the store checks messages for spam
+	spam          *spam.Filter
+	spamDecisions []spam.Decision
+	// serverHooks are the store's message hooks, and hooks the room's own,
+	// configured by hookSpecs
+	serverHooks hook.Pipeline
+	hooks       hook.Pipeline
+	hookSpecs   []hook.Spec
+	// held is the moderation queue
+	held       []HeldMessage
+	lastHeldId int64
+
+	history       []Message
+	historyLimit  int
+	editWindow    time.Duration
+	lastMessageId int64
+	lastSeq       int64
+	index         *search.Index
+	// posted is the store's count of messages posted, nil outside a store
+	posted *int64
 }
 
 type ChatRoomMetadata struct {
@@ -18,54 +56,407 @@ type ChatRoomMetadata struct {
 }
 
 func EmptyChatRoom(id int, name string) *ChatRoom {
-	return &ChatRoom{ProxyMetadata{id, name}, make(map[string]string)}
+	return newChatRoom(id, name, time.Now, nil)
+}
+
+func newChatRoom(id int, name string, clock func() time.Time, dispatcher Dispatcher) *ChatRoom {
+	now := clock()
+	return &ChatRoom{
+		ProxyMetadata: ProxyMetadata{Id: id, Uid: newUid(), Name: name, CreatedAt: now, LastActivityAt: now},
+		members:       make(map[string]*member),
+		invited:       make(map[string]bool),
+		clock:         clock,
+		dispatcher:    dispatcher,
+		policy:        DefaultMessagePolicy,
+		historyLimit:  DefaultHistoryLimit,
+		editWindow:    DefaultEditWindow,
+	}
 }
 
-func (c *ChatRoom) Join(tag string, callbackUrl string) error {
+func (c *ChatRoom) Join(ctx context.Context, tag string, callbackUrl string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if _, ok := c.members[tag]; ok {
-		return fmt.Errorf(`"%s" already joined chat room %+v`, tag, *c.GetMetadata())
+		return errAlreadyJoined(tag, c.ProxyMetadata)
+	}
+	if c.isIncomingWebhook(tag) {
+		return fmt.Errorf(`"%s" is the name of a webhook of room %+v`, tag, c.ProxyMetadata)
+	}
+	if c.bans.banned(tag) {
+		return forbidden(`"%s" is banned from this server`, tag)
 	}
 
-	c.members[tag] = callbackUrl
+	// Invite-only rooms may only be joined by their creator and those invited
+	if c.hasMode(InviteOnlyMode) && tag != c.Creator {
+		if !c.invited[tag] {
+			return fmt.Errorf(`"%s" hasn't been invited to room %+v`, tag, c.ProxyMetadata)
+		}
+		delete(c.invited, tag)
+	}
+
+	// The creator is an operator, or if the room has none, whoever joins it
+	// first, as on IRC. Bots don't count.
+	operator := tag == c.Creator || (c.Creator == "" && c.humans() == 0)
+	c.members[tag] = &member{id: newUid(), callbackUrl: callbackUrl, status: Online, lastSeen: c.clock(), joinedAt: c.clock(), operator: operator}
+	c.record(Message{Type: JoinEvent, Tag: tag, Time: c.clock()})
+	c.logAction(ctx, audit.Entry{Action: audit.MemberJoin, Actor: tag, Target: tag, After: c.memberState(tag)})
+	return nil
+}
+
+func errAlreadyJoined(tag string, room ProxyMetadata) error {
+	return fmt.Errorf(`"%s" already joined chat room %+v`, tag, room)
+}
+
+// humans counts the members that aren't sinks. Must be called with c.mu held.
+func (c *ChatRoom) humans() int {
+	n := 0
+	for _, member := range c.members {
+		if member.sink == nil {
+			n += 1
+		}
+	}
+	return n
+}
+
+func (c *ChatRoom) IsOperator(tag string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.isOperator(tag)
+}
+
+func (c *ChatRoom) isOperator(tag string) bool {
+	member, ok := c.members[tag]
+	return ok && member.operator
+}
+
+// requireOperator returns an error unless tag is an operator of the room.
+// Must be called with c.mu held.
+func (c *ChatRoom) requireOperator(tag string) error {
+	if !c.hasJoined(tag) {
+		return fmt.Errorf(`"%s" hasn't joined room %+v`, tag, c.ProxyMetadata)
+	}
+	if !c.isOperator(tag) {
+		return forbidden(`"%s" is not an operator of room %+v`, tag, c.ProxyMetadata)
+	}
 	return nil
 }
 
 func (c *ChatRoom) HasJoined(tag string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hasJoined(tag)
+}
+
+func (c *ChatRoom) hasJoined(tag string) bool {
 	if _, ok := c.members[tag]; ok {
 		return true
 	}
 	return false
 }
 
-func (c *ChatRoom) Leave(tag string) error {
+func (c *ChatRoom) Leave(ctx context.Context, tag string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if _, ok := c.members[tag]; !ok {
 		return fmt.Errorf(`"%s" is not in chat room "%s"`, tag, c.Name)
 	}
 
-	delete(c.members, tag)
+	c.logAction(ctx, audit.Entry{Action: audit.MemberLeave, Actor: tag, Target: tag, Before: c.memberState(tag)})
+	c.removeMember(tag)
+	c.record(Message{Type: LeaveEvent, Tag: tag, Time: c.clock()})
+	return nil
+}
+
+// RoomUpdate holds the room fields to change in one go. Nil fields are left
+// as they are.
+type RoomUpdate struct {
+	Topic       *string
+	Description *string
+	Modes       *string
+}
+
+func (c *ChatRoom) SetTopic(ctx context.Context, tag string, topic string) error {
+	return c.Update(ctx, tag, RoomUpdate{Topic: &topic})
+}
+
+func (c *ChatRoom) SetDescription(ctx context.Context, tag string, description string) error {
+	return c.Update(ctx, tag, RoomUpdate{Description: &description})
+}
+
+func (c *ChatRoom) SetModes(ctx context.Context, tag string, changes string) error {
+	return c.Update(ctx, tag, RoomUpdate{Modes: &changes})
+}
+
+// Update applies every change in update on behalf of the member with the
+// given tag. All of them are checked before any is applied, so a change
+// that isn't allowed leaves the room untouched.
+func (c *ChatRoom) Update(ctx context.Context, tag string, update RoomUpdate) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.hasJoined(tag) {
+		return fmt.Errorf(`"%s" hasn't joined room %+v`, tag, c.ProxyMetadata)
+	}
+
+	modes := c.Modes
+	if update.Modes != nil {
+		if err := c.requireOperator(tag); err != nil {
+			return err
+		}
+		var err error
+		if modes, err = applyModeChanges(c.Modes, *update.Modes); err != nil {
+			return err
+		}
+	}
+	if update.Topic != nil && strings.ContainsRune(modes, TopicLockMode) && !c.isOperator(tag) {
+		return forbidden(`only operators may set the topic of room %+v`, c.ProxyMetadata)
+	}
+
+	if update.Description != nil {
+		c.logAction(ctx, audit.Entry{Action: audit.RoomUpdate, Actor: tag, Before: descriptionState(c.Description), After: descriptionState(*update.Description)})
+		c.Description = *update.Description
+	}
+	if update.Modes != nil {
+		if err := c.applyModes(ctx, tag, *update.Modes); err != nil {
+			return err
+		}
+	}
+	if update.Topic != nil {
+		return c.setTopic(ctx, tag, *update.Topic)
+	}
 	return nil
 }
 
+// setTopic sets the topic, which only operators may do once the room has
+// TopicLockMode set. Must be called with c.mu held.
+func (c *ChatRoom) setTopic(ctx context.Context, tag string, topic string) error {
+	if !c.hasJoined(tag) {
+		return fmt.Errorf(`"%s" hasn't joined room %+v`, tag, c.ProxyMetadata)
+	}
+	if c.hasMode(TopicLockMode) && !c.isOperator(tag) {
+		return forbidden(`only operators may set the topic of room %+v`, c.ProxyMetadata)
+	}
+
+	c.logAction(ctx, audit.Entry{Action: audit.RoomUpdate, Actor: tag, Before: topicState(c.Topic), After: topicState(&Topic{Text: topic})})
+	c.Topic = &Topic{Text: topic, SetBy: tag, SetAt: c.clock()}
+	c.LastActivityAt = c.Topic.SetAt
+	return c.broadcast(ctx, tag, CallbackBody{Type: TopicEvent, Topic: c.Topic})
+}
+
 // TODO: move to controller layer?
 type CallbackBody struct {
-	Message string `json:"message"`
-}
-
-func (c *ChatRoom) PostMessage(tag string, message string) error {
-	for member, callbackUrl := range c.members {
-		if member != tag {
-			// TODO: dispatch messages in parallel
-			// TODO: log client responses and errors
-			bs, err := json.Marshal(CallbackBody{Message: message})
-			if err != nil {
-				return err
-			}
-			http.Post(callbackUrl, "application/json", bytes.NewReader(bs))
+	Type string `json:"type"`
+	// Id is the ID of a message or action in the room's history.
+	Id       int64    `json:"id,omitempty"`
+	Tag      string   `json:"tag,omitempty"`
+	Message  string   `json:"message,omitempty"`
+	Topic    *Topic   `json:"topic,omitempty"`
+	Presence Presence `json:"presence,omitempty"`
+	Reason   string   `json:"reason,omitempty"`
+	Dropped  int      `json:"dropped,omitempty"`
+	Target   string   `json:"target,omitempty"`
+	Modes    string   `json:"modes,omitempty"`
+	// Part numbers the parts of a message that was split, from 1 to Parts.
+	Part  int `json:"part,omitempty"`
+	Parts int `json:"parts,omitempty"`
+	// Time is when a message held back for review was sent. Other events
+	// happen as they're sent.
+	Time *time.Time `json:"time,omitempty"`
+	// HeldId is the ID of a message in the room's moderation queue.
+	HeldId int64 `json:"heldId,omitempty"`
+	// Webhook is the ID of the incoming webhook a message was posted with,
+	// if it was posted with one.
+	Webhook string `json:"webhook,omitempty"`
+}
+
+// Event types sent to members in CallbackBody.Type
+const (
+	MessageEvent = "message"
+	TopicEvent   = "topic"
+	// JoinEvent is kept in the history when Tag joins the room. Members
+	// aren't sent it.
+	JoinEvent = "join"
+	// PresenceEvent tells members that Tag's presence changed to Presence.
+	PresenceEvent = "presence"
+	// LeaveEvent tells members that Tag was removed from the room, and why.
+	LeaveEvent = "leave"
+	// PingEvent probes a member's callback; it needs no handling beyond a 2xx response.
+	PingEvent = "ping"
+	// NoticeEvent is a Message from the server itself, rather than a member.
+	NoticeEvent = "notice"
+	// ActionEvent is a Message describing what Tag is doing, sent with /me.
+	ActionEvent = "action"
+	// NickEvent tells members that Tag is now known as Target.
+	NickEvent = "nick"
+	// ModeEvent tells members that Tag changed the room's modes to Modes, or
+	// gave or took operator status ("+o" or "-o") from Target.
+	ModeEvent = "mode"
+	// InviteEvent tells members that Tag invited Target to the room.
+	InviteEvent = "invite"
+	// DroppedEvent tells a member that messages queued while its callback
+	// was unreachable were dropped, and how many.
+	DroppedEvent = "dropped"
+	// MessageApprovedEvent tells a member that Tag approved its message
+	// HeldId, which was posted as the message Id.
+	MessageApprovedEvent = "message_approved"
+	// MessageRejectedEvent tells a member that Tag rejected its message
+	// HeldId, and why.
+	MessageRejectedEvent = "message_rejected"
+	// MessageEditedEvent tells members that Tag edited the message Id,
+	// posted by Target, to read Message.
+	MessageEditedEvent = "message_edited"
+	// MessageDeletedEvent tells members that Tag deleted the message Id,
+	// posted by Target.
+	MessageDeletedEvent = "message_deleted"
+)
+
+// DroppedNotice is the body of a DroppedEvent, for use as
+// delivery.Config.DroppedNotice. Webhooks aren't sent one, as the services
+// they post to expect messages in their own format.
+func DroppedNotice(r delivery.Recipient, dropped int) []byte {
+	if strings.HasPrefix(r.Tag, webhookRecipient) {
+		return nil
+	}
+	bs, _ := json.Marshal(CallbackBody{Type: DroppedEvent, Dropped: dropped})
+	return bs
+}
+
+func (c *ChatRoom) PostMessage(ctx context.Context, tag string, message string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if isCommand(message) {
+		return c.runCommand(ctx, tag, message)
+	}
+	c.seen(ctx, tag)
+	return c.submit(ctx, MessageEvent, tag, "", unescapeCommand(message))
+}
+
+// submit runs a message, or action, through the room's hooks, message policy
+// and spam rules, then posts it as sent by from, with the incoming webhook
+// with the given ID if it's posted with one, or holds it back for review if
+// a hook or the room's modes say so. Must be called with c.mu held.
+func (c *ChatRoom) submit(ctx context.Context, eventType string, from string, webhook string, text string) error {
+	text, verdict, err := c.runHooks(ctx, eventType, from, text)
+	if err != nil {
+		return err
+	}
+	parts, err := c.policy.Apply(text)
+	if err != nil {
+		return err
+	}
+	decision, err := c.checkSpam(ctx, from, text)
+	if err != nil {
+		return err
+	}
+	// Warned whether the message is posted or held
+	c.warnSpam(ctx, decision)
+
+	switch {
+	case verdict.Action == hook.Hold:
+		return c.hold(eventType, from, webhook, text, parts, verdict.Reason, verdict.Hook)
+	case c.moderated(from):
+		return c.hold(eventType, from, webhook, text, parts, "the room is moderated", "")
+	}
+	return c.post(ctx, eventType, from, webhook, parts, time.Time{})
+}
+
+// post broadcasts the parts of a message, or action, as sent by from, or
+// with the incoming webhook with the given ID, numbering them for the
+// history. Messages held back for review are posted with the time they were
+// sent at; the rest have a zero sent. Must be called with c.mu held.
+func (c *ChatRoom) post(ctx context.Context, eventType string, from string, webhook string, parts []string, sent time.Time) error {
+	// Members aren't sent their own messages, but webhooks have no member
+	exclude := from
+	if webhook != "" {
+		exclude = ""
+	}
+
+	c.LastActivityAt = c.clock()
+	for i, part := range parts {
+		c.lastMessageId += 1
+		body := CallbackBody{Type: eventType, Id: c.lastMessageId, Tag: from, Message: part, Webhook: webhook}
+		if !sent.IsZero() {
+			body.Time = &sent
+		}
+		if len(parts) > 1 {
+			body.Part, body.Parts = i+1, len(parts)
 		}
+		if err := c.broadcast(ctx, exclude, body); err != nil {
+			return err
+		}
+	}
+	if c.posted != nil {
+		atomic.AddInt64(c.posted, 1)
+	}
+	return nil
+}
+
+func (c *ChatRoom) postNotice(ctx context.Context, message string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.broadcast(ctx, "", CallbackBody{Type: NoticeEvent, Message: message})
+}
+
+// broadcast dispatches body to the callback or sink of every member except
+// the one with the given tag, and to the room's webhooks, and keeps it in
+// the history. Rooms created
+// outside a store have no dispatcher, and can only deliver to sinks. Must be
+// called with c.mu held.
+func (c *ChatRoom) broadcast(ctx context.Context, tag string, body CallbackBody) error {
+	bs, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	c.remember(body)
+
+	for other, member := range c.members {
+		switch {
+		case other == tag:
+		case member.sink != nil:
+			member.sink.send(ctx, body)
+		case c.dispatcher != nil:
+			c.dispatcher.Dispatch(ctx, other, member.callbackUrl, bs)
+		}
+	}
+	if c.dispatcher != nil {
+		c.notifyWebhooks(ctx, body)
+	}
+	return nil
+}
+
+// send dispatches body to the callback or sink of the member with the given
+// tag alone, without keeping it in the history. Must be called with c.mu
+// held.
+func (c *ChatRoom) send(ctx context.Context, tag string, body CallbackBody) error {
+	member, ok := c.members[tag]
+	if !ok {
+		return nil
+	}
+	bs, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case member.sink != nil:
+		member.sink.send(ctx, body)
+	case c.dispatcher != nil:
+		c.dispatcher.Dispatch(ctx, tag, member.callbackUrl, bs)
 	}
 	return nil
 }
 
 func (c *ChatRoom) GetMetadata() *ProxyMetadata {
-	return &ProxyMetadata{c.Id, c.Name}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	meta := c.ProxyMetadata
+	meta.MemberCount = len(c.members)
+	return &meta
 }
@@ -1,6 +1,7 @@
 package model
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,22 +16,22 @@ func TestJoin(t *testing.T) {
 	t.Run("join empty room", func(t *testing.T) {
 		room := EmptyChatRoom(roomId, roomName)
 
-		err := room.Join(userName, callbackUrl)
+		err := room.Join(context.Background(), userName, callbackUrl)
 
 		assert.Nil(t, err)
-		assert.Equal(t, callbackUrl, room.members[userName])
+		assert.Equal(t, callbackUrl, room.members[userName].callbackUrl)
 	})
 
 	t.Run("join twice", func(t *testing.T) {
 		room := EmptyChatRoom(roomId, roomName)
 
-		err := room.Join(userName, callbackUrl)
+		err := room.Join(context.Background(), userName, callbackUrl)
 		assert.Nil(t, err)
 
-		err = room.Join(userName, callbackUrl)
+		err = room.Join(context.Background(), userName, callbackUrl)
 		assert.NotNil(t, err)
 
-		assert.Equal(t, callbackUrl, room.members[userName])
+		assert.Equal(t, callbackUrl, room.members[userName].callbackUrl)
 	})
 }
 
@@ -38,7 +39,7 @@ func TestLeave(t *testing.T) {
 	t.Run("leave empty room", func(t *testing.T) {
 		room := EmptyChatRoom(roomId, roomName)
 
-		err := room.Leave(userName)
+		err := room.Leave(context.Background(), userName)
 
 		assert.NotNil(t, err)
 
@@ -49,13 +50,102 @@ func TestLeave(t *testing.T) {
 	t.Run("leave joined room", func(t *testing.T) {
 		room := EmptyChatRoom(roomId, roomName)
 
-		err := room.Join(userName, callbackUrl)
+		err := room.Join(context.Background(), userName, callbackUrl)
 		assert.Nil(t, err)
 
-		err = room.Leave(userName)
+		err = room.Leave(context.Background(), userName)
 		assert.Nil(t, err)
 
 		_, ok := room.members[userName]
 		assert.False(t, ok)
 	})
 }
+
+func TestSetTopic(t *testing.T) {
+	t.Run("non-member can't set topic", func(t *testing.T) {
+		room := EmptyChatRoom(roomId, roomName)
+
+		err := room.SetTopic(context.Background(), userName, "a topic")
+
+		assert.NotNil(t, err)
+		assert.Nil(t, room.GetMetadata().Topic)
+	})
+
+	t.Run("member sets topic", func(t *testing.T) {
+		room := EmptyChatRoom(roomId, roomName)
+
+		err := room.Join(context.Background(), userName, callbackUrl)
+		assert.Nil(t, err)
+
+		err = room.SetTopic(context.Background(), userName, "a topic")
+		assert.Nil(t, err)
+
+		topic := room.GetMetadata().Topic
+		assert.Equal(t, "a topic", topic.Text)
+		assert.Equal(t, userName, topic.SetBy)
+		assert.False(t, topic.SetAt.IsZero())
+	})
+}
+
+func TestSetModes(t *testing.T) {
+	ctx := context.Background()
+	room, dispatcher := newCommandRoom()
+
+	err := room.SetModes(ctx, "bob", "+m")
+	assert.Equal(t, ForbiddenCode, commandCodeOf(err))
+
+	err = room.SetModes(ctx, "alice", "+mt")
+	assert.Nil(t, err)
+	assert.Equal(t, "mt", room.Modes)
+	assert.Equal(t, []CallbackBody{{Type: ModeEvent, Tag: "alice", Modes: "mt"}}, dispatcher.received("bob"))
+}
+
+func TestUpdate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("applies every change", func(t *testing.T) {
+		room, _ := newCommandRoom()
+		topic, description, modes := "news", "a room for news", "+t"
+
+		err := room.Update(ctx, "alice", RoomUpdate{Topic: &topic, Description: &description, Modes: &modes})
+		assert.Nil(t, err)
+		assert.Equal(t, "news", room.Topic.Text)
+		assert.Equal(t, "a room for news", room.Description)
+		assert.Equal(t, "t", room.Modes)
+	})
+
+	t.Run("rejected change leaves room untouched", func(t *testing.T) {
+		room, dispatcher := newCommandRoom()
+		topic, description, modes := "news", "a room for news", "+x"
+
+		err := room.Update(ctx, "alice", RoomUpdate{Topic: &topic, Description: &description, Modes: &modes})
+		assert.NotNil(t, err)
+		assert.Nil(t, room.Topic)
+		assert.Equal(t, "", room.Description)
+		assert.Equal(t, "", room.Modes)
+		assert.Empty(t, dispatcher.received("bob"))
+	})
+
+	t.Run("topic is checked against the new modes", func(t *testing.T) {
+		room, _ := newCommandRoom()
+		topic, description := "news", "a room for news"
+		room.SetModes(ctx, "alice", "+t")
+
+		err := room.Update(ctx, "bob", RoomUpdate{Topic: &topic, Description: &description})
+		assert.Equal(t, ForbiddenCode, commandCodeOf(err))
+		assert.Equal(t, "", room.Description)
+	})
+}
+
+func TestGetMetadata(t *testing.T) {
+	t.Run("counts members", func(t *testing.T) {
+		room := EmptyChatRoom(roomId, roomName)
+
+		err := room.Join(context.Background(), userName, callbackUrl)
+		assert.Nil(t, err)
+
+		meta := room.GetMetadata()
+		assert.Equal(t, 1, meta.MemberCount)
+		assert.False(t, meta.CreatedAt.IsZero())
+	})
+}
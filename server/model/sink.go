@@ -0,0 +1,91 @@
+package model
+
+import (
+	"context"
+	"irc/server/audit"
+	"irc/server/requestid"
+)
+
+// Sink receives a room's events in-process, for members without a callback
+// URL, like bots. Events are delivered in order, one at a time, and not
+// while the room is locked, so Receive may call back into the room.
+type Sink interface {
+	Receive(ctx context.Context, room MessageProxy, event CallbackBody)
+}
+
+// sinkQueueLength is how many events may wait for a slow sink before new
+// ones are dropped.
+const sinkQueueLength = 256
+
+type sinkEvent struct {
+	requestId string
+	body      CallbackBody
+}
+
+// sinkQueue feeds events to a sink from its own goroutine.
+type sinkQueue struct {
+	events chan sinkEvent
+}
+
+func startSink(room *ChatRoom, sink Sink) *sinkQueue {
+	q := &sinkQueue{events: make(chan sinkEvent, sinkQueueLength)}
+	go func() {
+		for event := range q.events {
+			// The request that caused the event may be long finished, so only
+			// its ID is passed on
+			ctx := requestid.NewContext(context.Background(), event.requestId)
+			sink.Receive(ctx, room, event.body)
+		}
+	}()
+	return q
+}
+
+func (q *sinkQueue) send(ctx context.Context, body CallbackBody) {
+	select {
+	case q.events <- sinkEvent{requestid.FromContext(ctx), body}:
+	default:
+	}
+}
+
+func (q *sinkQueue) stop() {
+	close(q.events)
+}
+
+// JoinSink adds a member that receives events through sink rather than a
+// callback URL. Such members are always online, and never evicted.
+func (c *ChatRoom) JoinSink(tag string, sink Sink) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.members[tag]; ok {
+		return errAlreadyJoined(tag, c.ProxyMetadata)
+	}
+
+	c.members[tag] = &member{id: newUid(), status: Online, lastSeen: c.clock(), joinedAt: c.clock(), sink: startSink(c, sink)}
+	c.record(Message{Type: JoinEvent, Tag: tag, Time: c.clock()})
+	c.logAction(context.Background(), audit.Entry{Action: audit.MemberJoin, Actor: tag, Target: tag, After: c.memberState(tag)})
+	return nil
+}
+
+// removeMember removes the member with the given tag, stopping its sink if
+// it has one and dropping its held messages. Must be called with c.mu held.
+func (c *ChatRoom) removeMember(tag string) {
+	if member, ok := c.members[tag]; ok && member.sink != nil {
+		member.sink.stop()
+	}
+	delete(c.members, tag)
+	c.dropHeld(tag)
+}
+
+// close stops the sinks of every member, once the room is deleted.
+func (c *ChatRoom) close(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	meta := c.ProxyMetadata
+	meta.MemberCount = len(c.members)
+	c.logAction(ctx, audit.Entry{Action: audit.RoomDelete, Before: audit.State(meta)})
+	for tag := range c.members {
+		c.removeMember(tag)
+	}
+}
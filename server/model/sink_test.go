@@ -0,0 +1,70 @@
+package model
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSink passes on the events it receives.
+type recordingSink chan CallbackBody
+
+func (s recordingSink) Receive(ctx context.Context, room MessageProxy, event CallbackBody) {
+	s <- event
+}
+
+func (s recordingSink) next(t *testing.T) CallbackBody {
+	t.Helper()
+	select {
+	case event := <-s:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected an event")
+		return CallbackBody{}
+	}
+}
+
+func TestJoinSink(t *testing.T) {
+	t.Run("receives events without a dispatcher", func(t *testing.T) {
+		room := EmptyChatRoom(roomId, roomName)
+		sink := make(recordingSink, 10)
+		room.Join(context.Background(), "alice", callbackUrl)
+
+		err := room.JoinSink("bot", sink)
+		assert.Nil(t, err)
+		err = room.JoinSink("bot", sink)
+		assert.NotNil(t, err)
+
+		room.PostMessage(context.Background(), "alice", "hello")
+		assert.Equal(t, CallbackBody{Type: MessageEvent, Id: 1, Tag: "alice", Message: "hello"}, sink.next(t))
+	})
+
+	t.Run("bots aren't operators, or evicted", func(t *testing.T) {
+		dispatcher := newFakeDispatcher()
+		clock := &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+		room := newChatRoom(roomId, roomName, clock.Now, dispatcher)
+		room.JoinSink("bot", make(recordingSink, 10))
+		room.Join(context.Background(), "alice", "alice-callback")
+
+		assert.False(t, room.IsOperator("bot"))
+		assert.True(t, room.IsOperator("alice"))
+
+		clock.Advance(time.Hour)
+		room.checkPresence(presenceConfig)
+
+		assert.Equal(t, []MemberInfo{{Tag: "bot", Presence: Online, LastSeen: clock.now.Add(-time.Hour), Bot: true}}, room.GetMembers())
+	})
+
+	t.Run("deleting the room stops sinks", func(t *testing.T) {
+		store := NewChatRoomStore(WithDispatcher(newFakeDispatcher()), WithSink("bot", make(recordingSink, 10)))
+		id, _ := store.AddProxy(context.Background(), roomName, "")
+		room := store.chatRooms[id]
+		assert.True(t, room.HasJoined("bot"))
+
+		err := store.DeleteProxy(context.Background(), id)
+		assert.Nil(t, err)
+		assert.False(t, room.HasJoined("bot"))
+	})
+}
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"context"
+	"irc/server/audit"
+	"irc/server/spam"
+	"time"
+)
+
+// Codes identifying why a message was rejected as spam
+const (
+	// SpamCode rejects a message a spam rule caught.
+	SpamCode = "spam"
+	// MutedCode rejects the messages of a member muted for spamming.
+	MutedCode = "muted"
+)
+
+// maxSpamDecisions caps how many spam decisions a room keeps for its
+// operators.
+const maxSpamDecisions = 100
+
+// WithSpamConfig makes the store's rooms check posted messages for floods
+// and spam, as config sets. Rooms don't check them by default.
+func WithSpamConfig(config spam.Config) StoreOption {
+	return func(s *ChatRoomStore) {
+		s.spam = config
+	}
+}
+
+// checkSpam checks a message from the member with the given tag before it's
+// posted, taking the action of any spam rule it breaks. It returns the
+// decision, if a rule was broken, and the error rejecting the message, if
+// it's rejected rather than posted with a warning. Operators and bots aren't
+// checked. Must be called with c.mu held.
+func (c *ChatRoom) checkSpam(ctx context.Context, tag string, message string) (*spam.Decision, error) {
+	member, ok := c.members[tag]
+	if !ok || member.operator || member.sink != nil {
+		return nil, nil
+	}
+
+	now := c.clock()
+	if now.Before(member.mutedUntil) {
+		return nil, invalidMessage(MutedCode, `"%s" is muted for spamming until %s`, tag, member.mutedUntil.Format(time.RFC3339))
+	}
+	if c.spam == nil {
+		return nil, nil
+	}
+
+	members := make([]string, 0, len(c.members))
+	for other := range c.members {
+		members = append(members, other)
+	}
+	decision := c.spam.Check(spam.Post{Tag: tag, Text: message, Time: now, JoinedAt: member.joinedAt, Members: members})
+	if decision == nil {
+		return nil, nil
+	}
+	c.spamDecisions = append(c.spamDecisions, *decision)
+	if excess := len(c.spamDecisions) - maxSpamDecisions; excess > 0 {
+		c.spamDecisions = append([]spam.Decision(nil), c.spamDecisions[excess:]...)
+	}
+
+	switch decision.Action {
+	case spam.Warn:
+		return decision, nil
+	case spam.Mute:
+		member.mutedUntil = *decision.MutedUntil
+		c.logAction(ctx, audit.Entry{Action: audit.MemberMute, Target: tag, Reason: decision.Reason, After: audit.State(map[string]time.Time{"mutedUntil": member.mutedUntil})})
+	case spam.Kick:
+		reason := "spam: " + decision.Reason
+		c.logAction(ctx, audit.Entry{Action: audit.MemberKick, Target: tag, Reason: reason, Before: c.memberState(tag)})
+		c.broadcast(ctx, "", CallbackBody{Type: LeaveEvent, Tag: tag, Reason: reason})
+		c.removeMember(tag)
+	}
+	return decision, invalidMessage(SpamCode, "message rejected as spam: %s", decision.Reason)
+}
+
+// warnSpam sends the member who posted a message a spam rule let through a
+// notice warning it. Must be called with c.mu held.
+func (c *ChatRoom) warnSpam(ctx context.Context, decision *spam.Decision) {
+	if decision == nil || decision.Action != spam.Warn {
+		return
+	}
+	c.send(ctx, decision.Tag, CallbackBody{Type: NoticeEvent, Message: "warning: " + decision.Reason})
+}
+
+// SpamDecisions lists the messages the room's spam rules caught, oldest
+// first, which only its operators may see.
+func (c *ChatRoom) SpamDecisions(tag string) ([]spam.Decision, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.requireOperator(tag); err != nil {
+		return nil, err
+	}
+	return append([]spam.Decision{}, c.spamDecisions...), nil
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"context"
+	"irc/server/spam"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpam(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	dispatcher := newFakeDispatcher()
+	config := spam.Config{
+		MaxDuplicates:   1,
+		DuplicateWindow: time.Minute,
+		MaxMentions:     1,
+		MaxLinks:        1,
+		Actions:         map[spam.Rule]spam.Action{spam.Duplicate: spam.Mute, spam.Mentions: spam.Warn, spam.Links: spam.Kick},
+		MuteFor:         time.Minute,
+	}
+	store := NewChatRoomStore(WithDispatcher(dispatcher), WithClock(clock.Now), WithSpamConfig(config))
+	id, _ := store.AddProxy(ctx, roomName, "alice")
+	room, _ := store.GetProxy(id)
+	for _, tag := range []string{"alice", "bob", "carol", "dave"} {
+		room.Join(ctx, tag, tag+"-callback")
+	}
+
+	t.Run("warn", func(t *testing.T) {
+		assert.Nil(t, room.PostMessage(ctx, "bob", "carol, dave: look"))
+		assert.Equal(t, CallbackBody{Type: NoticeEvent, Message: "warning: mentioned 2 members"}, dispatcher.received("bob")[0])
+		assert.Equal(t, "carol, dave: look", dispatcher.received("alice")[0].Message, "warned messages are posted")
+	})
+
+	t.Run("mute", func(t *testing.T) {
+		assert.Nil(t, room.PostMessage(ctx, "carol", "hello"))
+		assert.Equal(t, SpamCode, codeOf(room.PostMessage(ctx, "carol", "Hello!")))
+		assert.Equal(t, MutedCode, codeOf(room.PostMessage(ctx, "carol", "something else")))
+		assert.Equal(t, MutedCode, codeOf(room.PostMessage(ctx, "carol", "/me waves")))
+
+		clock.Advance(time.Minute)
+		assert.Nil(t, room.PostMessage(ctx, "carol", "something else"), "mutes wear off")
+	})
+
+	t.Run("kick", func(t *testing.T) {
+		assert.Equal(t, SpamCode, codeOf(room.PostMessage(ctx, "dave", "https://a.example https://b.example")))
+		assert.False(t, room.HasJoined("dave"))
+		events := dispatcher.received("dave")
+		assert.Equal(t, CallbackBody{Type: LeaveEvent, Tag: "dave", Reason: "spam: posted 2 links"}, events[len(events)-1])
+	})
+
+	t.Run("operators are exempt", func(t *testing.T) {
+		assert.Nil(t, room.PostMessage(ctx, "alice", "again"))
+		assert.Nil(t, room.PostMessage(ctx, "alice", "again"))
+	})
+
+	t.Run("decisions are visible to operators", func(t *testing.T) {
+		decisions, err := room.SpamDecisions("alice")
+		assert.Nil(t, err)
+		var actions []spam.Action
+		for _, d := range decisions {
+			actions = append(actions, d.Action)
+		}
+		assert.Equal(t, []spam.Action{spam.Warn, spam.Mute, spam.Kick}, actions)
+
+		_, err = room.SpamDecisions("bob")
+		assert.Equal(t, ForbiddenCode, commandCodeOf(err))
+	})
+}
@@ -1,41 +1,141 @@
 package model
 
 import (
+	"context"
 	"fmt"
+	"irc/server/audit"
+	"irc/server/delivery"
+	"irc/server/health"
+	"irc/server/hook"
+	"irc/server/search"
+	"irc/server/spam"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type ChatRoomStore struct {
+	mu          sync.RWMutex
 	roomCounter int
 	chatRooms   map[int]*ChatRoom
+	clock       func() time.Time
+	dispatcher  Dispatcher
+	policy      MessagePolicy
+	sinks       []storeSink
+	history     int
+	editWindow  time.Duration
+	index       *search.Index
+	bans        *banList
+	audit       *audit.Log
+	spam        spam.Config
+	hooks       hook.Pipeline
+	// posted counts the messages broadcast across rooms
+	posted *int64
 }
 
-var store *ChatRoomStore
+type storeSink struct {
+	tag  string
+	sink Sink
+}
+
+type StoreOption func(*ChatRoomStore)
+
+// WithDispatcher sets the Dispatcher rooms use to deliver events to members.
+func WithDispatcher(dispatcher Dispatcher) StoreOption {
+	return func(s *ChatRoomStore) {
+		s.dispatcher = dispatcher
+	}
+}
 
-func NewChatRoomStore() *ChatRoomStore {
-	return &ChatRoomStore{roomCounter: 0, chatRooms: make(map[int]*ChatRoom)}
+// WithMessagePolicy sets the policy rooms apply to posted messages. Defaults
+// to DefaultMessagePolicy.
+func WithMessagePolicy(policy MessagePolicy) StoreOption {
+	return func(s *ChatRoomStore) {
+		s.policy = policy
+	}
 }
 
-func InitChatRoomStore() {
-	store = NewChatRoomStore()
+// WithSink makes every room the store creates join a member with the given
+// tag that receives events through sink, like a bot.
+func WithSink(tag string, sink Sink) StoreOption {
+	return func(s *ChatRoomStore) {
+		s.sinks = append(s.sinks, storeSink{tag, sink})
+	}
 }
 
-func GetChatRoomStore() *ChatRoomStore {
-	return store
+// WithHistoryLimit sets how many messages and events each room keeps, for
+// search and export. Defaults to DefaultHistoryLimit.
+func WithHistoryLimit(limit int) StoreOption {
+	return func(s *ChatRoomStore) {
+		s.history = limit
+	}
+}
+
+// WithClock sets the source of the current time, for tests.
+func WithClock(clock func() time.Time) StoreOption {
+	return func(s *ChatRoomStore) {
+		s.clock = clock
+	}
+}
+
+func NewChatRoomStore(opts ...StoreOption) *ChatRoomStore {
+	s := &ChatRoomStore{roomCounter: 0, chatRooms: make(map[int]*ChatRoom), clock: time.Now, policy: DefaultMessagePolicy, history: DefaultHistoryLimit, editWindow: DefaultEditWindow, index: search.NewIndex(), bans: newBanList(), posted: new(int64)}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.dispatcher == nil {
+		s.dispatcher = delivery.NewDispatcher(delivery.Config{DroppedNotice: DroppedNotice})
+	}
+	return s
 }
 
-func (s *ChatRoomStore) AddProxy(name string) (int, error) {
-	if !s.hasUniqueChatRoomName(name) {
+func (s *ChatRoomStore) AddProxy(ctx context.Context, name string, creator string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	normalized, err := NormalizeChatRoomName(name)
+	if err != nil {
+		return 0, err
+	}
+	if _, ok := s.findByName(normalized); ok {
 		return 0, fmt.Errorf("cannot create duplicate chat room: %q", name)
 	}
 
 	id := s.roomCounter
 	s.roomCounter += 1
-	s.chatRooms[id] = EmptyChatRoom(id, name)
+	room := newChatRoom(id, name, s.clock, s.dispatcher)
+	room.Creator = creator
+	room.policy = s.policy
+	room.historyLimit = s.history
+	room.editWindow = s.editWindow
+	room.index = s.index
+	room.bans = s.bans
+	room.audit = s.audit
+	room.serverHooks = s.hooks
+	room.posted = s.posted
+	if s.spam.Enabled() {
+		room.spam = spam.NewFilter(s.spam)
+	}
+	room.logAction(ctx, audit.Entry{Action: audit.RoomCreate, Actor: creator, After: audit.State(room.ProxyMetadata)})
+	for _, sink := range s.sinks {
+		room.JoinSink(sink.tag, sink.sink)
+	}
+	s.chatRooms[id] = room
 	return id, nil
 }
 
+// MessagesPosted returns how many messages, and actions, have been broadcast
+// across the store's rooms. Messages split into parts count once, and held
+// messages count once they're approved.
+func (s *ChatRoomStore) MessagesPosted() int64 {
+	return atomic.LoadInt64(s.posted)
+}
+
 func (s *ChatRoomStore) GetMetadata() []ProxyMetadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	// Ensures that chat room metadata is retrieved sorted by room ID
 	keys := make([]int, 0, len(s.chatRooms))
 	for k := range s.chatRooms {
@@ -44,7 +144,7 @@ func (s *ChatRoomStore) GetMetadata() []ProxyMetadata {
 	sort.Ints(keys)
 
 	rooms := make([]ProxyMetadata, 0, len(s.chatRooms))
-	for k := range keys {
+	for _, k := range keys {
 		room := s.chatRooms[k]
 		rooms = append(rooms, *room.GetMetadata())
 	}
@@ -52,6 +152,9 @@ func (s *ChatRoomStore) GetMetadata() []ProxyMetadata {
 }
 
 func (s *ChatRoomStore) GetProxy(id int) (MessageProxy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if room, ok := s.chatRooms[id]; !ok {
 		return nil, fmt.Errorf("chat room does not exist: %d", id)
 	} else {
@@ -59,21 +162,115 @@ func (s *ChatRoomStore) GetProxy(id int) (MessageProxy, error) {
 	}
 }
 
-func (s *ChatRoomStore) DeleteProxy(id int) error {
+func (s *ChatRoomStore) GetProxyByUid(uid string) (MessageProxy, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, room := range s.chatRooms {
+		if room.Uid == uid {
+			return room, nil
+		}
+	}
+	return nil, fmt.Errorf("chat room does not exist: %s", uid)
+}
+
+func (s *ChatRoomStore) GetProxyByName(name string) (MessageProxy, error) {
+	normalized, err := NormalizeChatRoomName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if room, ok := s.findByName(normalized); ok {
+		return room, nil
+	}
+	return nil, fmt.Errorf("chat room does not exist: %q", name)
+}
+
+func (s *ChatRoomStore) DeleteProxy(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if _, ok := s.chatRooms[id]; !ok {
 		return fmt.Errorf("chat room does not exist: %d", id)
 	} else {
-		// TODO: cleanup/flush chat room resources?
+		s.chatRooms[id].close(ctx)
+		if s.index != nil {
+			s.index.RemoveRoom(id)
+		}
 		delete(s.chatRooms, id)
 		return nil
 	}
 }
 
-func (s *ChatRoomStore) hasUniqueChatRoomName(name string) bool {
+// PostNotice sends a notice from the server to the members of every room.
+func (s *ChatRoomStore) PostNotice(ctx context.Context, message string) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, room := range s.chatRooms {
+		if err := room.postNotice(ctx, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search finds the messages matching query in the rooms member may see:
+// every room but secret ones, which only their members may search.
+func (s *ChatRoomStore) Search(query search.Query, member string) ([]search.Result, *search.Cursor) {
+	if s.index == nil {
+		return nil, nil
+	}
+
+	s.mu.RLock()
+	var rooms []int
+	for id, room := range s.chatRooms {
+		if query.Rooms != nil && !containsInt(query.Rooms, id) {
+			continue
+		}
+		if room.VisibleTo(member) {
+			rooms = append(rooms, id)
+		}
+	}
+	s.mu.RUnlock()
+
+	// An empty, rather than nil, list of rooms searches none
+	query.Rooms = append([]int{}, rooms...)
+	return s.index.Search(query)
+}
+
+func containsInt(ints []int, i int) bool {
+	for _, candidate := range ints {
+		if candidate == i {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterChecks registers a check that the store is loaded and its lock
+// can be taken.
+func (s *ChatRoomStore) RegisterChecks(r *health.Registry) {
+	r.Register("store", health.CheckFunc(func(ctx context.Context) error {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		if s.chatRooms == nil {
+			return fmt.Errorf("store not loaded")
+		}
+		return nil
+	}))
+}
+
+// findByName looks up a chat room by its normalized name. Must be called
+// with s.mu held.
+func (s *ChatRoomStore) findByName(normalized string) (*ChatRoom, bool) {
 	for _, room := range s.chatRooms {
-		if room.Name == name {
-			return false
+		if name, _ := NormalizeChatRoomName(room.Name); name == normalized {
+			return room, true
 		}
 	}
-	return true
+	return nil, false
 }
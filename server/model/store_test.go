@@ -1,7 +1,9 @@
 package model
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -22,13 +24,25 @@ func TestGetChatRoomMeta(t *testing.T) {
 
 		assert.Equal(t, 3, len(meta))
 	})
+
+	t.Run("server with a deleted room", func(t *testing.T) {
+		s := storeWithThreeChatRooms()
+
+		err := s.DeleteProxy(context.Background(), 0)
+		assert.Nil(t, err)
+
+		meta := s.GetMetadata()
+
+		assert.Equal(t, 2, len(meta))
+		assert.Equal(t, 1, meta[0].Id)
+	})
 }
 
 func TestAddChatRoom(t *testing.T) {
 	t.Run("new server will have 1 chat room", func(t *testing.T) {
 		s := NewChatRoomStore()
 
-		_, err := s.AddProxy("room1")
+		_, err := s.AddProxy(context.Background(), "room1", "")
 
 		assert.Nil(t, err)
 		assert.Equal(t, len(s.chatRooms), 1)
@@ -37,19 +51,28 @@ func TestAddChatRoom(t *testing.T) {
 	t.Run("server with existing rooms will have 1 more", func(t *testing.T) {
 		s := storeWithThreeChatRooms()
 
-		_, err := s.AddProxy("room3")
+		_, err := s.AddProxy(context.Background(), "room3", "")
 
 		assert.Nil(t, err)
 		assert.Equal(t, len(s.chatRooms), 4)
 	})
 
+	t.Run("records creator", func(t *testing.T) {
+		s := NewChatRoomStore()
+
+		id, err := s.AddProxy(context.Background(), "room1", "creator")
+		assert.Nil(t, err)
+
+		assert.Equal(t, "creator", s.chatRooms[id].GetMetadata().Creator)
+	})
+
 	t.Run("chat room names must be unique", func(t *testing.T) {
 		s := NewChatRoomStore()
 
-		_, err := s.AddProxy("room1")
+		_, err := s.AddProxy(context.Background(), "room1", "")
 		assert.Nil(t, err)
 
-		_, err = s.AddProxy("room1")
+		_, err = s.AddProxy(context.Background(), "room1", "")
 		assert.NotNil(t, err)
 
 		assert.Equal(t, len(s.chatRooms), 1)
@@ -76,11 +99,50 @@ func TestGetChatRoom(t *testing.T) {
 	})
 }
 
+func TestGetChatRoomByName(t *testing.T) {
+	t.Run("err if name not present", func(t *testing.T) {
+		s := storeWithThreeChatRooms()
+
+		room, err := s.GetProxyByName("room3")
+		assert.NotNil(t, err)
+		assert.Nil(t, room)
+	})
+
+	t.Run("ignores case and prefix", func(t *testing.T) {
+		s := storeWithThreeChatRooms()
+
+		for _, name := range []string{"room1", "ROOM1", "#Room1"} {
+			room, err := s.GetProxyByName(name)
+			assert.Nil(t, err)
+			assert.Equal(t, 1, room.GetMetadata().Id)
+		}
+	})
+}
+
+func TestGetChatRoomByUid(t *testing.T) {
+	t.Run("err if uid not present", func(t *testing.T) {
+		s := storeWithThreeChatRooms()
+
+		room, err := s.GetProxyByUid("not-a-uid")
+		assert.NotNil(t, err)
+		assert.Nil(t, room)
+	})
+
+	t.Run("returns room with associated uid", func(t *testing.T) {
+		s := storeWithThreeChatRooms()
+		uid := s.chatRooms[2].Uid
+
+		room, err := s.GetProxyByUid(uid)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, room.GetMetadata().Id)
+	})
+}
+
 func TestDeleteChatRoom(t *testing.T) {
 	t.Run("err if room ID not present", func(t *testing.T) {
 		s := NewChatRoomStore()
 
-		err := s.DeleteProxy(0)
+		err := s.DeleteProxy(context.Background(), 0)
 		assert.NotNil(t, err)
 	})
 
@@ -89,7 +151,7 @@ func TestDeleteChatRoom(t *testing.T) {
 
 		s := storeWithThreeChatRooms()
 
-		err := s.DeleteProxy(roomID)
+		err := s.DeleteProxy(context.Background(), roomID)
 		assert.Nil(t, err)
 		assert.Equal(t, 2, len(s.chatRooms))
 	})
@@ -104,5 +166,25 @@ func storeWithThreeChatRooms() *ChatRoomStore {
 	return &ChatRoomStore{
 		roomCounter: 3,
 		chatRooms:   rooms,
+		clock:       time.Now,
+		policy:      DefaultMessagePolicy,
 	}
 }
+
+func TestMessagesPosted(t *testing.T) {
+	ctx := context.Background()
+	store := NewChatRoomStore(WithDispatcher(newFakeDispatcher()))
+	id, _ := store.AddProxy(ctx, roomName, "alice")
+	room, _ := store.GetProxy(id)
+	room.Join(ctx, "alice", "alice-callback")
+	room.Join(ctx, "bob", "bob-callback")
+
+	assert.Nil(t, room.PostMessage(ctx, "alice", "hello"))
+	assert.Nil(t, room.PostMessage(ctx, "alice", "/topic greetings"))
+	assert.Nil(t, room.SetModes(ctx, "alice", "+m"))
+	assert.NotNil(t, room.PostMessage(ctx, "bob", "held"))
+	assert.Equal(t, int64(1), store.MessagesPosted())
+
+	assert.Nil(t, room.Approve(ctx, "alice", 1))
+	assert.Equal(t, int64(2), store.MessagesPosted())
+}
@@ -0,0 +1,143 @@
+package model
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"irc/server/audit"
+	"irc/server/webhook"
+	"net/url"
+	"time"
+)
+
+// Webhook mirrors a room's messages, actions, topic changes and notices to a URL,
+// in one of the formats of the webhook package.
+type Webhook struct {
+	Id        string         `json:"id"`
+	URL       string         `json:"url"`
+	Format    webhook.Format `json:"format"`
+	Template  string         `json:"template,omitempty"`
+	CreatedBy string         `json:"createdBy"`
+	CreatedAt time.Time      `json:"createdAt"`
+
+	formatter webhook.Formatter
+}
+
+// webhookEvents are the event types sent to webhooks. The rest only matter
+// to members.
+var webhookEvents = map[string]bool{
+	MessageEvent: true,
+	ActionEvent:  true,
+	TopicEvent:   true,
+	NoticeEvent:  true,
+}
+
+func (c *ChatRoom) AddWebhook(ctx context.Context, tag string, rawUrl string, format webhook.Format, template string) (Webhook, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.requireOperator(tag); err != nil {
+		return Webhook{}, err
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, fmt.Errorf("webhook url must be an absolute http(s) url: %q", rawUrl)
+	}
+	if format == "" {
+		format = webhook.JSON
+	}
+	formatter, err := webhook.NewFormatter(format, template)
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	hook := &Webhook{
+		Id:        newUid(),
+		URL:       rawUrl,
+		Format:    format,
+		Template:  template,
+		CreatedBy: tag,
+		CreatedAt: c.clock(),
+		formatter: formatter,
+	}
+	c.webhooks = append(c.webhooks, hook)
+	c.logAction(ctx, audit.Entry{Action: audit.WebhookAdd, Actor: tag, After: hook.state()})
+	return *hook, nil
+}
+
+func (c *ChatRoom) GetWebhooks(tag string) ([]Webhook, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.requireOperator(tag); err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]Webhook, 0, len(c.webhooks))
+	for _, hook := range c.webhooks {
+		webhooks = append(webhooks, *hook)
+	}
+	return webhooks, nil
+}
+
+func (c *ChatRoom) RemoveWebhook(ctx context.Context, tag string, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.requireOperator(tag); err != nil {
+		return err
+	}
+
+	for i, hook := range c.webhooks {
+		if hook.Id == id {
+			c.logAction(ctx, audit.Entry{Action: audit.WebhookRemove, Actor: tag, Before: hook.state()})
+			c.webhooks = append(c.webhooks[:i], c.webhooks[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf(`webhook does not exist: "%s"`, id)
+}
+
+// state is the webhook as kept in the audit log, with only the scheme and
+// host of its URL, whose path may hold a secret of the service it posts to.
+func (hook *Webhook) state() json.RawMessage {
+	u, _ := url.Parse(hook.URL)
+	return audit.State(map[string]string{"id": hook.Id, "kind": "outgoing", "url": u.Scheme + "://" + u.Host, "format": string(hook.Format)})
+}
+
+// webhookRecipient prefixes the IDs of webhooks to tell their dispatcher
+// queues apart from those of members.
+const webhookRecipient = "webhook:"
+
+// notifyWebhooks dispatches body to every webhook, formatted as each expects.
+// Each webhook gets its own dispatcher queue, so a slow one doesn't hold up
+// members. Must be called with c.mu held.
+func (c *ChatRoom) notifyWebhooks(ctx context.Context, body CallbackBody) {
+	if len(c.webhooks) == 0 || !webhookEvents[body.Type] {
+		return
+	}
+
+	event := webhook.Event{
+		Type:    body.Type,
+		Room:    c.Name,
+		Tag:     body.Tag,
+		Message: body.Message,
+		Time:    c.clock(),
+		Part:    body.Part,
+		Parts:   body.Parts,
+	}
+	if body.Topic != nil {
+		event.Tag, event.Topic = body.Topic.SetBy, body.Topic.Text
+	}
+
+	for _, hook := range c.webhooks {
+		bs, err := hook.formatter.Format(event)
+		if err != nil {
+			// Templates can fail on some events but not others; those events
+			// just aren't sent
+			continue
+		}
+		c.dispatcher.Dispatch(ctx, webhookRecipient+hook.Id, hook.URL, bs)
+	}
+}
@@ -0,0 +1,127 @@
+package model
+
+import (
+	"context"
+	"irc/server/delivery"
+	"irc/server/webhook"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const webhookUrl = "https://hooks.example.com/T000/B000"
+
+func TestOperators(t *testing.T) {
+	t.Run("creator is operator", func(t *testing.T) {
+		room := EmptyChatRoom(roomId, roomName)
+		room.Creator = "bob"
+		room.Join(context.Background(), "alice", callbackUrl)
+		room.Join(context.Background(), "bob", callbackUrl)
+
+		assert.False(t, room.IsOperator("alice"))
+		assert.True(t, room.IsOperator("bob"))
+	})
+
+	t.Run("first to join a room without a creator is operator", func(t *testing.T) {
+		room := EmptyChatRoom(roomId, roomName)
+		room.Join(context.Background(), "alice", callbackUrl)
+		room.Join(context.Background(), "bob", callbackUrl)
+
+		assert.True(t, room.IsOperator("alice"))
+		assert.False(t, room.IsOperator("bob"))
+		assert.Equal(t, []MemberInfo{
+			{Tag: "alice", Presence: Online, LastSeen: room.members["alice"].lastSeen, Operator: true},
+			{Tag: "bob", Presence: Online, LastSeen: room.members["bob"].lastSeen},
+		}, room.GetMembers())
+	})
+
+	t.Run("leaving gives up operator", func(t *testing.T) {
+		room := EmptyChatRoom(roomId, roomName)
+		room.Join(context.Background(), "alice", callbackUrl)
+		room.Leave(context.Background(), "alice")
+
+		assert.False(t, room.IsOperator("alice"))
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	newRoom := func() (*ChatRoom, *fakeDispatcher) {
+		dispatcher := newFakeDispatcher()
+		room := newChatRoom(roomId, roomName, time.Now, dispatcher)
+		room.Join(context.Background(), "alice", "alice-callback")
+		room.Join(context.Background(), "bob", "bob-callback")
+		return room, dispatcher
+	}
+
+	t.Run("only operators manage webhooks", func(t *testing.T) {
+		room, _ := newRoom()
+
+		_, err := room.AddWebhook(ctx, "bob", webhookUrl, webhook.Slack, "")
+		assert.NotNil(t, err)
+		_, err = room.AddWebhook(ctx, "carol", webhookUrl, webhook.Slack, "")
+		assert.NotNil(t, err)
+		_, err = room.GetWebhooks("bob")
+		assert.NotNil(t, err)
+
+		hook, err := room.AddWebhook(ctx, "alice", webhookUrl, webhook.Slack, "")
+		assert.Nil(t, err)
+		assert.Equal(t, "alice", hook.CreatedBy)
+
+		err = room.RemoveWebhook(ctx, "bob", hook.Id)
+		assert.NotNil(t, err)
+
+		webhooks, err := room.GetWebhooks("alice")
+		assert.Nil(t, err)
+		assert.Len(t, webhooks, 1)
+	})
+
+	t.Run("invalid webhooks", func(t *testing.T) {
+		room, _ := newRoom()
+
+		_, err := room.AddWebhook(ctx, "alice", "ftp://example.com", webhook.Slack, "")
+		assert.NotNil(t, err)
+		_, err = room.AddWebhook(ctx, "alice", "/relative", webhook.Slack, "")
+		assert.NotNil(t, err)
+		_, err = room.AddWebhook(ctx, "alice", webhookUrl, "xml", "")
+		assert.NotNil(t, err)
+		_, err = room.AddWebhook(ctx, "alice", webhookUrl, webhook.Template, "{{")
+		assert.NotNil(t, err)
+	})
+
+	t.Run("mirrors messages and topics", func(t *testing.T) {
+		room, dispatcher := newRoom()
+		hook, err := room.AddWebhook(ctx, "alice", webhookUrl, webhook.Slack, "")
+		assert.Nil(t, err)
+
+		room.PostMessage(context.Background(), "bob", "hello")
+		room.SetTopic(context.Background(), "alice", "news")
+		room.Heartbeat(context.Background(), "bob", Away)
+
+		assert.Equal(t, []string{
+			`{"text":"*bob*: hello"}`,
+			`{"text":"*alice* set the topic of #test_chat_room to: news"}`,
+		}, dispatcher.receivedBodies("webhook:"+hook.Id))
+	})
+
+	t.Run("removed webhooks get nothing", func(t *testing.T) {
+		room, dispatcher := newRoom()
+		hook, _ := room.AddWebhook(ctx, "alice", webhookUrl, webhook.JSON, "")
+
+		err := room.RemoveWebhook(ctx, "alice", hook.Id)
+		assert.Nil(t, err)
+		err = room.RemoveWebhook(ctx, "alice", hook.Id)
+		assert.NotNil(t, err)
+
+		room.PostMessage(context.Background(), "bob", "hello")
+		assert.Empty(t, dispatcher.receivedBodies("webhook:"+hook.Id))
+	})
+	t.Run("get no dropped notices", func(t *testing.T) {
+		room, _ := newRoom()
+		hook, _ := room.AddWebhook(ctx, "alice", webhookUrl, webhook.Slack, "")
+
+		assert.Nil(t, DroppedNotice(delivery.Recipient{Tag: "webhook:" + hook.Id, CallbackURL: webhookUrl}, 3))
+		assert.JSONEq(t, `{"type":"dropped","dropped":3}`, string(DroppedNotice(delivery.Recipient{Tag: "bob", CallbackURL: "bob-callback"}, 3)))
+	})
+}
@@ -0,0 +1,120 @@
+// Package ratelimit implements token bucket rate limiting keyed by arbitrary
+// strings, like client IPs or member tags.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit allows Burst requests at once, refilled at Rate per second. The zero
+// Limit allows everything.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute allows n requests a minute, all of which may be made at once.
+func PerMinute(n int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: n}
+}
+
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// State is a key's bucket after a call to Allow.
+type State struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request will be allowed. It's
+	// zero if one would be allowed now.
+	RetryAfter time.Duration
+}
+
+// Limiter holds a token bucket for each key.
+type Limiter struct {
+	limit Limit
+	clock func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewLimiter(limit Limit, clock func() time.Time) *Limiter {
+	return &Limiter{limit: limit, clock: clock, buckets: make(map[string]*bucket), lastPrune: clock()}
+}
+
+// Allow takes a token from key's bucket if there's one left.
+func (l *Limiter) Allow(key string) State {
+	if l.limit.Unlimited() {
+		return State{Allowed: true}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock()
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	b.refill(l.limit, now)
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens -= 1
+	}
+
+	state := State{
+		Allowed:   allowed,
+		Limit:     l.limit.Burst,
+		Remaining: int(b.tokens),
+		Reset:     l.timeFor(float64(l.limit.Burst) - b.tokens),
+	}
+	if b.tokens < 1 {
+		state.RetryAfter = l.timeFor(1 - b.tokens)
+	}
+	return state
+}
+
+func (b *bucket) refill(limit Limit, now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.updated = now
+	}
+}
+
+// timeFor returns how long it takes to refill the given number of tokens.
+func (l *Limiter) timeFor(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / l.limit.Rate * float64(time.Second)))
+}
+
+// prune forgets buckets that have had time to fill up again, since they're
+// the same as new ones. It runs at most once per time it takes to fill one.
+func (l *Limiter) prune(now time.Time) {
+	full := l.timeFor(float64(l.limit.Burst))
+	if now.Sub(l.lastPrune) < full {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= full {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
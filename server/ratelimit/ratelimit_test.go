@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestAllow(t *testing.T) {
+	t.Run("allows a burst then refills", func(t *testing.T) {
+		clock := newFakeClock()
+		l := NewLimiter(Limit{Rate: 1, Burst: 3}, clock.Now)
+
+		for i := 2; i >= 0; i-- {
+			state := l.Allow("a")
+			assert.True(t, state.Allowed)
+			assert.Equal(t, 3, state.Limit)
+			assert.Equal(t, i, state.Remaining)
+		}
+
+		state := l.Allow("a")
+		assert.False(t, state.Allowed)
+		assert.Equal(t, time.Second, state.RetryAfter)
+		assert.Equal(t, 3*time.Second, state.Reset)
+
+		clock.Advance(time.Second)
+		assert.True(t, l.Allow("a").Allowed)
+		assert.False(t, l.Allow("a").Allowed)
+	})
+
+	t.Run("keys have separate buckets", func(t *testing.T) {
+		clock := newFakeClock()
+		l := NewLimiter(Limit{Rate: 1, Burst: 1}, clock.Now)
+
+		assert.True(t, l.Allow("a").Allowed)
+		assert.False(t, l.Allow("a").Allowed)
+		assert.True(t, l.Allow("b").Allowed)
+	})
+
+	t.Run("zero limit allows everything", func(t *testing.T) {
+		l := NewLimiter(Limit{}, time.Now)
+
+		for i := 0; i < 100; i++ {
+			assert.True(t, l.Allow("a").Allowed)
+		}
+	})
+
+	t.Run("forgets full buckets", func(t *testing.T) {
+		clock := newFakeClock()
+		l := NewLimiter(PerMinute(60), clock.Now)
+
+		l.Allow("a")
+		clock.Advance(2 * time.Minute)
+		l.Allow("b")
+
+		assert.Len(t, l.buckets, 1)
+		assert.Contains(t, l.buckets, "b")
+	})
+}
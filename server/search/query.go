@@ -0,0 +1,41 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseQuery parses search text into clauses: each word is a clause of its
+// own, and so is each "quoted phrase". Words are matched case-insensitively,
+// and punctuation is ignored, as it is when indexing.
+func ParseQuery(text string) ([][]string, error) {
+	var clauses [][]string
+	for k, part := range strings.Split(text, `"`) {
+		terms := terms(part)
+		if len(terms) == 0 {
+			continue
+		}
+		// Odd parts are between quotes, even if the last quote is missing
+		if k%2 == 1 {
+			clauses = append(clauses, terms)
+			continue
+		}
+		for _, term := range terms {
+			clauses = append(clauses, []string{term})
+		}
+	}
+
+	if len(clauses) == 0 {
+		return nil, fmt.Errorf("search query has no words: %q", text)
+	}
+	return clauses, nil
+}
+
+func terms(text string) []string {
+	tokens := tokenize(text)
+	terms := make([]string, len(tokens))
+	for k, t := range tokens {
+		terms[k] = t.term
+	}
+	return terms
+}
@@ -0,0 +1,351 @@
+// Package search indexes the messages posted in rooms, and finds them again
+// by the words and phrases they contain.
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Document is a message, as indexed.
+type Document struct {
+	Room int
+	Id   int64
+	Tag  string
+	Text string
+	Time time.Time
+}
+
+// Index is an inverted index of documents, kept per room. It's safe for
+// concurrent use.
+type Index struct {
+	mu    sync.RWMutex
+	rooms map[int]*roomIndex
+}
+
+type roomIndex struct {
+	docs map[int64]*entry
+	// postings maps each term to the documents containing it, and the
+	// positions of the term among each document's tokens.
+	postings map[string]map[int64][]int
+}
+
+type entry struct {
+	doc    Document
+	tokens []token
+}
+
+func NewIndex() *Index {
+	return &Index{rooms: make(map[int]*roomIndex)}
+}
+
+// Add indexes doc, replacing the document with the same room and ID if
+// there is one, as when a message is edited.
+func (i *Index) Add(doc Document) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	room, ok := i.rooms[doc.Room]
+	if !ok {
+		room = &roomIndex{docs: make(map[int64]*entry), postings: make(map[string]map[int64][]int)}
+		i.rooms[doc.Room] = room
+	}
+	room.remove(doc.Id)
+
+	e := &entry{doc: doc, tokens: tokenize(doc.Text)}
+	room.docs[doc.Id] = e
+	for pos, t := range e.tokens {
+		docs, ok := room.postings[t.term]
+		if !ok {
+			docs = make(map[int64][]int)
+			room.postings[t.term] = docs
+		}
+		docs[doc.Id] = append(docs[doc.Id], pos)
+	}
+}
+
+// Remove forgets a document, as when a message is deleted.
+func (i *Index) Remove(room int, id int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if r, ok := i.rooms[room]; ok {
+		r.remove(id)
+	}
+}
+
+// RemoveRoom forgets every document of a room.
+func (i *Index) RemoveRoom(room int) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.rooms, room)
+}
+
+func (r *roomIndex) remove(id int64) {
+	e, ok := r.docs[id]
+	if !ok {
+		return
+	}
+	for _, t := range e.tokens {
+		docs := r.postings[t.term]
+		delete(docs, id)
+		if len(docs) == 0 {
+			delete(r.postings, t.term)
+		}
+	}
+	delete(r.docs, id)
+}
+
+// Query selects documents. Every clause must match, and so must the filters
+// that are set.
+type Query struct {
+	// Clauses are the terms a document must contain, as parsed by ParseQuery.
+	// A clause of several terms is a phrase, whose terms must follow each
+	// other in order.
+	Clauses [][]string
+	// Rooms limits the search to the given rooms, if not nil.
+	Rooms []int
+	// From limits the search to messages posted by a member.
+	From string
+	// After and Before limit the search to messages posted in between, if
+	// not zero.
+	After  time.Time
+	Before time.Time
+	Limit  int
+	// Cursor continues a search after the last result of the previous page.
+	Cursor *Cursor
+}
+
+// Cursor marks a result, so the next page starts right after it.
+type Cursor struct {
+	Time int64 `json:"t"`
+	Room int   `json:"r"`
+	Id   int64 `json:"i"`
+}
+
+// Result is a document that matched, with a snippet of its text around the
+// first match.
+type Result struct {
+	Document
+	Snippet string
+	// Highlights are the byte ranges of Snippet, [start, end), that matched.
+	Highlights [][2]int
+}
+
+// Search returns the documents matching q, newest first, and the cursor for
+// the next page if there is one.
+func (i *Index) Search(q Query) ([]Result, *Cursor) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	rooms := q.Rooms
+	if rooms == nil {
+		for id := range i.rooms {
+			rooms = append(rooms, id)
+		}
+	}
+
+	var results []Result
+	for _, id := range rooms {
+		room, ok := i.rooms[id]
+		if !ok {
+			continue
+		}
+		for _, e := range room.candidates(q.Clauses) {
+			if !q.filter(e.doc) {
+				continue
+			}
+			if matched, ok := room.match(e, q.Clauses); ok {
+				results = append(results, result(e, matched))
+			}
+		}
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		return before(cursorOf(results[a].Document), cursorOf(results[b].Document))
+	})
+	if q.Limit <= 0 || len(results) <= q.Limit {
+		return results, nil
+	}
+	results = results[:q.Limit]
+	next := cursorOf(results[len(results)-1].Document)
+	return results, &next
+}
+
+func cursorOf(doc Document) Cursor {
+	return Cursor{Time: doc.Time.UnixNano(), Room: doc.Room, Id: doc.Id}
+}
+
+// before orders results newest first, then by room, then newest first again
+// for messages posted at the same time.
+func before(a Cursor, b Cursor) bool {
+	if a.Time != b.Time {
+		return a.Time > b.Time
+	}
+	if a.Room != b.Room {
+		return a.Room < b.Room
+	}
+	return a.Id > b.Id
+}
+
+func (q Query) filter(doc Document) bool {
+	if q.From != "" && doc.Tag != q.From {
+		return false
+	}
+	if !q.After.IsZero() && !doc.Time.After(q.After) {
+		return false
+	}
+	if !q.Before.IsZero() && !doc.Time.Before(q.Before) {
+		return false
+	}
+	return q.Cursor == nil || before(*q.Cursor, cursorOf(doc))
+}
+
+// candidates returns the documents containing every term of clauses,
+// starting from the rarest term.
+func (r *roomIndex) candidates(clauses [][]string) []*entry {
+	var rarest map[int64][]int
+	for _, clause := range clauses {
+		for _, term := range clause {
+			docs := r.postings[term]
+			if len(docs) == 0 {
+				return nil
+			}
+			if rarest == nil || len(docs) < len(rarest) {
+				rarest = docs
+			}
+		}
+	}
+
+	entries := make([]*entry, 0, len(rarest))
+	for id := range rarest {
+		entries = append(entries, r.docs[id])
+	}
+	return entries
+}
+
+// match checks that e contains every clause, returning the positions of the
+// tokens that matched.
+func (r *roomIndex) match(e *entry, clauses [][]string) ([]int, bool) {
+	var matched []int
+	for _, clause := range clauses {
+		found := false
+		for _, start := range r.postings[clause[0]][e.doc.Id] {
+			if start+len(clause) > len(e.tokens) {
+				break
+			}
+			phrase := true
+			for k, term := range clause[1:] {
+				if e.tokens[start+k+1].term != term {
+					phrase = false
+					break
+				}
+			}
+			if phrase {
+				found = true
+				for k := range clause {
+					matched = append(matched, start+k)
+				}
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+
+	sort.Ints(matched)
+	return dedupe(matched), true
+}
+
+func dedupe(positions []int) []int {
+	out := positions[:0]
+	for k, pos := range positions {
+		if k == 0 || pos != positions[k-1] {
+			out = append(out, pos)
+		}
+	}
+	return out
+}
+
+// snippetLength is the most bytes of text a snippet shows, ellipses aside.
+const snippetLength = 160
+
+const ellipsis = "…"
+
+// result cuts the snippet around the first matched token.
+func result(e *entry, matched []int) Result {
+	text := e.doc.Text
+	start, end := 0, len(text)
+	if len(text) > snippetLength {
+		start = e.tokens[matched[0]].start - snippetLength/4
+		if start < 0 {
+			start = 0
+		}
+		end = start + snippetLength
+		if end > len(text) {
+			end = len(text)
+			start = end - snippetLength
+		}
+		for start > 0 && !utf8.RuneStart(text[start]) {
+			start += 1
+		}
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end -= 1
+		}
+	}
+
+	prefix, suffix := "", ""
+	if start > 0 {
+		prefix = ellipsis
+	}
+	if end < len(text) {
+		suffix = ellipsis
+	}
+
+	r := Result{Document: e.doc, Snippet: prefix + text[start:end] + suffix}
+	for _, pos := range matched {
+		t := e.tokens[pos]
+		if t.start >= start && t.end <= end {
+			offset := len(prefix) - start
+			r.Highlights = append(r.Highlights, [2]int{t.start + offset, t.end + offset})
+		}
+	}
+	return r
+}
+
+// token is a word of a text, lowercased, and where it is in the text.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into words: runs of letters, digits and marks.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		if isWordChar(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+func isWordChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
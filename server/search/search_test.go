@@ -0,0 +1,120 @@
+package search
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var at = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func newTestIndex() *Index {
+	index := NewIndex()
+	index.Add(Document{Room: 0, Id: 1, Tag: "alice", Text: "The build is broken again", Time: at})
+	index.Add(Document{Room: 0, Id: 2, Tag: "bob", Text: "Who broke the build?", Time: at.Add(time.Minute)})
+	index.Add(Document{Room: 1, Id: 1, Tag: "alice", Text: "build broken, reverting", Time: at.Add(2 * time.Minute)})
+	return index
+}
+
+func ids(results []Result) [][2]int64 {
+	out := [][2]int64{}
+	for _, r := range results {
+		out = append(out, [2]int64{int64(r.Room), r.Id})
+	}
+	return out
+}
+
+func search(t *testing.T, index *Index, q Query, text string) []Result {
+	t.Helper()
+	clauses, err := ParseQuery(text)
+	assert.Nil(t, err)
+	q.Clauses = clauses
+	results, _ := index.Search(q)
+	return results
+}
+
+func TestParseQuery(t *testing.T) {
+	clauses, err := ParseQuery(`Build "is broken" again!`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"build"}, {"is", "broken"}, {"again"}}, clauses)
+
+	clauses, err = ParseQuery(`"unterminated phrase`)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{{"unterminated", "phrase"}}, clauses)
+
+	_, err = ParseQuery(` "" ?! `)
+	assert.NotNil(t, err)
+}
+
+func TestSearch(t *testing.T) {
+	t.Run("words match in any order, newest first", func(t *testing.T) {
+		index := newTestIndex()
+
+		assert.Equal(t, [][2]int64{{1, 1}, {0, 2}, {0, 1}}, ids(search(t, index, Query{}, "BUILD")))
+		assert.Equal(t, [][2]int64{{1, 1}, {0, 1}}, ids(search(t, index, Query{}, "broken build")))
+		assert.Empty(t, search(t, index, Query{}, "build deploy"))
+	})
+
+	t.Run("phrases", func(t *testing.T) {
+		index := newTestIndex()
+
+		assert.Equal(t, [][2]int64{{1, 1}}, ids(search(t, index, Query{}, `"build broken"`)))
+		assert.Equal(t, [][2]int64{{0, 1}}, ids(search(t, index, Query{}, `"is broken"`)))
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		index := newTestIndex()
+
+		assert.Equal(t, [][2]int64{{0, 2}, {0, 1}}, ids(search(t, index, Query{Rooms: []int{0}}, "build")))
+		assert.Equal(t, [][2]int64{{1, 1}, {0, 1}}, ids(search(t, index, Query{From: "alice"}, "build")))
+		assert.Equal(t, [][2]int64{{0, 2}}, ids(search(t, index, Query{After: at, Before: at.Add(2 * time.Minute)}, "build")))
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		index := newTestIndex()
+		clauses, _ := ParseQuery("build")
+
+		results, next := index.Search(Query{Clauses: clauses, Limit: 2})
+		assert.Equal(t, [][2]int64{{1, 1}, {0, 2}}, ids(results))
+		assert.NotNil(t, next)
+
+		results, next = index.Search(Query{Clauses: clauses, Limit: 2, Cursor: next})
+		assert.Equal(t, [][2]int64{{0, 1}}, ids(results))
+		assert.Nil(t, next)
+	})
+
+	t.Run("edits and deletions", func(t *testing.T) {
+		index := newTestIndex()
+
+		index.Add(Document{Room: 0, Id: 1, Tag: "alice", Text: "The build is fixed", Time: at})
+		assert.Equal(t, [][2]int64{{1, 1}}, ids(search(t, index, Query{}, "broken")))
+		assert.Equal(t, [][2]int64{{0, 1}}, ids(search(t, index, Query{}, "fixed")))
+
+		index.Remove(0, 2)
+		assert.Equal(t, [][2]int64{{1, 1}, {0, 1}}, ids(search(t, index, Query{}, "build")))
+
+		index.RemoveRoom(1)
+		assert.Equal(t, [][2]int64{{0, 1}}, ids(search(t, index, Query{}, "build")))
+	})
+}
+
+func TestSnippets(t *testing.T) {
+	index := NewIndex()
+	index.Add(Document{Room: 0, Id: 1, Text: "Who broke the build?", Time: at})
+	long := strings.Repeat("filler ", 40) + "the needle is here " + strings.Repeat("filler ", 40)
+	index.Add(Document{Room: 0, Id: 2, Text: long, Time: at})
+
+	results := search(t, index, Query{}, "broke build")
+	assert.Equal(t, "Who broke the build?", results[0].Snippet)
+	assert.Equal(t, [][2]int{{4, 9}, {14, 19}}, results[0].Highlights)
+
+	results = search(t, index, Query{}, "needle")
+	snippet := results[0].Snippet
+	assert.True(t, strings.HasPrefix(snippet, "…"))
+	assert.True(t, strings.HasSuffix(snippet, "…"))
+	assert.Len(t, results[0].Highlights, 1)
+	h := results[0].Highlights[0]
+	assert.Equal(t, "needle", snippet[h[0]:h[1]])
+}
@@ -0,0 +1,283 @@
+// Package spam tells floods and spam apart from ordinary messages, going by
+// what was recently posted in a room.
+package spam
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Rule names a way of telling spam apart.
+type Rule string
+
+const (
+	// Duplicate catches a member posting the same message over and over.
+	// Messages are compared ignoring case, punctuation and spacing, so
+	// near-identical ones count too.
+	Duplicate Rule = "duplicate"
+	// Burst catches floods of messages in a room, from anyone.
+	Burst Rule = "burst"
+	// Mentions catches messages mentioning many members of the room.
+	Mentions Rule = "mentions"
+	// Links catches messages with many links, and links posted by members
+	// who only just joined.
+	Links Rule = "links"
+)
+
+var rules = []Rule{Duplicate, Burst, Mentions, Links}
+
+// Action is what's done with a message a rule caught.
+type Action string
+
+const (
+	// Drop rejects the message.
+	Drop Action = "drop"
+	// Warn posts the message, but warns the member who posted it.
+	Warn Action = "warn"
+	// Mute rejects the message, and every other message of the member who
+	// posted it for Config.MuteFor.
+	Mute Action = "mute"
+	// Kick rejects the message, and kicks the member who posted it out of
+	// the room.
+	Kick Action = "kick"
+)
+
+// Config sets the limits of each rule, which a zero limit disables, and the
+// action taken when they're exceeded.
+type Config struct {
+	// A member may post the same message MaxDuplicates times within
+	// DuplicateWindow.
+	MaxDuplicates   int
+	DuplicateWindow time.Duration
+	// A room may have MaxBurst messages posted within BurstWindow.
+	MaxBurst    int
+	BurstWindow time.Duration
+	// A message may mention MaxMentions members of the room, by tag.
+	MaxMentions int
+	// A message may have MaxLinks links, and members may only post links
+	// once they've been in the room for NewMemberLinkDelay.
+	MaxLinks           int
+	NewMemberLinkDelay time.Duration
+
+	// Actions sets the action of each rule. Rules without one drop.
+	Actions map[Rule]Action
+	// MuteFor is how long the Mute action mutes members.
+	MuteFor time.Duration
+}
+
+// DefaultConfig catches the floods and spam no person would post.
+var DefaultConfig = Config{
+	MaxDuplicates:      3,
+	DuplicateWindow:    time.Minute,
+	MaxBurst:           30,
+	BurstWindow:        10 * time.Second,
+	MaxMentions:        5,
+	MaxLinks:           3,
+	NewMemberLinkDelay: 0,
+	Actions:            map[Rule]Action{Duplicate: Mute, Burst: Drop, Mentions: Warn, Links: Drop},
+	MuteFor:            5 * time.Minute,
+}
+
+// Enabled reports whether any rule is enabled.
+func (c Config) Enabled() bool {
+	return c.MaxDuplicates > 0 || c.MaxBurst > 0 || c.MaxMentions > 0 || c.MaxLinks > 0 || c.NewMemberLinkDelay > 0
+}
+
+func (c Config) action(rule Rule) Action {
+	if action, ok := c.Actions[rule]; ok {
+		return action
+	}
+	return Drop
+}
+
+// ParseActions parses a comma separated list of rule=action pairs, e.g.
+// "duplicate=mute,links=kick", into Config.Actions.
+func ParseActions(text string) (map[Rule]Action, error) {
+	actions := make(map[Rule]Action)
+	for _, pair := range strings.Split(text, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("spam actions must be rule=action pairs: %q", pair)
+		}
+		rule, action := Rule(strings.TrimSpace(parts[0])), Action(strings.TrimSpace(parts[1]))
+		if !knownRule(rule) {
+			return nil, fmt.Errorf("unknown spam rule %q", rule)
+		}
+		switch action {
+		case Drop, Warn, Mute, Kick:
+		default:
+			return nil, fmt.Errorf("unknown spam action %q", action)
+		}
+		actions[rule] = action
+	}
+	return actions, nil
+}
+
+func knownRule(rule Rule) bool {
+	for _, known := range rules {
+		if rule == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Post is a message about to be posted in a room.
+type Post struct {
+	Tag  string
+	Text string
+	Time time.Time
+	// JoinedAt is when the member posting joined the room.
+	JoinedAt time.Time
+	// Members are the tags of the room's members, which the message might
+	// mention.
+	Members []string
+}
+
+// Decision is the action taken on a message a rule caught, and why.
+type Decision struct {
+	Time   time.Time `json:"time"`
+	Tag    string    `json:"tag"`
+	Text   string    `json:"text"`
+	Rule   Rule      `json:"rule"`
+	Action Action    `json:"action"`
+	Reason string    `json:"reason"`
+	// MutedUntil is when the member is muted until, for the Mute action.
+	MutedUntil *time.Time `json:"mutedUntil,omitempty"`
+}
+
+// recent is a message recently checked.
+type recent struct {
+	tag  string
+	key  string
+	time time.Time
+}
+
+// Filter checks the messages posted in a room against Config. It isn't safe
+// for concurrent use; rooms call it with their lock held.
+type Filter struct {
+	config Config
+	recent []recent
+}
+
+func NewFilter(config Config) *Filter {
+	return &Filter{config: config}
+}
+
+// Check checks a message, returning the decision of the first rule it
+// breaks, or nil if it breaks none. Messages are counted towards the limits
+// on duplicates and bursts whether or not they break a rule, so members who
+// keep flooding stay caught.
+func (f *Filter) Check(post Post) *Decision {
+	f.forget(post.Time)
+	key := normalize(post.Text)
+	f.recent = append(f.recent, recent{post.Tag, key, post.Time})
+
+	decide := func(rule Rule, format string, args ...interface{}) *Decision {
+		d := &Decision{
+			Time:   post.Time,
+			Tag:    post.Tag,
+			Text:   post.Text,
+			Rule:   rule,
+			Action: f.config.action(rule),
+			Reason: fmt.Sprintf(format, args...),
+		}
+		if d.Action == Mute {
+			until := post.Time.Add(f.config.MuteFor)
+			d.MutedUntil = &until
+		}
+		return d
+	}
+
+	c := f.config
+	if c.MaxDuplicates > 0 && key != "" {
+		if n := f.count(post.Tag, key, post.Time.Add(-c.DuplicateWindow)); n > c.MaxDuplicates {
+			return decide(Duplicate, "posted the same message %d times in %s", n, c.DuplicateWindow)
+		}
+	}
+	if c.MaxBurst > 0 {
+		if n := f.count("", "", post.Time.Add(-c.BurstWindow)); n > c.MaxBurst {
+			return decide(Burst, "%d messages posted in the room in %s", n, c.BurstWindow)
+		}
+	}
+	if c.MaxMentions > 0 {
+		if n := mentions(post.Text, post.Members, post.Tag); n > c.MaxMentions {
+			return decide(Mentions, "mentioned %d members", n)
+		}
+	}
+	if c.MaxLinks > 0 || c.NewMemberLinkDelay > 0 {
+		n := len(linkPattern.FindAllStringIndex(post.Text, -1))
+		switch {
+		case c.MaxLinks > 0 && n > c.MaxLinks:
+			return decide(Links, "posted %d links", n)
+		case n > 0 && post.Time.Sub(post.JoinedAt) < c.NewMemberLinkDelay:
+			return decide(Links, "posted links within %s of joining", c.NewMemberLinkDelay)
+		}
+	}
+	return nil
+}
+
+// count counts the recent messages since the given time, from the member
+// with the given tag and with the given key, or from anyone with any key if
+// they're empty.
+func (f *Filter) count(tag string, key string, since time.Time) int {
+	n := 0
+	for _, r := range f.recent {
+		if r.time.Before(since) || (tag != "" && r.tag != tag) || (key != "" && r.key != key) {
+			continue
+		}
+		n += 1
+	}
+	return n
+}
+
+// forget forgets the messages older than any window.
+func (f *Filter) forget(now time.Time) {
+	window := f.config.DuplicateWindow
+	if f.config.BurstWindow > window {
+		window = f.config.BurstWindow
+	}
+	i := 0
+	for i < len(f.recent) && f.recent[i].time.Before(now.Add(-window)) {
+		i += 1
+	}
+	f.recent = f.recent[i:]
+}
+
+// normalize reduces a message to its lowercased letters and digits, so that
+// messages differing only in case, punctuation or spacing are the same.
+func normalize(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, text)
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// mentions counts the members other than from that text mentions by tag,
+// with or without a leading '@'.
+func mentions(text string, members []string, from string) int {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(",:;!?()@", r)
+	}) {
+		words[strings.ToLower(word)] = true
+	}
+
+	n := 0
+	for _, member := range members {
+		if member != from && words[strings.ToLower(member)] {
+			n += 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,81 @@
+package spam
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var at = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func ruleOf(d *Decision) Rule {
+	if d == nil {
+		return ""
+	}
+	return d.Rule
+}
+
+func TestFilter(t *testing.T) {
+	t.Run("duplicates", func(t *testing.T) {
+		f := NewFilter(Config{MaxDuplicates: 2, DuplicateWindow: time.Minute})
+		assert.Nil(t, f.Check(Post{Tag: "alice", Text: "buy now", Time: at}))
+		assert.Nil(t, f.Check(Post{Tag: "alice", Text: "Buy  now!", Time: at}))
+		assert.Nil(t, f.Check(Post{Tag: "bob", Text: "buy now", Time: at}), "duplicates are counted per member")
+		d := f.Check(Post{Tag: "alice", Text: "BUY NOW", Time: at.Add(time.Second)})
+		assert.Equal(t, Duplicate, ruleOf(d))
+		assert.Equal(t, Drop, d.Action, "rules without an action drop")
+		assert.Equal(t, "posted the same message 3 times in 1m0s", d.Reason)
+
+		assert.Nil(t, f.Check(Post{Tag: "alice", Text: "buy now", Time: at.Add(2 * time.Minute)}), "older duplicates are forgotten")
+	})
+
+	t.Run("bursts", func(t *testing.T) {
+		f := NewFilter(Config{MaxBurst: 2, BurstWindow: 10 * time.Second, Actions: map[Rule]Action{Burst: Warn}})
+		assert.Nil(t, f.Check(Post{Tag: "alice", Text: "one", Time: at}))
+		assert.Nil(t, f.Check(Post{Tag: "bob", Text: "two", Time: at.Add(time.Second)}))
+		d := f.Check(Post{Tag: "carol", Text: "three", Time: at.Add(2 * time.Second)})
+		assert.Equal(t, Burst, ruleOf(d))
+		assert.Equal(t, Warn, d.Action)
+		assert.Nil(t, f.Check(Post{Tag: "carol", Text: "four", Time: at.Add(time.Minute)}))
+	})
+
+	t.Run("mentions", func(t *testing.T) {
+		f := NewFilter(Config{MaxMentions: 2})
+		members := []string{"alice", "bob", "carol", "dave"}
+		assert.Nil(t, f.Check(Post{Tag: "alice", Text: "bob, carol: hi alice", Time: at, Members: members}), "members mentioning themselves don't count")
+		d := f.Check(Post{Tag: "alice", Text: "@Bob @carol @dave look", Time: at, Members: members})
+		assert.Equal(t, Mentions, ruleOf(d))
+		assert.Equal(t, "mentioned 3 members", d.Reason)
+	})
+
+	t.Run("links", func(t *testing.T) {
+		f := NewFilter(Config{MaxLinks: 1, NewMemberLinkDelay: time.Minute, Actions: map[Rule]Action{Links: Mute}, MuteFor: 5 * time.Minute})
+		assert.Nil(t, f.Check(Post{Tag: "alice", Text: "see https://example.com", Time: at, JoinedAt: at.Add(-time.Hour)}))
+		d := f.Check(Post{Tag: "alice", Text: "http://a.example www.b.example", Time: at, JoinedAt: at.Add(-time.Hour)})
+		assert.Equal(t, Links, ruleOf(d))
+		assert.Equal(t, "posted 2 links", d.Reason)
+		assert.Equal(t, at.Add(5*time.Minute), *d.MutedUntil)
+
+		d = f.Check(Post{Tag: "bob", Text: "see https://example.com", Time: at, JoinedAt: at.Add(-time.Second)})
+		assert.Equal(t, Links, ruleOf(d), "new members may not post links")
+		assert.Nil(t, f.Check(Post{Tag: "bob", Text: "no links here", Time: at, JoinedAt: at.Add(-time.Second)}))
+	})
+}
+
+func TestParseActions(t *testing.T) {
+	actions, err := ParseActions("duplicate=mute, links=kick")
+	assert.Nil(t, err)
+	assert.Equal(t, map[Rule]Action{Duplicate: Mute, Links: Kick}, actions)
+
+	actions, err = ParseActions("")
+	assert.Nil(t, err)
+	assert.Empty(t, actions)
+
+	_, err = ParseActions("floods=drop")
+	assert.EqualError(t, err, `unknown spam rule "floods"`)
+	_, err = ParseActions("burst=ban")
+	assert.EqualError(t, err, `unknown spam action "ban"`)
+	_, err = ParseActions("burst")
+	assert.EqualError(t, err, `spam actions must be rule=action pairs: "burst"`)
+}
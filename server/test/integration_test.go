@@ -2,15 +2,30 @@ package test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"irc/server/api"
+	"irc/server/audit"
+	"irc/server/bot"
+	"irc/server/delivery"
+	"irc/server/health"
+	"irc/server/hook"
+	"irc/server/metrics"
 	"irc/server/model"
+	"irc/server/ratelimit"
+	"irc/server/requestid"
+	"irc/server/spam"
+	"irc/server/webhook"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	_ "github.com/stretchr/testify/require"
@@ -28,8 +43,16 @@ func createRoomRequest(roomName string) *http.Request {
 	return httptest.NewRequest("POST", "/api/rooms", bytes.NewReader(bs))
 }
 
+func createRoomRequestWithCreator(roomName string, creator string) *http.Request {
+	bs, err := json.Marshal(api.CreateChatRoomArgs{Name: roomName, Creator: creator})
+	if err != nil {
+		log.Panicln(err)
+	}
+	return httptest.NewRequest("POST", "/api/rooms", bytes.NewReader(bs))
+}
+
 func joinRoomRequest(roomId int, tag string, callbackUrl string) *http.Request {
-	bs, err := json.Marshal(api.JoinChatRoomArgs{tag, callbackUrl})
+	bs, err := json.Marshal(api.JoinChatRoomArgs{Tag: tag, CallbackURL: callbackUrl})
 	if err != nil {
 		log.Panicln(err)
 	}
@@ -41,202 +64,458 @@ func leaveRoomRequest(roomId int, tag string) *http.Request {
 }
 
 func postMessageRequest(roomId int, tag string, message string) *http.Request {
-	bs, err := json.Marshal(api.PostMessageArgs{message})
+	bs, err := json.Marshal(api.PostMessageArgs{Message: message})
 	if err != nil {
 		log.Panicln(err)
 	}
 	return httptest.NewRequest("POST", fmt.Sprintf("/api/rooms/%d/members/%s/messages", roomId, tag), bytes.NewReader(bs))
 }
 
+func listMembersRequest(roomId int) *http.Request {
+	return httptest.NewRequest("GET", fmt.Sprintf("/api/rooms/%d/members", roomId), nil)
+}
+
+func heartbeatRequest(roomId int, tag string, status model.Presence) *http.Request {
+	bs, err := json.Marshal(api.HeartbeatArgs{Status: status})
+	if err != nil {
+		log.Panicln(err)
+	}
+	return httptest.NewRequest("POST", fmt.Sprintf("/api/rooms/%d/members/%s/heartbeat", roomId, tag), bytes.NewReader(bs))
+}
+
+func getRoomRequest(roomId int) *http.Request {
+	return httptest.NewRequest("GET", fmt.Sprintf(`/api/rooms/%d`, roomId), nil)
+}
+
+func updateRoomRequest(roomId int, args api.UpdateChatRoomArgs) *http.Request {
+	bs, err := json.Marshal(args)
+	if err != nil {
+		log.Panicln(err)
+	}
+	return httptest.NewRequest("PATCH", fmt.Sprintf(`/api/rooms/%d`, roomId), bytes.NewReader(bs))
+}
+
 func deleteRoomRequest(roomId int) *http.Request {
 	return httptest.NewRequest("DELETE", fmt.Sprintf(`/api/rooms/%d`, roomId), nil)
 }
 
 func TestListChatRoomsHandler(t *testing.T) {
+	t.Parallel()
+
 	t.Run("new server has no chat rooms", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
-		rr := invokeHandler(api.ChatRoomsHandler, listRoomsRequest())
+		rr := invokeHandler(server, listRoomsRequest())
 
 		expectStatus(t, rr, 200)
 		expectBody(t, rr, "[]")
 	})
 
 	t.Run("server with several rooms", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
+
+		rr := invokeHandler(server, createRoomRequest("room0"))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, createRoomRequest("room1"))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, createRoomRequest("room2"))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, listRoomsRequest())
 
-		rr := invokeHandler(api.ChatRoomsHandler, createRoomRequest("room0"))
 		expectStatus(t, rr, 200)
+		rooms := decodeRoomSummaries(t, rr)
+		assert.Equal(t, 3, len(rooms))
+		for i, room := range rooms {
+			assert.Equal(t, i, room.Id)
+			assert.Equal(t, fmt.Sprintf("room%d", i), room.Name)
+			assert.NotEmpty(t, room.Uid)
+		}
+		assert.NotEqual(t, rooms[0].Uid, rooms[1].Uid)
+	})
+}
+
+func TestListChatRoomsQuery(t *testing.T) {
+	t.Parallel()
 
-		rr = invokeHandler(api.ChatRoomsHandler, createRoomRequest("room1"))
+	setup := func() *api.Server {
+		server := newTestServer()
+		for _, name := range []string{"alpha", "beta", "alphabet", "gamma", "delta"} {
+			rr := invokeHandler(server, createRoomRequest(name))
+			expectStatus(t, rr, 200)
+		}
+		// gamma (2) gets two members, alphabet (2) gets one and is moderated
+		for _, tag := range []string{"user1", "user2"} {
+			rr := invokeHandler(server, joinRoomRequest(3, tag, "localhost:6000"))
+			expectStatus(t, rr, 200)
+		}
+		rr := invokeHandler(server, joinRoomRequest(2, "user1", "localhost:6000"))
 		expectStatus(t, rr, 200)
+		modes := "+m"
+		rr = invokeHandler(server, updateRoomRequest(2, api.UpdateChatRoomArgs{Tag: "user1", Modes: &modes}))
+		expectStatus(t, rr, 200)
+		return server
+	}
 
-		rr = invokeHandler(api.ChatRoomsHandler, createRoomRequest("room2"))
+	list := func(server *api.Server, query string) ([]string, *httptest.ResponseRecorder) {
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/api/rooms?"+query, nil))
 		expectStatus(t, rr, 200)
+		names := []string{}
+		for _, room := range decodeRoomSummaries(t, rr) {
+			names = append(names, room.Name)
+		}
+		return names, rr
+	}
+
+	t.Run("search and filter", func(t *testing.T) {
+		server := setup()
+
+		names, _ := list(server, "q=PHA")
+		assert.Equal(t, []string{"alpha", "alphabet"}, names)
+
+		names, _ = list(server, "prefix=%23alpha")
+		assert.Equal(t, []string{"alpha", "alphabet"}, names)
+
+		names, _ = list(server, "q=ta")
+		assert.Equal(t, []string{"beta", "delta"}, names)
+
+		names, _ = list(server, "minMembers=1")
+		assert.Equal(t, []string{"alphabet", "gamma"}, names)
+
+		names, _ = list(server, "maxMembers=0")
+		assert.Equal(t, []string{"alpha", "beta", "delta"}, names)
+
+		names, _ = list(server, "mode=m")
+		assert.Equal(t, []string{"alphabet"}, names)
+
+		names, _ = list(server, "tag=user2")
+		assert.Equal(t, []string{"gamma"}, names)
+	})
+
+	t.Run("sort", func(t *testing.T) {
+		server := setup()
+
+		names, _ := list(server, "sort=name")
+		assert.Equal(t, []string{"alpha", "alphabet", "beta", "delta", "gamma"}, names)
+
+		names, _ = list(server, "sort=-name")
+		assert.Equal(t, []string{"gamma", "delta", "beta", "alphabet", "alpha"}, names)
+
+		names, _ = list(server, "sort=-size")
+		assert.Equal(t, []string{"gamma", "alphabet", "delta", "beta", "alpha"}, names)
+
+		names, _ = list(server, "sort=created")
+		assert.Equal(t, []string{"alpha", "beta", "alphabet", "gamma", "delta"}, names)
+
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/api/rooms?sort=members", nil))
+		expectStatus(t, rr, 400)
+	})
+
+	t.Run("paginate", func(t *testing.T) {
+		server := setup()
+
+		names, rr := list(server, "sort=name&limit=2")
+		assert.Equal(t, []string{"alpha", "alphabet"}, names)
+
+		var pages [][]string
+		for link := rr.Header().Get("Link"); link != ""; link = rr.Header().Get("Link") {
+			assert.True(t, strings.HasSuffix(link, `>; rel="next"`))
+			next := strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+			rr = invokeHandler(server, httptest.NewRequest("GET", next, nil))
+			expectStatus(t, rr, 200)
+			var page []string
+			for _, room := range decodeRoomSummaries(t, rr) {
+				page = append(page, room.Name)
+			}
+			pages = append(pages, page)
+		}
+		assert.Equal(t, [][]string{{"beta", "delta"}, {"gamma"}}, pages)
+	})
+
+	t.Run("rejects invalid cursor", func(t *testing.T) {
+		server := setup()
 
-		rr = invokeHandler(api.ChatRoomsHandler, listRoomsRequest())
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/api/rooms?cursor=garbage", nil))
+		expectStatus(t, rr, 400)
+	})
+
+	t.Run("etag", func(t *testing.T) {
+		server := setup()
+
+		_, rr := list(server, "")
+		etag := rr.Header().Get("ETag")
+		assert.NotEmpty(t, etag)
+
+		req := listRoomsRequest()
+		req.Header.Set("If-None-Match", etag)
+		rr = invokeHandler(server, req)
+		expectStatus(t, rr, 304)
+		expectBody(t, rr, "")
+
+		rr = invokeHandler(server, createRoomRequest("epsilon"))
+		expectStatus(t, rr, 200)
 
+		req = listRoomsRequest()
+		req.Header.Set("If-None-Match", etag)
+		rr = invokeHandler(server, req)
 		expectStatus(t, rr, 200)
-		expectBody(t, rr, `[{"id":0,"name":"room0"},{"id":1,"name":"room1"},{"id":2,"name":"room2"}]`)
+		assert.NotEqual(t, etag, rr.Header().Get("ETag"))
 	})
 }
 
 func TestCreateChatRoomHandler(t *testing.T) {
+	t.Parallel()
+
 	roomName := "room0"
 
 	t.Run("create new room", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
-		rr := invokeHandler(api.ChatRoomsHandler, createRoomRequest(roomName))
+		rr := invokeHandler(server, createRoomRequest(roomName))
 
 		expectStatus(t, rr, 200)
-		expectBody(t, rr, `{"roomId":0}`)
+		var body api.CreateChatRoomResponseBody
+		err := json.NewDecoder(rr.Body).Decode(&body)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, body.RoomId)
+		assert.NotEmpty(t, body.RoomUid)
 	})
 
 	t.Run("enforces unique room names", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
-		rr := invokeHandler(api.ChatRoomsHandler, createRoomRequest(roomName))
+		rr := invokeHandler(server, createRoomRequest(roomName))
 		expectStatus(t, rr, 200)
 
-		rr = invokeHandler(api.ChatRoomsHandler, createRoomRequest(roomName))
+		rr = invokeHandler(server, createRoomRequest(roomName))
 
 		expectStatus(t, rr, 400)
 		expectBody(t, rr, fmt.Sprintf(`cannot create duplicate chat room: "%s"`, roomName))
 	})
+
+	t.Run("room names are case-insensitive", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, createRoomRequest("#ROOM0"))
+
+		expectStatus(t, rr, 400)
+		expectBody(t, rr, `cannot create duplicate chat room: "#ROOM0"`)
+	})
+
+	t.Run("rejects invalid room names", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, createRoomRequest("room 0"))
+
+		expectStatus(t, rr, 400)
+		expectBody(t, rr, `chat room name may only contain letters, digits, '-', '_' and '.': "room 0"`)
+	})
 }
 
 func TestJoinChatRoomHandler(t *testing.T) {
+	t.Parallel()
+
 	roomId := 0
 	roomName := "room0"
 	userTag := "new_user"
 	callbackUrl := "localhost:6000"
 
 	t.Run("join non-existent room", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
-		rr := invokeHandler(api.MembersHandler, joinRoomRequest(roomId, userTag, callbackUrl))
+		rr := invokeHandler(server, joinRoomRequest(roomId, userTag, callbackUrl))
 
 		expectStatus(t, rr, 400)
 		expectBody(t, rr, fmt.Sprintf(`chat room does not exist: "%d"`, roomId))
 	})
 
 	t.Run("join empty room", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
-		rr := invokeHandler(api.ChatRoomsHandler, createRoomRequest(roomName))
+		rr := invokeHandler(server, createRoomRequest(roomName))
 		expectStatus(t, rr, 200)
 
-		rr = invokeHandler(api.MembersHandler, joinRoomRequest(roomId, userTag, callbackUrl))
+		rr = invokeHandler(server, joinRoomRequest(roomId, userTag, callbackUrl))
 
 		expectStatus(t, rr, 200)
 		expectBody(t, rr, "")
 	})
 
 	t.Run("join twice", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
-		rr := invokeHandler(api.ChatRoomsHandler, createRoomRequest(roomName))
+		rr := invokeHandler(server, createRoomRequest(roomName))
 		expectStatus(t, rr, 200)
 
-		rr = invokeHandler(api.MembersHandler, joinRoomRequest(roomId, userTag, callbackUrl))
+		rr = invokeHandler(server, joinRoomRequest(roomId, userTag, callbackUrl))
 		expectStatus(t, rr, 200)
 
-		rr = invokeHandler(api.MembersHandler, joinRoomRequest(roomId, userTag, callbackUrl))
+		rr = invokeHandler(server, joinRoomRequest(roomId, userTag, callbackUrl))
 		expectStatus(t, rr, 400)
 		expectBody(t, rr, fmt.Sprintf(`"%s" already joined chat room {Id:%d Name:%s}`, userTag, roomId, roomName))
 	})
 }
 
 func TestLeaveChatRoomHandler(t *testing.T) {
+	t.Parallel()
+
 	roomId := 0
 	roomName := "room0"
 	userTag := "new_user"
 	callbackUrl := "localhost:6000"
 
 	t.Run("leave non-existent room", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
-		rr := invokeHandler(api.MemberHandler, leaveRoomRequest(roomId, userTag))
+		rr := invokeHandler(server, leaveRoomRequest(roomId, userTag))
 
 		expectStatus(t, rr, 400)
 		expectBody(t, rr, fmt.Sprintf(`chat room does not exist: "%d"`, roomId))
 	})
 
 	t.Run("leave existing room, but haven't joined", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
-		rr := invokeHandler(api.ChatRoomsHandler, createRoomRequest(roomName))
+		rr := invokeHandler(server, createRoomRequest(roomName))
 		expectStatus(t, rr, 200)
 
 		leaveRoomId := 3
-		rr = invokeHandler(api.MemberHandler, leaveRoomRequest(leaveRoomId, userTag))
+		rr = invokeHandler(server, leaveRoomRequest(leaveRoomId, userTag))
 
 		expectStatus(t, rr, 400)
 		expectBody(t, rr, fmt.Sprintf(`chat room does not exist: "%d"`, leaveRoomId))
 	})
 
 	t.Run("leave joined room", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
-		rr := invokeHandler(api.ChatRoomsHandler, createRoomRequest(roomName))
+		rr := invokeHandler(server, createRoomRequest(roomName))
 		expectStatus(t, rr, 200)
 
-		rr = invokeHandler(api.MembersHandler, joinRoomRequest(roomId, userTag, callbackUrl))
+		rr = invokeHandler(server, joinRoomRequest(roomId, userTag, callbackUrl))
 		expectStatus(t, rr, 200)
 
-		rr = invokeHandler(api.MemberHandler, leaveRoomRequest(roomId, userTag))
+		rr = invokeHandler(server, leaveRoomRequest(roomId, userTag))
 
 		expectStatus(t, rr, 200)
 		expectBody(t, rr, "")
 	})
 }
 
+func TestPresenceHandlers(t *testing.T) {
+	t.Parallel()
+
+	roomId := 0
+	roomName := "room0"
+
+	t.Run("list members", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+
+		for _, tag := range []string{"user2", "user1"} {
+			rr = invokeHandler(server, joinRoomRequest(roomId, tag, "localhost:6000"))
+			expectStatus(t, rr, 200)
+		}
+
+		rr = invokeHandler(server, listMembersRequest(roomId))
+		expectStatus(t, rr, 200)
+
+		var members []model.MemberInfo
+		err := json.NewDecoder(rr.Body).Decode(&members)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(members))
+		assert.Equal(t, "user1", members[0].Tag)
+		assert.Equal(t, model.Online, members[0].Presence)
+		assert.Equal(t, "user2", members[1].Tag)
+	})
+
+	t.Run("heartbeat without joining", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, heartbeatRequest(roomId, "user1", model.Online))
+		expectStatus(t, rr, 400)
+		expectBody(t, rr, fmt.Sprintf(`"user1" hasn't joined room {Id:%d Name:%s}`, roomId, roomName))
+	})
+
+	t.Run("heartbeat sets presence", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, joinRoomRequest(roomId, "user1", "localhost:6000"))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, heartbeatRequest(roomId, "user1", model.Away))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, listMembersRequest(roomId))
+		expectStatus(t, rr, 200)
+
+		var members []model.MemberInfo
+		err := json.NewDecoder(rr.Body).Decode(&members)
+		assert.Nil(t, err)
+		assert.Equal(t, model.Away, members[0].Presence)
+	})
+}
+
 func TestPostMessageHandler(t *testing.T) {
+	t.Parallel()
+
 	roomId := 0
 	roomName := "room1"
 	userTag := "new_user"
 	message := "this is a test message"
 
 	t.Run("post message to non-existent room", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
-		rr := invokeHandler(api.MessagesHandler, postMessageRequest(roomId, userTag, message))
+		rr := invokeHandler(server, postMessageRequest(roomId, userTag, message))
 
 		expectStatus(t, rr, 400)
 		expectBody(t, rr, fmt.Sprintf(`chat room does not exist: "%d"`, roomId))
 	})
 
 	t.Run("post message to existing room, no one joined", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
-		rr := invokeHandler(api.ChatRoomsHandler, createRoomRequest(roomName))
+		rr := invokeHandler(server, createRoomRequest(roomName))
 		expectStatus(t, rr, 200)
 
-		rr = invokeHandler(api.MessagesHandler, postMessageRequest(roomId, userTag, message))
+		rr = invokeHandler(server, postMessageRequest(roomId, userTag, message))
 		expectStatus(t, rr, 400)
 		expectBody(t, rr, fmt.Sprintf(`"%s" hasn't joined room {Id:%d Name:%s}`, userTag, roomId, roomName))
 	})
 
 	t.Run("post message to existing room, only you joined", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
 		ts := testServerExpectsNoCall(t)
 		defer ts.Close()
 
-		rr := invokeHandler(api.ChatRoomsHandler, createRoomRequest(roomName))
+		rr := invokeHandler(server, createRoomRequest(roomName))
 		expectStatus(t, rr, 200)
 
-		rr = invokeHandler(api.MembersHandler, joinRoomRequest(roomId, userTag, ts.URL))
+		rr = invokeHandler(server, joinRoomRequest(roomId, userTag, ts.URL))
 		expectStatus(t, rr, 200)
 
-		rr = invokeHandler(api.MessagesHandler, postMessageRequest(roomId, userTag, message))
+		rr = invokeHandler(server, postMessageRequest(roomId, userTag, message))
 		expectStatus(t, rr, 200)
 		expectBody(t, rr, "")
 	})
 
 	t.Run("post message to existing room, several people joined", func(t *testing.T) {
-		model.InitChatRoomStore()
+		server := newTestServer()
 
 		myServer := testServerExpectsNoCall(t)
 		defer myServer.Close()
@@ -258,18 +537,18 @@ func TestPostMessageHandler(t *testing.T) {
 			{tag: "user3", callbackUrl: otherServer1.URL},
 		}
 
-		rr := invokeHandler(api.ChatRoomsHandler, createRoomRequest(roomName))
+		rr := invokeHandler(server, createRoomRequest(roomName))
 		expectStatus(t, rr, 200)
 
-		rr = invokeHandler(api.MembersHandler, joinRoomRequest(roomId, userTag, myServer.URL))
+		rr = invokeHandler(server, joinRoomRequest(roomId, userTag, myServer.URL))
 		expectStatus(t, rr, 200)
 
 		for _, otherMember := range otherChatRoomMembers {
-			rr = invokeHandler(api.MembersHandler, joinRoomRequest(roomId, otherMember.tag, otherMember.callbackUrl))
+			rr = invokeHandler(server, joinRoomRequest(roomId, otherMember.tag, otherMember.callbackUrl))
 			expectStatus(t, rr, 200)
 		}
 
-		rr = invokeHandler(api.MessagesHandler, postMessageRequest(roomId, userTag, message))
+		rr = invokeHandler(server, postMessageRequest(roomId, userTag, message))
 		expectStatus(t, rr, 200)
 		expectBody(t, rr, "")
 
@@ -278,76 +557,1651 @@ func TestPostMessageHandler(t *testing.T) {
 	})
 }
 
-func TestDeleteChatRoomHandler(t *testing.T) {
+func TestGetChatRoomHandler(t *testing.T) {
+	t.Parallel()
+
 	roomId := 0
 	roomName := "room0"
+	creator := "creator"
 
-	t.Run("delete non-existent room", func(t *testing.T) {
-		model.InitChatRoomStore()
+	t.Run("get non-existent room", func(t *testing.T) {
+		server := newTestServer()
 
-		rr := invokeHandler(api.ChatRoomHandler, deleteRoomRequest(roomId))
+		rr := invokeHandler(server, getRoomRequest(roomId))
 
 		expectStatus(t, rr, 400)
 		expectBody(t, rr, fmt.Sprintf(`chat room does not exist: "%d"`, roomId))
 	})
 
-	t.Run("delete existing room", func(t *testing.T) {
-		model.InitChatRoomStore()
+	t.Run("get existing room", func(t *testing.T) {
+		server := newTestServer()
 
-		rr := invokeHandler(api.ChatRoomsHandler, createRoomRequest(roomName))
+		rr := invokeHandler(server, createRoomRequestWithCreator(roomName, creator))
 		expectStatus(t, rr, 200)
 
-		rr = invokeHandler(api.ChatRoomHandler, deleteRoomRequest(roomId))
+		rr = invokeHandler(server, joinRoomRequest(roomId, creator, "localhost:6000"))
+		expectStatus(t, rr, 200)
 
+		rr = invokeHandler(server, getRoomRequest(roomId))
 		expectStatus(t, rr, 200)
-		expectBody(t, rr, "")
+
+		var meta model.ProxyMetadata
+		err := json.NewDecoder(rr.Body).Decode(&meta)
+		assert.Nil(t, err)
+		assert.Equal(t, roomId, meta.Id)
+		assert.Equal(t, roomName, meta.Name)
+		assert.Equal(t, creator, meta.Creator)
+		assert.Equal(t, 1, meta.MemberCount)
+		assert.Nil(t, meta.Topic)
+		assert.False(t, meta.CreatedAt.IsZero())
 	})
 }
 
-func invokeHandler(handler http.HandlerFunc, req *http.Request) *httptest.ResponseRecorder {
-	// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
-	rr := httptest.NewRecorder()
+func TestChatRoomLookup(t *testing.T) {
+	t.Parallel()
 
-	// Our handlers satisfy http.Handler, so we can call their ServeHTTP method
-	// directly and pass in our Request and ResponseRecorder.
-	handler.ServeHTTP(rr, req)
+	roomName := "General"
 
-	return rr
-}
+	t.Run("get room by name", func(t *testing.T) {
+		server := newTestServer()
 
-func expectStatus(t *testing.T, rr *httptest.ResponseRecorder, expected int) {
-	t.Helper()
-	// Check the status code is what we expect.
-	if status := rr.Code; status != expected {
-		t.Errorf("handler returned wrong status code: got %v want %v",
-			status, expected)
-	}
+		rr := invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+
+		for _, name := range []string{"general", "GENERAL", "%23general"} {
+			req := httptest.NewRequest("GET", "/api/rooms/by-name/"+name, nil)
+			rr = invokeHandler(server, req)
+			expectStatus(t, rr, 200)
+
+			var meta model.ProxyMetadata
+			err := json.NewDecoder(rr.Body).Decode(&meta)
+			assert.Nil(t, err)
+			assert.Equal(t, roomName, meta.Name)
+		}
+	})
+
+	t.Run("get non-existent room by name", func(t *testing.T) {
+		server := newTestServer()
+
+		req := httptest.NewRequest("GET", "/api/rooms/by-name/general", nil)
+		rr := invokeHandler(server, req)
+
+		expectStatus(t, rr, 400)
+		expectBody(t, rr, `chat room does not exist: "general"`)
+	})
+
+	t.Run("join room by uid", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+
+		var body api.CreateChatRoomResponseBody
+		err := json.NewDecoder(rr.Body).Decode(&body)
+		assert.Nil(t, err)
+
+		bs, err := json.Marshal(api.JoinChatRoomArgs{Tag: "new_user", CallbackURL: "localhost:6000"})
+		assert.Nil(t, err)
+		req := httptest.NewRequest("POST", fmt.Sprintf("/api/rooms/%s/members", body.RoomUid), bytes.NewReader(bs))
+		rr = invokeHandler(server, req)
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, getRoomRequest(body.RoomId))
+		expectStatus(t, rr, 200)
+
+		var meta model.ProxyMetadata
+		err = json.NewDecoder(rr.Body).Decode(&meta)
+		assert.Nil(t, err)
+		assert.Equal(t, body.RoomUid, meta.Uid)
+		assert.Equal(t, 1, meta.MemberCount)
+	})
+
+	t.Run("uids are not reused after delete", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+		var first api.CreateChatRoomResponseBody
+		err := json.NewDecoder(rr.Body).Decode(&first)
+		assert.Nil(t, err)
+
+		rr = invokeHandler(server, deleteRoomRequest(first.RoomId))
+		expectStatus(t, rr, 200)
+
+		server = newTestServer()
+
+		rr = invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+		var second api.CreateChatRoomResponseBody
+		err = json.NewDecoder(rr.Body).Decode(&second)
+		assert.Nil(t, err)
+
+		assert.Equal(t, first.RoomId, second.RoomId)
+		assert.NotEqual(t, first.RoomUid, second.RoomUid)
+
+		req := httptest.NewRequest("GET", "/api/rooms/"+first.RoomUid, nil)
+		rr = invokeHandler(server, req)
+		expectStatus(t, rr, 400)
+		expectBody(t, rr, fmt.Sprintf(`chat room does not exist: "%s"`, first.RoomUid))
+	})
 }
 
-func expectBody(t *testing.T, rr *httptest.ResponseRecorder, expected string) {
-	t.Helper()
-	// Check the response body is what we expect.
-	if rr.Body.String() != expected {
-		t.Errorf("handler returned unexpected body:\n\tgot:  %v\n\twant: %v",
-			rr.Body.String(), expected)
-	}
+func TestUpdateChatRoomHandler(t *testing.T) {
+	t.Parallel()
+
+	roomId := 0
+	roomName := "room0"
+	userTag := "new_user"
+	topic := "all things room0"
+	description := "a room for testing"
+
+	t.Run("non-member can't update room", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, updateRoomRequest(roomId, api.UpdateChatRoomArgs{Tag: userTag, Topic: &topic}))
+
+		expectStatus(t, rr, 400)
+		expectBody(t, rr, fmt.Sprintf(`"%s" hasn't joined room {Id:%d Name:%s}`, userTag, roomId, roomName))
+	})
+
+	t.Run("set topic and description", func(t *testing.T) {
+		server := newTestServer()
+
+		myServer := testServerExpectsNoCall(t)
+		defer myServer.Close()
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		otherServer := testServerExpectsTopic(t, &wg, userTag, topic)
+		defer otherServer.Close()
+
+		rr := invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, joinRoomRequest(roomId, userTag, myServer.URL))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, joinRoomRequest(roomId, "other_user", otherServer.URL))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, updateRoomRequest(roomId, api.UpdateChatRoomArgs{
+			Tag:         userTag,
+			Topic:       &topic,
+			Description: &description,
+		}))
+		expectStatus(t, rr, 200)
+
+		var meta model.ProxyMetadata
+		err := json.NewDecoder(rr.Body).Decode(&meta)
+		assert.Nil(t, err)
+		assert.Equal(t, description, meta.Description)
+		assert.Equal(t, topic, meta.Topic.Text)
+		assert.Equal(t, userTag, meta.Topic.SetBy)
+
+		rr = invokeHandler(server, listRoomsRequest())
+		expectStatus(t, rr, 200)
+		rooms := decodeRoomSummaries(t, rr)
+		assert.Equal(t, 1, len(rooms))
+		assert.Equal(t, topic, rooms[0].Topic)
+
+		wg.Wait()
+	})
+
+	t.Run("invalid field leaves room unchanged", func(t *testing.T) {
+		server := newTestServer()
+		modes := "+x"
+
+		expectStatus(t, invokeHandler(server, createRoomRequestWithCreator(roomName, userTag)), 200)
+		expectStatus(t, invokeHandler(server, joinRoomRequest(roomId, userTag, "")), 200)
+
+		rr := invokeHandler(server, updateRoomRequest(roomId, api.UpdateChatRoomArgs{
+			Tag:         userTag,
+			Description: &description,
+			Modes:       &modes,
+		}))
+		expectStatus(t, rr, 400)
+
+		rr = invokeHandler(server, getRoomRequest(roomId))
+		expectStatus(t, rr, 200)
+		var meta model.ProxyMetadata
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&meta))
+		assert.Equal(t, "", meta.Description)
+	})
 }
 
-func testServerExpectsNoCall(t *testing.T) *httptest.Server {
-	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		assert.Fail(t, "expected no callback to message poster")
-	}))
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	t.Run("counts posted messages", func(t *testing.T) {
+		server := newTestServer()
+		roomName := "metrics_room"
+
+		rr := invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, joinRoomRequest(0, "user1", "localhost:6000"))
+		expectStatus(t, rr, 200)
+
+		for i := 0; i < 2; i++ {
+			rr = invokeHandler(server, postMessageRequest(0, "user1", "hello"))
+			expectStatus(t, rr, 200)
+		}
+		// Commands aren't messages
+		expectStatus(t, invokeHandler(server, postMessageRequest(0, "user1", "/topic metrics")), 200)
+
+		rr = invokeHandler(server, httptest.NewRequest("GET", "/metrics", nil))
+		expectStatus(t, rr, 200)
+
+		body := rr.Body.String()
+		assert.Contains(t, body, "irc_messages_posted_total 2\n")
+		assert.Contains(t, body, "irc_rooms 1\n")
+		assert.Contains(t, body, "irc_members 1\n")
+	})
+
+	t.Run("each server has its own metrics", func(t *testing.T) {
+		registry := metrics.NewRegistry()
+		dispatcher := delivery.NewDispatcher(delivery.Config{Metrics: registry})
+		defer dispatcher.Close()
+		server := api.NewServer(model.NewChatRoomStore(model.WithDispatcher(dispatcher)), api.WithDispatcher(dispatcher), api.WithMetrics(registry), api.WithLogger(log.New(ioutil.Discard, "", 0)))
+		other := newTestServer()
+
+		expectStatus(t, invokeHandler(other, createRoomRequest("other_room")), 200)
+
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/metrics", nil))
+		expectStatus(t, rr, 200)
+		body := rr.Body.String()
+		assert.NotContains(t, body, `irc_http_requests_total{route="/api/rooms",method="POST"`)
+		assert.Contains(t, body, "irc_rooms 0\n")
+		assert.Contains(t, body, "# TYPE irc_callback_delivery_failures_total counter\n")
+	})
 }
 
-func testServerExpectsCall(t *testing.T, wg *sync.WaitGroup, expectedMessage string) *httptest.Server {
-	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("called server!")
-		assert.Equal(t, r.Method, http.MethodPost)
+func TestHealth(t *testing.T) {
+	t.Parallel()
 
-		var body model.CallbackBody
-		err := json.NewDecoder(r.Body).Decode(&body)
-		assert.Nil(t, err)
+	checkNames := func(report health.Report) []string {
+		var names []string
+		for _, check := range report.Checks {
+			names = append(names, check.Name)
+		}
+		return names
+	}
+
+	t.Run("alive", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/healthz", nil))
+
+		expectStatus(t, rr, 200)
+		assert.JSONEq(t, `{"status":"ok","checks":[]}`, rr.Body.String())
+	})
+
+	t.Run("ready", func(t *testing.T) {
+		spool, err := delivery.NewFileSpool(t.TempDir())
+		assert.Nil(t, err)
+		dispatcher := delivery.NewDispatcher(delivery.Config{Spool: spool})
+		defer dispatcher.Close()
+		server := api.NewServer(model.NewChatRoomStore(model.WithDispatcher(dispatcher)),
+			api.WithDispatcher(dispatcher),
+			api.WithCheck("extra", health.CheckFunc(func(ctx context.Context) error { return nil })),
+			api.WithLogger(log.New(ioutil.Discard, "", 0)),
+		)
+
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/readyz", nil))
+
+		expectStatus(t, rr, 200)
+		var report health.Report
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&report))
+		assert.Equal(t, health.StatusOK, report.Status)
+		assert.Equal(t, []string{"extra", "server", "store", "delivery", "spool"}, checkNames(report))
+	})
+
+	t.Run("not ready while backlog is too long", func(t *testing.T) {
+		callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(503)
+		}))
+		defer callback.Close()
+
+		dispatcher := delivery.NewDispatcher(delivery.Config{RetryInterval: time.Hour, MaxBacklog: 1})
+		defer dispatcher.Close()
+		server := api.NewServer(model.NewChatRoomStore(model.WithDispatcher(dispatcher)),
+			api.WithDispatcher(dispatcher),
+			api.WithLogger(log.New(ioutil.Discard, "", 0)),
+		)
+		invokeHandler(server, createRoomRequest("room0"))
+		invokeHandler(server, joinRoomRequest(0, "user0", callback.URL))
+		invokeHandler(server, joinRoomRequest(0, "user1", "localhost:6000"))
+		for i := 0; i < 2; i++ {
+			expectStatus(t, invokeHandler(server, postMessageRequest(0, "user1", "hello")), 200)
+		}
+
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/readyz", nil))
+
+		expectStatus(t, rr, 503)
+		var report health.Report
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&report))
+		assert.Equal(t, health.StatusFailing, report.Status)
+		for _, check := range report.Checks {
+			if check.Name == "delivery" {
+				assert.Equal(t, "backlog of 2 messages exceeds 1", check.Error)
+			}
+		}
+	})
+
+	t.Run("not ready once shutting down", func(t *testing.T) {
+		server := newTestServer()
+		err := server.Shutdown(context.Background())
+		assert.Nil(t, err)
+
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/readyz", nil))
+
+		expectStatus(t, rr, 503)
+		assert.Contains(t, rr.Body.String(), `"error":"shutting down"`)
+	})
+}
+
+func TestRateLimits(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	newRateLimitedServer := func(limits api.RateLimits) *api.Server {
+		return api.NewServer(model.NewChatRoomStore(),
+			api.WithRateLimits(limits),
+			api.WithClock(func() time.Time { return now }),
+			api.WithLogger(log.New(ioutil.Discard, "", 0)),
+		)
+	}
+	fromIP := func(req *http.Request, ip string) *http.Request {
+		req.RemoteAddr = ip + ":1234"
+		return req
+	}
+
+	t.Run("room creation by ip", func(t *testing.T) {
+		server := newRateLimitedServer(api.RateLimits{CreateRoom: ratelimit.PerMinute(2)})
+
+		rr := invokeHandler(server, createRoomRequest("room0"))
+		expectStatus(t, rr, 200)
+		assert.Equal(t, "2", rr.Header().Get("X-RateLimit-Limit"))
+		assert.Equal(t, "1", rr.Header().Get("X-RateLimit-Remaining"))
+		assert.Equal(t, "30", rr.Header().Get("X-RateLimit-Reset"))
+
+		expectStatus(t, invokeHandler(server, createRoomRequest("room1")), 200)
+
+		rr = invokeHandler(server, createRoomRequest("room2"))
+		expectStatus(t, rr, 429)
+		expectBody(t, rr, "rate limit exceeded, retry in 30s")
+		assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+		assert.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
+
+		expectStatus(t, invokeHandler(server, fromIP(createRoomRequest("room2"), "192.0.2.2")), 200)
+
+		rr = invokeHandler(server, listRoomsRequest())
+		expectStatus(t, rr, 200)
+		assert.Empty(t, rr.Header().Get("X-RateLimit-Limit"))
+	})
+
+	t.Run("room creation by creator", func(t *testing.T) {
+		server := newRateLimitedServer(api.RateLimits{CreateRoom: ratelimit.PerMinute(1)})
+
+		expectStatus(t, invokeHandler(server, createRoomRequestWithCreator("room0", "alice")), 200)
+		rr := invokeHandler(server, fromIP(createRoomRequestWithCreator("room1", "alice"), "192.0.2.2"))
+		expectStatus(t, rr, 429)
+	})
+
+	t.Run("imports count as room creation", func(t *testing.T) {
+		server := newRateLimitedServer(api.RateLimits{CreateRoom: ratelimit.PerMinute(1)})
+		importRequest := func(room string) *http.Request {
+			return httptest.NewRequest("POST", "/api/import?tag=alice&room="+room, strings.NewReader("--- Log opened Fri Jan 01 12:00:00 2021\n12:01 <alice> hello\n"))
+		}
+
+		expectStatus(t, invokeHandler(server, importRequest("room0")), 200)
+		expectStatus(t, invokeHandler(server, fromIP(importRequest("room1"), "192.0.2.2")), 429)
+		expectStatus(t, invokeHandler(server, fromIP(createRoomRequestWithCreator("room1", "alice"), "192.0.2.3")), 429)
+	})
+
+	t.Run("posts by tag", func(t *testing.T) {
+		server := newRateLimitedServer(api.RateLimits{Post: ratelimit.PerMinute(1)})
+		expectStatus(t, invokeHandler(server, createRoomRequest("room0")), 200)
+		expectStatus(t, invokeHandler(server, joinRoomRequest(0, "user1", "localhost:6000")), 200)
+		expectStatus(t, invokeHandler(server, joinRoomRequest(0, "user2", "localhost:6000")), 200)
+
+		expectStatus(t, invokeHandler(server, postMessageRequest(0, "user1", "hello")), 200)
+		rr := invokeHandler(server, fromIP(postMessageRequest(0, "user1", "hello"), "192.0.2.2"))
+		expectStatus(t, rr, 429)
+		assert.Equal(t, "60", rr.Header().Get("Retry-After"))
+
+		expectStatus(t, invokeHandler(server, fromIP(postMessageRequest(0, "user2", "hello"), "192.0.2.3")), 200)
+	})
+
+	t.Run("edits and deletions count as posts", func(t *testing.T) {
+		server := newRateLimitedServer(api.RateLimits{Post: ratelimit.PerMinute(2)})
+		expectStatus(t, invokeHandler(server, createRoomRequest("room0")), 200)
+		expectStatus(t, invokeHandler(server, joinRoomRequest(0, "user1", "localhost:6000")), 200)
+
+		expectStatus(t, invokeHandler(server, postMessageRequest(0, "user1", "helo")), 200)
+		expectStatus(t, invokeHandler(server, fromIP(editMessageRequest(0, 1, "user1", "hello"), "192.0.2.2")), 200)
+		expectStatus(t, invokeHandler(server, fromIP(editMessageRequest(0, 1, "user1", "hello!"), "192.0.2.3")), 429)
+		expectStatus(t, invokeHandler(server, fromIP(httptest.NewRequest("DELETE", "/api/rooms/0/messages/1?tag=user1", nil), "192.0.2.4")), 429)
+		expectStatus(t, invokeHandler(server, fromIP(httptest.NewRequest("GET", "/api/rooms/0/messages/1", nil), "192.0.2.5")), 200)
+	})
+
+	t.Run("joins are limited separately from posts", func(t *testing.T) {
+		server := newRateLimitedServer(api.RateLimits{Join: ratelimit.PerMinute(1), Post: ratelimit.PerMinute(5)})
+		expectStatus(t, invokeHandler(server, createRoomRequest("room0")), 200)
+		expectStatus(t, invokeHandler(server, createRoomRequest("room1")), 200)
+
+		expectStatus(t, invokeHandler(server, joinRoomRequest(0, "user1", "localhost:6000")), 200)
+		expectStatus(t, invokeHandler(server, fromIP(joinRoomRequest(1, "user1", "localhost:6000"), "192.0.2.2")), 429)
+		expectStatus(t, invokeHandler(server, postMessageRequest(0, "user1", "hello")), 200)
+	})
+}
+
+func TestMessageValidation(t *testing.T) {
+	t.Parallel()
+
+	newValidatingServer := func(policy model.MessagePolicy) *api.Server {
+		server := api.NewServer(model.NewChatRoomStore(model.WithMessagePolicy(policy)),
+			api.WithMaxBodySize(100),
+			api.WithLogger(log.New(ioutil.Discard, "", 0)),
+		)
+		invokeHandler(server, createRoomRequest("room0"))
+		invokeHandler(server, joinRoomRequest(0, "user1", "localhost:6000"))
+		return server
+	}
+	expectCode := func(t *testing.T, rr *httptest.ResponseRecorder, status int, code string) {
+		t.Helper()
+		expectStatus(t, rr, status)
+		var body struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&body))
+		assert.Equal(t, code, body.Code)
+		assert.NotEmpty(t, body.Error)
+	}
+
+	t.Run("body too large", func(t *testing.T) {
+		server := newValidatingServer(model.DefaultMessagePolicy)
+
+		rr := invokeHandler(server, postMessageRequest(0, "user1", strings.Repeat("a", 100)))
+		expectCode(t, rr, 413, api.BodyTooLargeCode)
+	})
+
+	t.Run("body of unknown length too large", func(t *testing.T) {
+		server := newValidatingServer(model.DefaultMessagePolicy)
+		req := postMessageRequest(0, "user1", strings.Repeat("a", 100))
+		req.ContentLength = -1
+
+		rr := invokeHandler(server, req)
+		expectCode(t, rr, 413, api.BodyTooLargeCode)
+	})
+
+	t.Run("invalid messages", func(t *testing.T) {
+		server := newValidatingServer(model.MessagePolicy{MaxLength: 10, ControlChars: model.RejectControlChars})
+
+		expectCode(t, invokeHandler(server, postMessageRequest(0, "user1", "")), 400, model.EmptyMessageCode)
+		expectCode(t, invokeHandler(server, postMessageRequest(0, "user1", "a\r\nb")), 400, model.ControlCharsCode)
+		expectCode(t, invokeHandler(server, postMessageRequest(0, "user1", "eleven chars")), 400, model.MessageTooLongCode)
+		expectStatus(t, invokeHandler(server, postMessageRequest(0, "user1", "ten chars!")), 200)
+	})
+
+	t.Run("invalid utf8", func(t *testing.T) {
+		server := newValidatingServer(model.DefaultMessagePolicy)
+		req := httptest.NewRequest("POST", "/api/rooms/0/members/user1/messages", strings.NewReader("{\"message\":\"\xff\"}"))
+
+		rr := invokeHandler(server, req)
+		expectCode(t, rr, 400, model.InvalidUTF8Code)
+	})
+}
+
+func TestCommands(t *testing.T) {
+	t.Parallel()
+
+	server := api.NewServer(model.NewChatRoomStore(), api.WithLogger(log.New(ioutil.Discard, "", 0)))
+	invokeHandler(server, createRoomRequest("room0"))
+	invokeHandler(server, joinRoomRequest(0, "user1", "localhost:6000"))
+	invokeHandler(server, joinRoomRequest(0, "user2", "localhost:6001"))
+	expectCode := func(t *testing.T, rr *httptest.ResponseRecorder, status int, code string) {
+		t.Helper()
+		expectStatus(t, rr, status)
+		var body struct {
+			Code string `json:"code"`
+		}
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&body))
+		assert.Equal(t, code, body.Code)
+	}
+
+	expectCode(t, invokeHandler(server, postMessageRequest(0, "user1", "/dance")), 400, model.UnknownCommandCode)
+	expectCode(t, invokeHandler(server, postMessageRequest(0, "user1", "/invite")), 400, model.InvalidArgumentsCode)
+	expectCode(t, invokeHandler(server, postMessageRequest(0, "user2", "/kick user1")), 403, model.ForbiddenCode)
+
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "user1", "/topic commands")), 200)
+	rr := invokeHandler(server, getRoomRequest(0))
+	assert.Contains(t, rr.Body.String(), `"commands"`)
+
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "user1", "/kick user2")), 200)
+	rr = invokeHandler(server, listMembersRequest(0))
+	assert.NotContains(t, rr.Body.String(), "user2")
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer()
+	invokeHandler(server, createRoomRequest("general"))
+	invokeHandler(server, createRoomRequest("ops"))
+	invokeHandler(server, joinRoomRequest(0, "alice", "localhost:6000"))
+	invokeHandler(server, joinRoomRequest(1, "bob", "localhost:6001"))
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "alice", "the build is broken")), 200)
+	expectStatus(t, invokeHandler(server, postMessageRequest(1, "bob", "who broke the build?")), 200)
+	expectStatus(t, invokeHandler(server, postMessageRequest(1, "bob", "build fixed")), 200)
+
+	searchFor := func(t *testing.T, query string) ([]api.SearchResult, *httptest.ResponseRecorder) {
+		t.Helper()
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/api/search?"+query, nil))
+		expectStatus(t, rr, 200)
+		var results []api.SearchResult
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&results))
+		return results, rr
+	}
+	messages := func(results []api.SearchResult) []string {
+		out := []string{}
+		for _, result := range results {
+			out = append(out, fmt.Sprintf("%s/%d %s", result.RoomName, result.Id, result.Message))
+		}
+		return out
+	}
+
+	t.Run("words, phrases and filters", func(t *testing.T) {
+		results, _ := searchFor(t, "q=build")
+		assert.Equal(t, []string{"ops/2 build fixed", "ops/1 who broke the build?", "general/1 the build is broken"}, messages(results))
+
+		results, _ = searchFor(t, "q=%22the+build%22&from=bob")
+		assert.Equal(t, []string{"ops/1 who broke the build?"}, messages(results))
+		assert.Equal(t, "who broke the build?", results[0].Snippet)
+		assert.Equal(t, [][2]int{{10, 13}, {14, 19}}, results[0].Highlights)
+
+		results, _ = searchFor(t, "q=build&room=general")
+		assert.Equal(t, []string{"general/1 the build is broken"}, messages(results))
+
+		results, _ = searchFor(t, "q=build&after=2000-01-01T00:00:00Z&before=2001-01-01T00:00:00Z")
+		assert.Empty(t, results)
+	})
+
+	t.Run("pages", func(t *testing.T) {
+		results, rr := searchFor(t, "q=build&limit=2")
+		assert.Len(t, results, 2)
+		link := rr.Header().Get("Link")
+		next := strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+
+		results, rr = searchFor(t, strings.TrimPrefix(next, "/api/search?"))
+		assert.Equal(t, []string{"general/1 the build is broken"}, messages(results))
+		assert.Empty(t, rr.Header().Get("Link"))
+	})
+
+	t.Run("secret rooms are only searched by their members", func(t *testing.T) {
+		modes := "+s"
+		expectStatus(t, invokeHandler(server, updateRoomRequest(1, api.UpdateChatRoomArgs{Tag: "bob", Modes: &modes})), 200)
+
+		results, _ := searchFor(t, "q=build")
+		assert.Equal(t, []string{"general/1 the build is broken"}, messages(results))
+		results, _ = searchFor(t, "q=build&member=bob")
+		assert.Len(t, results, 3)
+	})
+
+	t.Run("invalid queries", func(t *testing.T) {
+		for _, query := range []string{"", "q=%3F", "q=build&room=nowhere", "q=build&after=yesterday", "q=build&limit=0", "q=build&cursor=garbage"} {
+			rr := invokeHandler(server, httptest.NewRequest("GET", "/api/search?"+query, nil))
+			expectStatus(t, rr, 400)
+		}
+	})
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer()
+	invokeHandler(server, createRoomRequest("general"))
+	invokeHandler(server, joinRoomRequest(0, "alice", "localhost:6000"))
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "alice", "hello")), 200)
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "alice", "/me waves")), 200)
+	exportRequest := func(query string) *http.Request {
+		return httptest.NewRequest("GET", "/api/rooms/0/export?"+query, nil)
+	}
+
+	t.Run("json lines", func(t *testing.T) {
+		rr := invokeHandler(server, exportRequest(""))
+		expectStatus(t, rr, 200)
+		assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+		assert.Equal(t, `attachment; filename="general.jsonl"`, rr.Header().Get("Content-Disposition"))
+
+		var types []string
+		decoder := json.NewDecoder(rr.Body)
+		for decoder.More() {
+			var message model.Message
+			assert.Nil(t, decoder.Decode(&message))
+			types = append(types, message.Type)
+		}
+		assert.Equal(t, []string{model.JoinEvent, model.MessageEvent, model.ActionEvent}, types)
+	})
+
+	t.Run("irc log", func(t *testing.T) {
+		rr := invokeHandler(server, exportRequest("format=irclog"))
+		expectStatus(t, rr, 200)
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		assert.Len(t, lines, 5)
+		assert.True(t, strings.HasSuffix(lines[2], "] <alice> hello"), lines[2])
+		assert.True(t, strings.HasSuffix(lines[3], "]  * alice waves"), lines[3])
+	})
+
+	t.Run("html", func(t *testing.T) {
+		rr := invokeHandler(server, exportRequest("format=html"))
+		expectStatus(t, rr, 200)
+		assert.Equal(t, "text/html; charset=utf-8", rr.Header().Get("Content-Type"))
+		assert.Contains(t, rr.Body.String(), "&lt;alice&gt; hello")
+	})
+
+	t.Run("time range", func(t *testing.T) {
+		rr := invokeHandler(server, exportRequest("to=2000-01-01T00:00:00Z"))
+		expectStatus(t, rr, 200)
+		expectBody(t, rr, "")
+	})
+
+	t.Run("invalid queries", func(t *testing.T) {
+		expectStatus(t, invokeHandler(server, exportRequest("format=pdf")), 400)
+		expectStatus(t, invokeHandler(server, exportRequest("from=yesterday")), 400)
+	})
+
+	t.Run("secret rooms are only exported to their members", func(t *testing.T) {
+		server := newTestServer()
+		invokeHandler(server, createRoomRequest("secret"))
+		invokeHandler(server, joinRoomRequest(0, "alice", "localhost:6000"))
+		modes := "+s"
+		expectStatus(t, invokeHandler(server, updateRoomRequest(0, api.UpdateChatRoomArgs{Tag: "alice", Modes: &modes})), 200)
+
+		expectStatus(t, invokeHandler(server, exportRequest("")), 403)
+		expectStatus(t, invokeHandler(server, exportRequest("member=alice")), 200)
+	})
+}
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer()
+	importRequest := func(query string, body string) *http.Request {
+		return httptest.NewRequest("POST", "/api/import?"+query, strings.NewReader(body))
+	}
+	decode := func(rr *httptest.ResponseRecorder) api.ImportResponseBody {
+		var body api.ImportResponseBody
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&body))
+		return body
+	}
+	log := strings.Join([]string{
+		"--- Log opened Fri Jan 01 12:00:00 2021",
+		"12:00 -!- alice [~alice@example.com] has joined #general",
+		"12:01 <alice> hello from the old server",
+		"12:01 -!- Netsplit over, joins: bob",
+		"12:02  * alice waves",
+	}, "\n")
+
+	rr := invokeHandler(server, importRequest("room=general&tag=alice", log))
+	expectStatus(t, rr, 200)
+	body := decode(rr)
+	assert.True(t, body.Created)
+	assert.Equal(t, model.ImportResult{Imported: 3}, body.ImportResult)
+	assert.Equal(t, 1, body.Skipped)
+
+	rr = invokeHandler(server, importRequest("room=general&tag=alice", log))
+	expectStatus(t, rr, 200)
+	body = decode(rr)
+	assert.False(t, body.Created)
+	assert.Equal(t, model.ImportResult{Duplicates: 3}, body.ImportResult)
+
+	rr = invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/export", nil))
+	expectStatus(t, rr, 200)
+	rr = invokeHandler(server, importRequest("room=general&tag=alice&format=json", rr.Body.String()))
+	expectStatus(t, rr, 200)
+	assert.Equal(t, model.ImportResult{Duplicates: 3}, decode(rr).ImportResult, "exported transcripts import as duplicates")
+
+	rr = invokeHandler(server, httptest.NewRequest("GET", "/api/search?q=old", nil))
+	expectStatus(t, rr, 200)
+	assert.Contains(t, rr.Body.String(), "hello from the old server")
+
+	t.Run("only operators may import into existing rooms", func(t *testing.T) {
+		rr := invokeHandler(server, importRequest("room=general&tag=mallory", log))
+		expectStatus(t, rr, 403)
+	})
+
+	t.Run("invalid imports", func(t *testing.T) {
+		expectStatus(t, invokeHandler(server, importRequest("room=general", log)), 400)
+		expectStatus(t, invokeHandler(server, importRequest("room=general&tag=alice&format=html", log)), 400)
+		expectStatus(t, invokeHandler(server, importRequest("room=other&tag=alice", "12:00 <alice> undated")), 400)
+		expectStatus(t, invokeHandler(server, getRoomRequest(1)), 400)
+
+		rr := invokeHandler(server, importRequest("room=general&tag=alice", "--- Log opened Fri Jan 01 12:00:00 2021\n12:05 <alice> "+strings.Repeat("a", 5000)))
+		expectStatus(t, rr, 400)
+		assert.Contains(t, rr.Body.String(), model.MessageTooLongCode)
+	})
+}
+
+func adminRequest(method string, path string, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	return req
+}
+
+func TestAdmin(t *testing.T) {
+	t.Parallel()
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+	}))
+	defer down.Close()
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer up.Close()
+
+	dispatcher := delivery.NewDispatcher(delivery.Config{RetryInterval: time.Hour})
+	defer dispatcher.Close()
+	server := api.NewServer(model.NewChatRoomStore(model.WithDispatcher(dispatcher)),
+		api.WithDispatcher(dispatcher),
+		api.WithAdminToken("admin-secret"),
+		api.WithLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	invokeHandler(server, createRoomRequest("room0"))
+	invokeHandler(server, createRoomRequest("room1"))
+	expectStatus(t, invokeHandler(server, joinRoomRequest(0, "alice", down.URL)), 200)
+	expectStatus(t, invokeHandler(server, joinRoomRequest(0, "bob", up.URL)), 200)
+	expectStatus(t, invokeHandler(server, joinRoomRequest(1, "bob", up.URL)), 200)
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "bob", "hello")), 200)
+
+	t.Run("requires the admin token", func(t *testing.T) {
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/admin/members", nil))
+		expectStatus(t, rr, 401)
+		assert.Equal(t, `Bearer realm="admin"`, rr.Header().Get("WWW-Authenticate"))
+
+		req := adminRequest("GET", "/admin/members", "")
+		req.Header.Set("Authorization", "Bearer guess")
+		expectStatus(t, invokeHandler(server, req), 401)
+		req.Header.Set("Authorization", "admin-secret")
+		expectStatus(t, invokeHandler(server, req), 401)
+		req.Header.Set("Authorization", "bearer admin-secret")
+		expectStatus(t, invokeHandler(server, req), 200)
+
+		expectStatus(t, invokeHandler(newTestServer(), adminRequest("GET", "/admin/members", "")), 404)
+	})
+
+	t.Run("members and delivery health", func(t *testing.T) {
+		var members []api.MemberStatus
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			rr := invokeHandler(server, adminRequest("GET", "/admin/members", ""))
+			expectStatus(t, rr, 200)
+			members = nil
+			assert.Nil(t, json.NewDecoder(rr.Body).Decode(&members))
+			if members[0].Delivery.Failures > 0 {
+				break
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.Len(t, members, 3)
+		assert.Equal(t, "alice", members[0].Tag)
+		assert.Equal(t, down.URL, members[0].CallbackUrl)
+		assert.Equal(t, 1, members[0].Delivery.Pending)
+		assert.Contains(t, members[0].Delivery.LastError, "503")
+		assert.Equal(t, delivery.QueueStatus{}, *members[1].Delivery)
+
+		rr := invokeHandler(server, adminRequest("GET", "/admin/members?tag=bob", ""))
+		expectStatus(t, rr, 200)
+		members = nil
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&members))
+		assert.Len(t, members, 2)
+
+		rr = invokeHandler(server, adminRequest("GET", "/admin/rooms", ""))
+		expectStatus(t, rr, 200)
+		var rooms []api.RoomBacklog
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&rooms))
+		assert.Equal(t, []api.RoomBacklog{
+			{RoomId: 0, RoomName: "room0", Members: 2, Backlog: 1, Failing: 1},
+			{RoomId: 1, RoomName: "room1", Members: 1},
+		}, rooms)
+	})
+
+	t.Run("server notices", func(t *testing.T) {
+		expectStatus(t, invokeHandler(server, adminRequest("POST", "/admin/notices", `{"message":"maintenance at noon"}`)), 200)
+		expectStatus(t, invokeHandler(server, adminRequest("POST", "/admin/notices", `{"message":" "}`)), 400)
+		assert.Equal(t, 2, dispatcher.Pending("alice", down.URL))
+	})
+
+	t.Run("remove and ban members", func(t *testing.T) {
+		rr := invokeHandler(server, adminRequest("DELETE", "/admin/members/alice?reason=flooding", ""))
+		expectStatus(t, rr, 200)
+		expectBody(t, rr, `{"rooms":[0]}`)
+
+		rr = invokeHandler(server, adminRequest("POST", "/admin/bans", `{"tag":"bob","reason":"spam"}`))
+		expectStatus(t, rr, 200)
+		expectBody(t, rr, `{"rooms":[0,1]}`)
+		expectBody(t, invokeHandler(server, listMembersRequest(1)), "[]")
+
+		rr = invokeHandler(server, joinRoomRequest(1, "bob", up.URL))
+		expectStatus(t, rr, 403)
+		assert.Contains(t, rr.Body.String(), `"code":"forbidden"`)
+
+		rr = invokeHandler(server, adminRequest("GET", "/admin/bans", ""))
+		expectStatus(t, rr, 200)
+		assert.Contains(t, rr.Body.String(), `"tag":"bob","reason":"spam"`)
+
+		expectStatus(t, invokeHandler(server, adminRequest("DELETE", "/admin/bans/bob", "")), 200)
+		expectStatus(t, invokeHandler(server, adminRequest("DELETE", "/admin/bans/bob", "")), 400)
+		expectStatus(t, invokeHandler(server, joinRoomRequest(1, "bob", up.URL)), 200)
+		expectStatus(t, invokeHandler(server, adminRequest("POST", "/admin/bans", `{"reason":"nobody"}`)), 400)
+	})
+}
+
+func TestAudit(t *testing.T) {
+	t.Parallel()
+
+	auditLog := audit.NewLog(audit.Config{})
+	server := api.NewServer(model.NewChatRoomStore(model.WithAuditLog(auditLog)),
+		api.WithAdminToken("admin-secret"),
+		api.WithAuditLog(auditLog),
+		api.WithLogger(log.New(ioutil.Discard, "", 0)),
+	)
+	auditRequest := func(query string) []audit.Entry {
+		t.Helper()
+		rr := invokeHandler(server, adminRequest("GET", "/admin/audit?"+query, ""))
+		expectStatus(t, rr, 200)
+		var entries []audit.Entry
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&entries))
+		return entries
+	}
+	actions := func(entries []audit.Entry) []string {
+		out := []string{}
+		for _, e := range entries {
+			out = append(out, e.Action+" "+e.Actor+">"+e.Target)
+		}
+		return out
+	}
+
+	invokeHandler(server, createRoomRequestWithCreator("general", "alice"))
+	invokeHandler(server, joinRoomRequest(0, "alice", "localhost:6000"))
+	invokeHandler(server, joinRoomRequest(0, "bob", "localhost:6001"))
+	modes := "+t"
+	expectStatus(t, invokeHandler(server, updateRoomRequest(0, api.UpdateChatRoomArgs{Tag: "alice", Modes: &modes})), 200)
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "alice", "/mode +o bob")), 200)
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "bob", "/kick alice bye")), 200)
+	req := adminRequest("POST", "/admin/bans", `{"tag":"bob","reason":"spam"}`)
+	req.Header.Set(requestid.Header, "ban-request")
+	expectStatus(t, invokeHandler(server, req), 200)
+	expectStatus(t, invokeHandler(server, deleteRoomRequest(0)), 200)
+
+	assert.Equal(t, []string{
+		"room.create alice>",
+		"member.join alice>alice",
+		"member.join bob>bob",
+		"room.mode alice>",
+		"member.role alice>bob",
+		"member.kick bob>alice",
+		"admin.ban >bob",
+		"member.leave >bob",
+		"room.delete >",
+	}, actions(auditRequest("")))
+
+	t.Run("before and after", func(t *testing.T) {
+		entries := auditRequest("action=member.role")
+		assert.Len(t, entries, 1)
+		assert.JSONEq(t, `{"callbackUrl":"localhost:6001","operator":false}`, string(entries[0].Before))
+		assert.JSONEq(t, `{"callbackUrl":"localhost:6001","operator":true}`, string(entries[0].After))
+		assert.Equal(t, "general", entries[0].Room.Name)
+
+		entries = auditRequest("action=room.mode")
+		assert.JSONEq(t, `{"modes":"t"}`, string(entries[0].After))
+	})
+
+	t.Run("filters", func(t *testing.T) {
+		assert.Equal(t, []string{"admin.ban >bob", "member.leave >bob"}, actions(auditRequest("requestId=ban-request")))
+		assert.Equal(t, []string{"member.kick bob>alice"}, actions(auditRequest("actor=bob&target=alice")))
+		assert.Len(t, auditRequest("target=bob"), 4)
+		assert.Len(t, auditRequest("room=1"), 0)
+		assert.Len(t, auditRequest("to=2000-01-01T00:00:00Z"), 0)
+
+		rr := invokeHandler(server, adminRequest("GET", "/admin/audit?limit=2", ""))
+		expectStatus(t, rr, 200)
+		assert.Equal(t, `</admin/audit?after=2&limit=2>; rel="next"`, rr.Header().Get("Link"))
+
+		expectStatus(t, invokeHandler(server, adminRequest("GET", "/admin/audit?room=general", "")), 400)
+		expectStatus(t, invokeHandler(server, adminRequest("GET", "/admin/audit?limit=0", "")), 400)
+	})
+
+	t.Run("export", func(t *testing.T) {
+		rr := invokeHandler(server, adminRequest("GET", "/admin/audit/export?actor=alice", ""))
+		expectStatus(t, rr, 200)
+		assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+		lines := strings.Split(strings.TrimSpace(rr.Body.String()), "\n")
+		assert.Len(t, lines, 4)
+		var entry audit.Entry
+		assert.Nil(t, json.Unmarshal([]byte(lines[0]), &entry))
+		assert.Equal(t, audit.RoomCreate, entry.Action)
+
+		expectStatus(t, invokeHandler(server, httptest.NewRequest("GET", "/admin/audit/export", nil)), 401)
+	})
+
+	t.Run("nick", func(t *testing.T) {
+		invokeHandler(server, createRoomRequestWithCreator("random", "carol"))
+		invokeHandler(server, joinRoomRequest(1, "carol", "localhost:6002"))
+		expectStatus(t, invokeHandler(server, postMessageRequest(1, "carol", "/nick caroline")), 200)
+
+		entries := auditRequest("action=member.nick")
+		assert.Equal(t, []string{"member.nick carol>caroline"}, actions(entries))
+		assert.JSONEq(t, `{"tag":"carol"}`, string(entries[0].Before))
+		assert.JSONEq(t, `{"tag":"caroline"}`, string(entries[0].After))
+	})
+
+	t.Run("topics and webhooks", func(t *testing.T) {
+		topic := "release day"
+		expectStatus(t, invokeHandler(server, updateRoomRequest(1, api.UpdateChatRoomArgs{Tag: "caroline", Topic: &topic})), 200)
+		expectStatus(t, invokeHandler(server, postMessageRequest(1, "caroline", "/topic release night")), 200)
+		entries := auditRequest("action=room.update&room=1")
+		assert.Len(t, entries, 2)
+		assert.JSONEq(t, `{"topic":""}`, string(entries[0].Before))
+		assert.JSONEq(t, `{"topic":"release night"}`, string(entries[1].After))
+
+		rr := invokeHandler(server, addWebhookRequest(1, api.AddWebhookArgs{Tag: "caroline", URL: "https://hooks.example.com/secret", Format: webhook.Slack}))
+		expectStatus(t, rr, 200)
+		var hook model.Webhook
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&hook))
+		expectStatus(t, invokeHandler(server, httptest.NewRequest("DELETE", "/api/rooms/1/webhooks/"+hook.Id+"?tag=caroline", nil)), 200)
+		expectStatus(t, invokeHandler(server, addIncomingWebhookRequest(1, "caroline", "CI")), 200)
+
+		entries = auditRequest("actor=caroline&action=webhook.add")
+		assert.Len(t, entries, 2)
+		assert.NotContains(t, string(entries[0].After), "secret", "webhook URLs may hold secrets")
+		assert.JSONEq(t, `{"id":"`+hook.Id+`","kind":"outgoing","url":"https://hooks.example.com","format":"slack"}`, string(entries[0].After))
+		assert.Contains(t, string(entries[1].After), `"name":"CI"`)
+		assert.Len(t, auditRequest("action=webhook.remove"), 1)
+	})
+}
+
+func TestSpam(t *testing.T) {
+	t.Parallel()
+
+	config := spam.Config{MaxDuplicates: 1, DuplicateWindow: time.Minute, Actions: map[spam.Rule]spam.Action{spam.Duplicate: spam.Mute}, MuteFor: time.Hour}
+	server := api.NewServer(model.NewChatRoomStore(model.WithSpamConfig(config)), api.WithLogger(log.New(ioutil.Discard, "", 0)))
+	invokeHandler(server, createRoomRequestWithCreator("general", "alice"))
+	invokeHandler(server, joinRoomRequest(0, "alice", "localhost:6000"))
+	invokeHandler(server, joinRoomRequest(0, "bob", "localhost:6001"))
+
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "bob", "buy now")), 200)
+	rr := invokeHandler(server, postMessageRequest(0, "bob", "buy now"))
+	expectStatus(t, rr, 400)
+	assert.Contains(t, rr.Body.String(), `"code":"spam"`)
+	rr = invokeHandler(server, postMessageRequest(0, "bob", "sorry"))
+	expectStatus(t, rr, 403)
+	assert.Contains(t, rr.Body.String(), `"code":"muted"`)
+
+	rr = invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/spam?tag=alice", nil))
+	expectStatus(t, rr, 200)
+	var decisions []spam.Decision
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&decisions))
+	assert.Len(t, decisions, 1)
+	assert.Equal(t, spam.Duplicate, decisions[0].Rule)
+	assert.Equal(t, "bob", decisions[0].Tag)
+	assert.Equal(t, "buy now", decisions[0].Text)
+
+	rr = invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/spam?tag=bob", nil))
+	expectStatus(t, rr, 403)
+}
+
+func setHooksRequest(roomId int, tag string, specs []hook.Spec) *http.Request {
+	bs, err := json.Marshal(api.SetHooksArgs{Tag: tag, Hooks: specs})
+	if err != nil {
+		log.Panicln(err)
+	}
+	return httptest.NewRequest("PUT", fmt.Sprintf("/api/rooms/%d/hooks", roomId), bytes.NewReader(bs))
+}
+
+func TestMessageHooks(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer()
+	invokeHandler(server, createRoomRequestWithCreator("general", "alice"))
+	invokeHandler(server, joinRoomRequest(0, "alice", "localhost:6000"))
+	invokeHandler(server, joinRoomRequest(0, "bob", "localhost:6001"))
+
+	specs := []hook.Spec{{Type: hook.RedactType, Words: []string{"darn"}}}
+	expectStatus(t, invokeHandler(server, setHooksRequest(0, "bob", specs)), 403)
+	rr := invokeHandler(server, setHooksRequest(0, "alice", []hook.Spec{{Type: hook.ReplaceType, Pattern: "("}}))
+	expectStatus(t, rr, 400)
+	rr = invokeHandler(server, setHooksRequest(0, "alice", specs))
+	expectStatus(t, rr, 200)
+	expectBody(t, rr, `[{"type":"redact","words":["darn"]}]`)
+
+	rr = invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/hooks?tag=alice", nil))
+	expectStatus(t, rr, 200)
+	expectBody(t, rr, `[{"type":"redact","words":["darn"]}]`)
+
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "bob", "darn it")), 200)
+	rr = invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/export", nil))
+	expectStatus(t, rr, 200)
+	assert.Contains(t, rr.Body.String(), `"text":"**** it"`)
+	assert.NotContains(t, rr.Body.String(), "darn")
+
+	t.Run("verdicts", func(t *testing.T) {
+		server := api.NewServer(model.NewChatRoomStore(model.WithMessageHooks(questionHook{})), api.WithLogger(log.New(ioutil.Discard, "", 0)))
+		invokeHandler(server, createRoomRequest("general"))
+		invokeHandler(server, joinRoomRequest(0, "bob", "localhost:6001"))
+
+		rr := invokeHandler(server, postMessageRequest(0, "bob", "why?"))
+		expectStatus(t, rr, 400)
+		assert.Contains(t, rr.Body.String(), `"code":"question"`)
+		rr = invokeHandler(server, postMessageRequest(0, "bob", "see http://example.com"))
+		expectStatus(t, rr, 202)
+		assert.Contains(t, rr.Body.String(), `"code":"held"`)
+		rr = invokeHandler(server, postMessageRequest(0, "bob", "boom"))
+		expectStatus(t, rr, 400)
+		assert.Contains(t, rr.Body.String(), `"code":"hook_failed"`)
+		expectStatus(t, invokeHandler(server, postMessageRequest(0, "bob", "fine")), 200)
+	})
+}
+
+// questionHook rejects questions, holds links and fails on "boom".
+type questionHook struct{}
+
+func (questionHook) Name() string {
+	return "questions"
+}
+
+func (questionHook) Apply(ctx context.Context, m *hook.Message) (hook.Verdict, error) {
+	switch {
+	case strings.HasSuffix(m.Text, "?"):
+		return hook.Rejected("question", "no questions"), nil
+	case strings.Contains(m.Text, "http"):
+		return hook.Held("links are reviewed"), nil
+	case m.Text == "boom":
+		return hook.Verdict{}, fmt.Errorf("exploded")
+	}
+	return hook.Verdict{}, nil
+}
+
+func decideHeldMessageRequest(roomId int, id int64, args api.DecideHeldMessageArgs) *http.Request {
+	bs, err := json.Marshal(args)
+	if err != nil {
+		log.Panicln(err)
+	}
+	return httptest.NewRequest("POST", fmt.Sprintf("/api/rooms/%d/held/%d", roomId, id), bytes.NewReader(bs))
+}
+
+func TestModerationQueue(t *testing.T) {
+	t.Parallel()
+
+	events := make(chan model.CallbackBody, 10)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body model.CallbackBody
+		assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		events <- body
+	}))
+	defer callback.Close()
+
+	server := newTestServer()
+	invokeHandler(server, createRoomRequestWithCreator("general", "alice"))
+	invokeHandler(server, joinRoomRequest(0, "alice", "localhost:6000"))
+	invokeHandler(server, joinRoomRequest(0, "bob", callback.URL))
+	modes := "+m"
+	expectStatus(t, invokeHandler(server, updateRoomRequest(0, api.UpdateChatRoomArgs{Tag: "alice", Modes: &modes})), 200)
+
+	rr := invokeHandler(server, postMessageRequest(0, "bob", "first"))
+	expectStatus(t, rr, 202)
+	var held api.HeldResponseBody
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&held))
+	assert.Equal(t, api.HeldResponseBody{Code: model.HeldCode, Id: 1, Reason: "the room is moderated"}, held)
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "bob", "second")), 202)
+
+	rr = invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/held?tag=alice", nil))
+	expectStatus(t, rr, 200)
+	var messages []model.HeldMessage
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&messages))
+	assert.Len(t, messages, 2)
+	assert.Equal(t, "first", messages[0].Text)
+	expectStatus(t, invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/held?tag=bob", nil)), 403)
+
+	expectStatus(t, invokeHandler(server, decideHeldMessageRequest(0, 1, api.DecideHeldMessageArgs{Tag: "bob", Decision: api.Approve})), 403)
+	expectStatus(t, invokeHandler(server, decideHeldMessageRequest(0, 1, api.DecideHeldMessageArgs{Tag: "alice", Decision: "maybe"})), 400)
+	expectStatus(t, invokeHandler(server, decideHeldMessageRequest(0, 1, api.DecideHeldMessageArgs{Tag: "alice", Decision: api.Approve})), 200)
+	expectStatus(t, invokeHandler(server, decideHeldMessageRequest(0, 2, api.DecideHeldMessageArgs{Tag: "alice", Decision: api.Reject, Reason: "spoilers"})), 200)
+	expectStatus(t, invokeHandler(server, decideHeldMessageRequest(0, 2, api.DecideHeldMessageArgs{Tag: "alice", Decision: api.Reject})), 400)
+
+	decisions := map[string]model.CallbackBody{}
+	for len(decisions) < 2 {
+		select {
+		case event := <-events:
+			if event.Type == model.MessageApprovedEvent || event.Type == model.MessageRejectedEvent {
+				decisions[event.Type] = event
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for decisions")
+		}
+	}
+	assert.Equal(t, int64(1), decisions[model.MessageApprovedEvent].HeldId)
+	assert.Equal(t, "spoilers", decisions[model.MessageRejectedEvent].Reason)
+
+	rr = invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/export", nil))
+	assert.Contains(t, rr.Body.String(), `"text":"first"`)
+	assert.NotContains(t, rr.Body.String(), `"text":"second"`)
+}
+
+func editMessageRequest(roomId int, id int64, tag string, message string) *http.Request {
+	bs, err := json.Marshal(api.EditMessageArgs{Tag: tag, Message: message})
+	if err != nil {
+		log.Panicln(err)
+	}
+	return httptest.NewRequest("PATCH", fmt.Sprintf("/api/rooms/%d/messages/%d", roomId, id), bytes.NewReader(bs))
+}
+
+func TestEditMessages(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer()
+	invokeHandler(server, createRoomRequestWithCreator("general", "alice"))
+	invokeHandler(server, joinRoomRequest(0, "alice", "localhost:6000"))
+	invokeHandler(server, joinRoomRequest(0, "bob", "localhost:6001"))
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "bob", "helo")), 200)
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "bob", "bye")), 200)
+	expectStatus(t, invokeHandler(server, postMessageRequest(0, "alice", "welcome")), 200)
+
+	expectStatus(t, invokeHandler(server, editMessageRequest(0, 1, "bob", "hello")), 200)
+	rr := invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/messages/1", nil))
+	expectStatus(t, rr, 200)
+	var message model.Message
+	assert.Nil(t, json.NewDecoder(rr.Body).Decode(&message))
+	assert.Equal(t, "hello", message.Text)
+	assert.Len(t, message.Edits, 1)
+	assert.Equal(t, "helo", message.Edits[0].Text)
+
+	rr = invokeHandler(server, editMessageRequest(0, 1, "bob", ""))
+	expectStatus(t, rr, 400)
+	assert.Contains(t, rr.Body.String(), `"code":"empty_message"`)
+	expectStatus(t, invokeHandler(server, editMessageRequest(0, 1, "alice", "moderated")), 200)
+	expectStatus(t, invokeHandler(server, httptest.NewRequest("DELETE", "/api/rooms/0/messages/3?tag=bob", nil)), 403)
+	expectStatus(t, invokeHandler(server, httptest.NewRequest("DELETE", "/api/rooms/0/messages/2?tag=bob", nil)), 200)
+	expectStatus(t, invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/messages/2", nil)), 404)
+	expectStatus(t, invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/messages/two", nil)), 400)
+
+	rr = invokeHandler(server, httptest.NewRequest("GET", "/api/search?q=bye", nil))
+	expectStatus(t, rr, 200)
+	assert.NotContains(t, rr.Body.String(), "bye")
+	rr = invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/export", nil))
+	assert.Contains(t, rr.Body.String(), `"text":"moderated"`)
+	assert.NotContains(t, rr.Body.String(), `"text":"bye"`)
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Parallel()
+
+	t.Run("operator mirrors a room to discord", func(t *testing.T) {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		hookServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			bs, err := ioutil.ReadAll(r.Body)
+			assert.Nil(t, err)
+			assert.JSONEq(t, `{"content":"hello","username":"bob"}`, string(bs))
+			wg.Done()
+		}))
+		defer hookServer.Close()
+
+		dispatcher := delivery.NewDispatcher(delivery.Config{})
+		defer dispatcher.Close()
+		server := api.NewServer(model.NewChatRoomStore(model.WithDispatcher(dispatcher)),
+			api.WithLogger(log.New(ioutil.Discard, "", 0)),
+		)
+		expectStatus(t, invokeHandler(server, createRoomRequestWithCreator("room0", "alice")), 200)
+		expectStatus(t, invokeHandler(server, joinRoomRequest(0, "bob", "localhost:6000")), 200)
+		expectStatus(t, invokeHandler(server, joinRoomRequest(0, "alice", "localhost:6000")), 200)
+
+		rr := invokeHandler(server, addWebhookRequest(0, api.AddWebhookArgs{Tag: "bob", URL: hookServer.URL, Format: webhook.Discord}))
+		expectStatus(t, rr, 403)
+		assert.Contains(t, rr.Body.String(), `"code":"forbidden"`)
+		assert.Contains(t, rr.Body.String(), `is not an operator of room {Id:0 Name:room0}`)
+
+		rr = invokeHandler(server, addWebhookRequest(0, api.AddWebhookArgs{Tag: "alice", URL: hookServer.URL, Format: webhook.Discord}))
+		expectStatus(t, rr, 200)
+		var hook model.Webhook
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&hook))
+		assert.Equal(t, webhook.Discord, hook.Format)
+		assert.NotEmpty(t, hook.Id)
+
+		expectStatus(t, invokeHandler(server, postMessageRequest(0, "bob", "hello")), 200)
+		wg.Wait()
+
+		rr = invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/webhooks?tag=alice", nil))
+		expectStatus(t, rr, 200)
+		var webhooks []model.Webhook
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&webhooks))
+		assert.Len(t, webhooks, 1)
+
+		rr = invokeHandler(server, httptest.NewRequest("DELETE", "/api/rooms/0/webhooks/"+hook.Id+"?tag=alice", nil))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/webhooks?tag=alice", nil))
+		expectStatus(t, rr, 200)
+		expectBody(t, rr, "[]")
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		server := newTestServer()
+		invokeHandler(server, createRoomRequestWithCreator("room0", "alice"))
+		invokeHandler(server, joinRoomRequest(0, "alice", "localhost:6000"))
+
+		rr := invokeHandler(server, addWebhookRequest(0, api.AddWebhookArgs{Tag: "alice", URL: "https://example.com", Format: webhook.Template, Template: "{{"}))
+		expectStatus(t, rr, 400)
+	})
+}
+
+func TestIncomingWebhooks(t *testing.T) {
+	t.Parallel()
+
+	newServerWithHook := func(t *testing.T, callbackUrl string) (*api.Server, api.AddIncomingWebhookResponseBody) {
+		dispatcher := delivery.NewDispatcher(delivery.Config{})
+		server := api.NewServer(model.NewChatRoomStore(model.WithDispatcher(dispatcher)),
+			api.WithLogger(log.New(ioutil.Discard, "", 0)),
+		)
+		expectStatus(t, invokeHandler(server, createRoomRequestWithCreator("room0", "alice")), 200)
+		expectStatus(t, invokeHandler(server, joinRoomRequest(0, "alice", callbackUrl)), 200)
+
+		rr := invokeHandler(server, addIncomingWebhookRequest(0, "alice", "CI"))
+		expectStatus(t, rr, 200)
+		var hook api.AddIncomingWebhookResponseBody
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&hook))
+		return server, hook
+	}
+
+	t.Run("posts json and forms", func(t *testing.T) {
+		messages := make(chan model.CallbackBody, 3)
+		callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body model.CallbackBody
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+			messages <- body
+		}))
+		defer callback.Close()
+
+		server, hook := newServerWithHook(t, callback.URL)
+		assert.Equal(t, "CI", hook.Name)
+		assert.Equal(t, "http://example.com/hooks/"+hook.Token, hook.URL)
+
+		rr := invokeHandler(server, httptest.NewRequest("POST", hook.URL, strings.NewReader(`{"text":"json"}`)))
+		expectStatus(t, rr, 200)
+		expectBody(t, rr, "ok")
+
+		req := httptest.NewRequest("POST", hook.URL, strings.NewReader("text=form"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		expectStatus(t, invokeHandler(server, req), 200)
+
+		req = httptest.NewRequest("POST", hook.URL, strings.NewReader(url.Values{"payload": {`{"text":"payload"}`}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		expectStatus(t, invokeHandler(server, req), 200)
+
+		for i, expected := range []string{"json", "form", "payload"} {
+			select {
+			case body := <-messages:
+				assert.Equal(t, model.CallbackBody{Type: model.MessageEvent, Id: int64(i + 1), Tag: "CI", Message: expected, Webhook: hook.Id}, body)
+			case <-time.After(2 * time.Second):
+				t.Fatalf("expected %q to be delivered", expected)
+			}
+		}
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		server, hook := newServerWithHook(t, "localhost:6000")
+
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/incoming-webhooks?tag=alice", nil))
+		expectStatus(t, rr, 200)
+		assert.NotContains(t, rr.Body.String(), hook.Token)
+
+		rr = invokeHandler(server, httptest.NewRequest("DELETE", "/api/rooms/0/incoming-webhooks/"+hook.Id+"?tag=alice", nil))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, httptest.NewRequest("POST", hook.URL, strings.NewReader(`{"text":"json"}`)))
+		expectStatus(t, rr, 404)
+		expectBody(t, rr, "Invalid webhook")
+	})
+
+	t.Run("rejected messages", func(t *testing.T) {
+		server, hook := newServerWithHook(t, "localhost:6000")
+
+		rr := invokeHandler(server, httptest.NewRequest("POST", hook.URL, strings.NewReader(`{"text":""}`)))
+		expectStatus(t, rr, 400)
+		assert.Contains(t, rr.Body.String(), model.EmptyMessageCode)
+	})
+
+	t.Run("only operators create webhooks", func(t *testing.T) {
+		server, _ := newServerWithHook(t, "localhost:6000")
+		invokeHandler(server, joinRoomRequest(0, "bob", "localhost:6000"))
+
+		rr := invokeHandler(server, addIncomingWebhookRequest(0, "bob", "CI"))
+		expectStatus(t, rr, 403)
+		assert.Contains(t, rr.Body.String(), `"code":"forbidden"`)
+		assert.Contains(t, rr.Body.String(), `is not an operator of room {Id:0 Name:room0}`)
+	})
+
+	t.Run("tokens are kept out of access logs", func(t *testing.T) {
+		var logs bytes.Buffer
+		server := api.NewServer(model.NewChatRoomStore(), api.WithLogger(log.New(&logs, "", 0)))
+
+		invokeHandler(server, httptest.NewRequest("POST", "/hooks/secret", strings.NewReader(`{"text":"json"}`)))
+
+		assert.NotContains(t, logs.String(), "secret")
+		assert.Contains(t, logs.String(), `path="/hooks/REDACTED"`)
+	})
+}
+
+func TestBots(t *testing.T) {
+	t.Parallel()
+
+	t.Run("bots join new rooms and answer commands", func(t *testing.T) {
+		replies := make(chan model.CallbackBody, 1)
+		callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body model.CallbackBody
+			assert.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+			replies <- body
+		}))
+		defer callback.Close()
+
+		dispatcher := delivery.NewDispatcher(delivery.Config{})
+		defer dispatcher.Close()
+		server := api.NewServer(model.NewChatRoomStore(model.WithDispatcher(dispatcher), bot.JoinAll(bot.NewEchoBot("echobot"))),
+			api.WithLogger(log.New(ioutil.Discard, "", 0)),
+		)
+		expectStatus(t, invokeHandler(server, createRoomRequest("room0")), 200)
+		expectStatus(t, invokeHandler(server, joinRoomRequest(0, "alice", callback.URL)), 200)
+
+		rr := invokeHandler(server, listMembersRequest(0))
+		expectStatus(t, rr, 200)
+		var members []model.MemberInfo
+		assert.Nil(t, json.NewDecoder(rr.Body).Decode(&members))
+		assert.Len(t, members, 2)
+		assert.Equal(t, "echobot", members[1].Tag)
+		assert.True(t, members[1].Bot)
+		assert.True(t, members[0].Operator)
+
+		expectStatus(t, invokeHandler(server, postMessageRequest(0, "alice", "!echo hi")), 200)
+
+		select {
+		case reply := <-replies:
+			assert.Equal(t, model.CallbackBody{Type: model.MessageEvent, Id: 2, Tag: "echobot", Message: "alice: hi"}, reply)
+		case <-time.After(2 * time.Second):
+			t.Fatal("expected echobot to reply")
+		}
+	})
+}
+
+func addIncomingWebhookRequest(roomId int, tag string, name string) *http.Request {
+	bs, err := json.Marshal(api.AddIncomingWebhookArgs{Tag: tag, Name: name})
+	if err != nil {
+		log.Panicln(err)
+	}
+	return httptest.NewRequest("POST", fmt.Sprintf("/api/rooms/%d/incoming-webhooks", roomId), bytes.NewReader(bs))
+}
+
+func addWebhookRequest(roomId int, args api.AddWebhookArgs) *http.Request {
+	bs, err := json.Marshal(args)
+	if err != nil {
+		log.Panicln(err)
+	}
+	return httptest.NewRequest("POST", fmt.Sprintf("/api/rooms/%d/webhooks", roomId), bytes.NewReader(bs))
+}
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	t.Run("generates request ids", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, listRoomsRequest())
+		expectStatus(t, rr, 200)
+		assert.Len(t, rr.Header().Get(requestid.Header), 32)
+	})
+
+	t.Run("forwards request ids on callbacks", func(t *testing.T) {
+		server := newTestServer()
+		requestId := "test-request-id"
+
+		received := make(chan string, 1)
+		otherServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			received <- r.Header.Get(requestid.Header)
+		}))
+		defer otherServer.Close()
+
+		rr := invokeHandler(server, createRoomRequest("room0"))
+		expectStatus(t, rr, 200)
+		rr = invokeHandler(server, joinRoomRequest(0, "user1", "localhost:6000"))
+		expectStatus(t, rr, 200)
+		rr = invokeHandler(server, joinRoomRequest(0, "user2", otherServer.URL))
+		expectStatus(t, rr, 200)
+
+		req := postMessageRequest(0, "user1", "hello")
+		req.Header.Set(requestid.Header, requestId)
+		rr = invokeHandler(server, req)
+		expectStatus(t, rr, 200)
+		assert.Equal(t, requestId, rr.Header().Get(requestid.Header))
+
+		assert.Equal(t, requestId, <-received)
+	})
+
+	t.Run("unknown path", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, httptest.NewRequest("GET", "/api/rooms/0/unknown", nil))
+
+		expectStatus(t, rr, 404)
+		expectBody(t, rr, "Invalid path")
+	})
+
+	t.Run("recovers from panics", func(t *testing.T) {
+		logger := log.New(ioutil.Discard, "", 0)
+		handler := api.Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("oops")
+		}), api.RequestIDs, api.RecoverPanics(logger))
+
+		req := listRoomsRequest()
+		req.Header.Set(requestid.Header, "test-request-id")
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		expectStatus(t, rr, 500)
+		expectBody(t, rr, `{"error":"internal server error","requestId":"test-request-id"}`+"\n")
+	})
+}
+
+func TestServerLifecycle(t *testing.T) {
+	t.Parallel()
+
+	t.Run("shutdown stops start", func(t *testing.T) {
+		server := newTestServer()
+
+		errs := make(chan error)
+		go func() {
+			errs <- server.Start("127.0.0.1:0")
+		}()
+
+		// Shutdown may happen before Start has begun serving, in which case
+		// Start returns as soon as it does
+		time.Sleep(10 * time.Millisecond)
+		err := server.Shutdown(context.Background())
+		assert.Nil(t, err)
+		assert.Equal(t, http.ErrServerClosed, <-errs)
+		assert.Nil(t, server.Shutdown(context.Background()), "shutting down twice doesn't panic")
+	})
+
+	t.Run("shutdown notifies members and drains deliveries", func(t *testing.T) {
+		var notices []model.CallbackBody
+		var mu sync.Mutex
+		callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body model.CallbackBody
+			err := json.NewDecoder(r.Body).Decode(&body)
+			assert.Nil(t, err)
+			mu.Lock()
+			notices = append(notices, body)
+			mu.Unlock()
+		}))
+		defer callback.Close()
+
+		dispatcher := delivery.NewDispatcher(delivery.Config{})
+		server := api.NewServer(model.NewChatRoomStore(model.WithDispatcher(dispatcher)),
+			api.WithDispatcher(dispatcher),
+			api.WithShutdownNotice("server shutting down"),
+			api.WithLogger(log.New(ioutil.Discard, "", 0)),
+		)
+		invokeHandler(server, createRoomRequest("room0"))
+		invokeHandler(server, createRoomRequest("room1"))
+		expectStatus(t, invokeHandler(server, joinRoomRequest(0, "user0", callback.URL)), 200)
+		expectStatus(t, invokeHandler(server, joinRoomRequest(1, "user0", callback.URL)), 200)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		assert.Nil(t, err)
+
+		// Shutdown only returns once every notice has been delivered
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Len(t, notices, 2)
+		for _, notice := range notices {
+			assert.Equal(t, model.NoticeEvent, notice.Type)
+			assert.Equal(t, "server shutting down", notice.Message)
+		}
+	})
+
+	t.Run("shutdown reports undelivered messages", func(t *testing.T) {
+		callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(503)
+		}))
+		defer callback.Close()
+
+		dispatcher := delivery.NewDispatcher(delivery.Config{RetryInterval: time.Hour})
+		server := api.NewServer(model.NewChatRoomStore(model.WithDispatcher(dispatcher)),
+			api.WithDispatcher(dispatcher),
+			api.WithShutdownNotice("server shutting down"),
+			api.WithLogger(log.New(ioutil.Discard, "", 0)),
+		)
+		invokeHandler(server, createRoomRequest("room0"))
+		expectStatus(t, invokeHandler(server, joinRoomRequest(0, "user0", callback.URL)), 200)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "1 messages left undelivered")
+	})
+
+	t.Run("servers don't share stores", func(t *testing.T) {
+		server1, server2 := newTestServer(), newTestServer()
+
+		rr := invokeHandler(server1, createRoomRequest("room0"))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server2, listRoomsRequest())
+		expectStatus(t, rr, 200)
+		expectBody(t, rr, "[]")
+	})
+}
+
+func TestDeleteChatRoomHandler(t *testing.T) {
+	t.Parallel()
+
+	roomId := 0
+	roomName := "room0"
+
+	t.Run("delete non-existent room", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, deleteRoomRequest(roomId))
+
+		expectStatus(t, rr, 400)
+		expectBody(t, rr, fmt.Sprintf(`chat room does not exist: "%d"`, roomId))
+	})
+
+	t.Run("delete existing room", func(t *testing.T) {
+		server := newTestServer()
+
+		rr := invokeHandler(server, createRoomRequest(roomName))
+		expectStatus(t, rr, 200)
+
+		rr = invokeHandler(server, deleteRoomRequest(roomId))
+
+		expectStatus(t, rr, 200)
+		expectBody(t, rr, "")
+	})
+}
+
+func newTestServer() *api.Server {
+	return api.NewServer(model.NewChatRoomStore(), api.WithLogger(log.New(ioutil.Discard, "", 0)))
+}
+
+func invokeHandler(server http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	// We create a ResponseRecorder (which satisfies http.ResponseWriter) to record the response.
+	rr := httptest.NewRecorder()
+
+	// Our server satisfies http.Handler, so we can call its ServeHTTP method
+	// directly and pass in our Request and ResponseRecorder.
+	server.ServeHTTP(rr, req)
+
+	return rr
+}
+
+func decodeRoomSummaries(t *testing.T, rr *httptest.ResponseRecorder) []api.ChatRoomSummary {
+	t.Helper()
+	var rooms []api.ChatRoomSummary
+	err := json.NewDecoder(rr.Body).Decode(&rooms)
+	assert.Nil(t, err)
+	return rooms
+}
+
+func expectStatus(t *testing.T, rr *httptest.ResponseRecorder, expected int) {
+	t.Helper()
+	// Check the status code is what we expect.
+	if status := rr.Code; status != expected {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, expected)
+	}
+}
+
+func expectBody(t *testing.T, rr *httptest.ResponseRecorder, expected string) {
+	t.Helper()
+	// Check the response body is what we expect.
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body:\n\tgot:  %v\n\twant: %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func testServerExpectsNoCall(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Fail(t, "expected no callback to message poster")
+	}))
+}
+
+func testServerExpectsCall(t *testing.T, wg *sync.WaitGroup, expectedMessage string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Println("called server!")
+		assert.Equal(t, r.Method, http.MethodPost)
+
+		var body model.CallbackBody
+		err := json.NewDecoder(r.Body).Decode(&body)
+		assert.Nil(t, err)
 		assert.Equal(t, expectedMessage, body.Message)
 		wg.Done()
 	}))
 }
+
+func testServerExpectsTopic(t *testing.T, wg *sync.WaitGroup, expectedSetBy string, expectedTopic string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body model.CallbackBody
+		err := json.NewDecoder(r.Body).Decode(&body)
+		assert.Nil(t, err)
+		assert.Equal(t, model.TopicEvent, body.Type)
+		assert.Equal(t, expectedTopic, body.Topic.Text)
+		assert.Equal(t, expectedSetBy, body.Topic.SetBy)
+		wg.Done()
+	}))
+}
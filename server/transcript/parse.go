@@ -0,0 +1,264 @@
+package transcript
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"irc/server/model"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse reads a transcript in the given format: JSON lines as written by the
+// JSON format, or an IRC log as irssi or weechat write them, which includes
+// those written by the IRCLog format. IRC logs are read in UTC, and their
+// lines that don't describe a message or event, like client notices, are
+// skipped and counted. The messages returned are marked as imported and
+// have no IDs, which rooms assign.
+func Parse(format Format, r io.Reader) (messages []model.Message, skipped int, err error) {
+	switch format {
+	case JSON:
+		messages, err = parseJSON(r)
+	case IRCLog:
+		messages, skipped, err = parseLog(r)
+	default:
+		err = fmt.Errorf("transcripts can't be imported from the %q format", format)
+	}
+	for i := range messages {
+		messages[i].Id = 0
+		messages[i].Imported = true
+	}
+	return messages, skipped, err
+}
+
+func parseJSON(r io.Reader) ([]model.Message, error) {
+	var messages []model.Message
+	decoder := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var m model.Message
+		err := decoder.Decode(&m)
+		if err == io.EOF {
+			return messages, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %v", line, err)
+		}
+		if m.Type == "" || m.Time.IsZero() {
+			return nil, fmt.Errorf("entry %d: type and time are required", line)
+		}
+		messages = append(messages, m)
+	}
+}
+
+var (
+	// weechat: "2021-01-01 23:58:00\tprefix\ttext"
+	weechatLine = regexp.MustCompile(`^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\t([^\t]*)\t(.*)$`)
+	// irssi: "23:58 text" or "[23:58] text", optionally with seconds
+	irssiLine = regexp.MustCompile(`^\[?(\d{2}):(\d{2})(?::(\d{2}))?\]? (.*)$`)
+
+	irssiMessage = regexp.MustCompile(`^<[ @+%~&]?([^>]+)> ?(.*)$`)
+	irssiAction  = regexp.MustCompile(`^ ?\* (\S+) (.*)$`)
+	irssiNotice  = regexp.MustCompile(`^-server- (.*)$`)
+	irssiJoin    = regexp.MustCompile(`^-!- (\S+) (?:\[[^\]]*\] )?has joined \S+$`)
+	irssiLeave   = regexp.MustCompile(`^-!- (\S+) (?:\[[^\]]*\] )?has (left \S+|quit)(?: \[(.*)\])?$`)
+	irssiKick    = regexp.MustCompile(`^-!- (\S+) was kicked from \S+ by (\S+)(?: \[(.*)\])?$`)
+	irssiTopic   = regexp.MustCompile(`^-!- (\S+) changed the topic of \S+ to: (.*)$`)
+	irssiNick    = regexp.MustCompile(`^-!- (\S+) is now known as (\S+)$`)
+	irssiMode    = regexp.MustCompile(`^-!- mode/\S+ \[(\S+)(?: (\S+))?\] by (\S+)$`)
+	irssiInvite  = regexp.MustCompile(`^-!- (\S+) invited (\S+) to \S+$`)
+
+	weechatJoin  = regexp.MustCompile(`^(\S+) (?:\([^)]*\) )?has joined \S+$`)
+	weechatLeave = regexp.MustCompile(`^(\S+) (?:\([^)]*\) )?has (left \S+|quit)(?: \((.*)\))?$`)
+	weechatNick  = regexp.MustCompile(`^(\S+) is now known as (\S+)$`)
+	weechatTopic = regexp.MustCompile(`^(\S+) has changed topic for \S+ (?:from ".*" )?to "(.*)"$`)
+	weechatMode  = regexp.MustCompile(`^Mode \S+ \[(\S+)(?: (\S+))?\] by (\S+)$`)
+)
+
+const (
+	weechatTime = "2006-01-02 15:04:05"
+)
+
+// logParser reads IRC logs a line at a time. irssi logs only give the date
+// in their "Log opened" and "Day changed" lines, so it's kept between lines.
+type logParser struct {
+	date    time.Time
+	skipped int
+}
+
+func parseLog(r io.Reader) ([]model.Message, int, error) {
+	p := &logParser{}
+	var messages []model.Message
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		m, ok, err := p.parse(strings.TrimRight(scanner.Text(), "\r"))
+		if err != nil {
+			return nil, 0, fmt.Errorf("line %d: %v", line, err)
+		}
+		if ok {
+			messages = append(messages, m)
+		}
+	}
+	return messages, p.skipped, scanner.Err()
+}
+
+// parse parses a line, reporting whether it's a message or event.
+func (p *logParser) parse(line string) (model.Message, bool, error) {
+	if strings.TrimSpace(line) == "" {
+		return model.Message{}, false, nil
+	}
+	if strings.HasPrefix(line, "--- ") {
+		return model.Message{}, false, p.parseMarker(line)
+	}
+
+	if match := weechatLine.FindStringSubmatch(line); match != nil {
+		t, err := time.Parse(weechatTime, match[1])
+		if err != nil {
+			return model.Message{}, false, err
+		}
+		m, ok := weechatEvent(match[2], match[3])
+		return p.found(m, t, ok)
+	}
+
+	if match := irssiLine.FindStringSubmatch(line); match != nil {
+		if p.date.IsZero() {
+			return model.Message{}, false, fmt.Errorf(`time stamp without a date; irssi logs must start with a "--- Log opened" line`)
+		}
+		hour, _ := strconv.Atoi(match[1])
+		minute, _ := strconv.Atoi(match[2])
+		second, _ := strconv.Atoi(match[3])
+		if hour > 23 || minute > 59 || second > 59 {
+			return model.Message{}, false, fmt.Errorf("invalid time stamp %q", line[:len(line)-len(match[4])-1])
+		}
+		t := p.date.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second)
+		m, ok := irssiEvent(match[4])
+		return p.found(m, t, ok)
+	}
+
+	p.skipped += 1
+	return model.Message{}, false, nil
+}
+
+func (p *logParser) found(m model.Message, t time.Time, ok bool) (model.Message, bool, error) {
+	if !ok {
+		p.skipped += 1
+		return model.Message{}, false, nil
+	}
+	m.Time = t
+	return m, true, nil
+}
+
+// parseMarker parses irssi's "--- Log opened", "--- Log closed" and "---
+// Day changed" lines.
+func (p *logParser) parseMarker(line string) error {
+	switch {
+	case strings.HasPrefix(line, "--- Log opened "):
+		t, err := time.Parse(logOpened, line)
+		if err != nil {
+			return fmt.Errorf("invalid date: %q", line)
+		}
+		p.date = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case strings.HasPrefix(line, "--- Day changed "):
+		t, err := time.Parse(dayChanged, line)
+		if err != nil {
+			return fmt.Errorf("invalid date: %q", line)
+		}
+		p.date = t
+	case strings.HasPrefix(line, "--- Log closed "):
+	default:
+		p.skipped += 1
+	}
+	return nil
+}
+
+func irssiEvent(text string) (model.Message, bool) {
+	if match := irssiMessage.FindStringSubmatch(text); match != nil {
+		return model.Message{Type: model.MessageEvent, Tag: match[1], Text: match[2]}, true
+	}
+	if match := irssiAction.FindStringSubmatch(text); match != nil {
+		return model.Message{Type: model.ActionEvent, Tag: match[1], Text: match[2]}, true
+	}
+	if match := irssiNotice.FindStringSubmatch(text); match != nil {
+		return model.Message{Type: model.NoticeEvent, Text: match[1]}, true
+	}
+	if match := irssiJoin.FindStringSubmatch(text); match != nil {
+		return model.Message{Type: model.JoinEvent, Tag: match[1]}, true
+	}
+	if match := irssiLeave.FindStringSubmatch(text); match != nil {
+		return leave(match[1], match[2], match[3]), true
+	}
+	if match := irssiKick.FindStringSubmatch(text); match != nil {
+		reason := "kicked by " + match[2]
+		if match[3] != "" && match[3] != match[2] {
+			reason += ": " + match[3]
+		}
+		return model.Message{Type: model.LeaveEvent, Tag: match[1], Text: reason}, true
+	}
+	if match := irssiTopic.FindStringSubmatch(text); match != nil {
+		return model.Message{Type: model.TopicEvent, Tag: match[1], Text: match[2]}, true
+	}
+	if match := irssiNick.FindStringSubmatch(text); match != nil {
+		return model.Message{Type: model.NickEvent, Tag: match[1], Target: match[2]}, true
+	}
+	if match := irssiMode.FindStringSubmatch(text); match != nil {
+		return mode(match[1], match[2], match[3]), true
+	}
+	if match := irssiInvite.FindStringSubmatch(text); match != nil {
+		return model.Message{Type: model.InviteEvent, Tag: match[1], Target: match[2]}, true
+	}
+	return model.Message{}, false
+}
+
+func weechatEvent(prefix string, text string) (model.Message, bool) {
+	switch prefix {
+	case "-->":
+		if match := weechatJoin.FindStringSubmatch(text); match != nil {
+			return model.Message{Type: model.JoinEvent, Tag: match[1]}, true
+		}
+	case "<--":
+		if match := weechatLeave.FindStringSubmatch(text); match != nil {
+			return leave(match[1], match[2], match[3]), true
+		}
+	case "--":
+		if match := weechatNick.FindStringSubmatch(text); match != nil {
+			return model.Message{Type: model.NickEvent, Tag: match[1], Target: match[2]}, true
+		}
+		if match := weechatTopic.FindStringSubmatch(text); match != nil {
+			return model.Message{Type: model.TopicEvent, Tag: match[1], Text: match[2]}, true
+		}
+		if match := weechatMode.FindStringSubmatch(text); match != nil {
+			return mode(match[1], match[2], match[3]), true
+		}
+	case "*", " *":
+		if tag := strings.Fields(text); len(tag) > 0 {
+			return model.Message{Type: model.ActionEvent, Tag: tag[0], Text: strings.TrimSpace(strings.TrimPrefix(text, tag[0]))}, true
+		}
+	case "", "=!=", "-":
+	default:
+		tag := strings.TrimLeft(prefix, "@+%~&")
+		return model.Message{Type: model.MessageEvent, Tag: tag, Text: text}, true
+	}
+	return model.Message{}, false
+}
+
+// leave describes a part or quit, keeping the reason given if any.
+func leave(tag string, how string, reason string) model.Message {
+	if how == "quit" && reason != "" {
+		reason = "quit: " + reason
+	} else if how == "quit" {
+		reason = "quit"
+	}
+	return model.Message{Type: model.LeaveEvent, Tag: tag, Text: reason}
+}
+
+// mode describes a mode change. Changes of room modes keep the resulting
+// modes without their '+', as rooms record them.
+func mode(modes string, target string, by string) model.Message {
+	if target == "" {
+		modes = strings.TrimPrefix(modes, "+")
+	}
+	return model.Message{Type: model.ModeEvent, Tag: by, Modes: modes, Target: target}
+}
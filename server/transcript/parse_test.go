@@ -0,0 +1,95 @@
+package transcript
+
+import (
+	"bytes"
+	"irc/server/model"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func parse(t *testing.T, format Format, text string) ([]model.Message, int) {
+	t.Helper()
+	messages, skipped, err := Parse(format, strings.NewReader(text))
+	assert.Nil(t, err)
+	return messages, skipped
+}
+
+func TestParseIrssi(t *testing.T) {
+	messages, skipped := parse(t, IRCLog, strings.Join([]string{
+		"--- Log opened Fri Jan 01 23:58:00 2021",
+		"23:58 -!- alice [~alice@example.com] has joined #general",
+		"23:58 <@alice> hello",
+		"23:59 < bob> hi",
+		"23:59 -!- Irssi: Join to #general was synced in 0 secs",
+		"--- Day changed Sat Jan 02 2021",
+		"00:00:30  * alice celebrates",
+		"00:01 -!- bob was kicked from #general by alice [too loud]",
+		"00:02 -!- carol [~carol@example.com] has quit [Ping timeout]",
+		"00:03 -!- mode/#general [+o carol] by alice",
+		"--- Log closed Sat Jan 02 00:03:00 2021",
+	}, "\n"))
+
+	day := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, []model.Message{
+		{Type: model.JoinEvent, Tag: "alice", Time: day.Add(23*time.Hour + 58*time.Minute), Imported: true},
+		{Type: model.MessageEvent, Tag: "alice", Text: "hello", Time: day.Add(23*time.Hour + 58*time.Minute), Imported: true},
+		{Type: model.MessageEvent, Tag: "bob", Text: "hi", Time: day.Add(23*time.Hour + 59*time.Minute), Imported: true},
+		{Type: model.ActionEvent, Tag: "alice", Text: "celebrates", Time: day.Add(24*time.Hour + 30*time.Second), Imported: true},
+		{Type: model.LeaveEvent, Tag: "bob", Text: "kicked by alice: too loud", Time: day.Add(24*time.Hour + time.Minute), Imported: true},
+		{Type: model.LeaveEvent, Tag: "carol", Text: "quit: Ping timeout", Time: day.Add(24*time.Hour + 2*time.Minute), Imported: true},
+		{Type: model.ModeEvent, Tag: "alice", Modes: "+o", Target: "carol", Time: day.Add(24*time.Hour + 3*time.Minute), Imported: true},
+	}, messages)
+	assert.Equal(t, 1, skipped)
+}
+
+func TestParseWeechat(t *testing.T) {
+	messages, skipped := parse(t, IRCLog, strings.Join([]string{
+		"2021-01-01 23:58:00\t-->\talice (~alice@example.com) has joined #general",
+		"2021-01-01 23:58:10\t@alice\thello",
+		"2021-01-01 23:58:20\t *\talice waves",
+		"2021-01-01 23:58:30\t--\talice has changed topic for #general from \"old\" to \"new\"",
+		"2021-01-01 23:58:40\t--\talice is now known as alicia",
+		"2021-01-01 23:58:50\t<--\talicia (~alice@example.com) has left #general (bye)",
+		"2021-01-01 23:59:00\t=!=\tsomething went wrong",
+	}, "\n"))
+
+	at := time.Date(2021, 1, 1, 23, 58, 0, 0, time.UTC)
+	assert.Equal(t, []model.Message{
+		{Type: model.JoinEvent, Tag: "alice", Time: at, Imported: true},
+		{Type: model.MessageEvent, Tag: "alice", Text: "hello", Time: at.Add(10 * time.Second), Imported: true},
+		{Type: model.ActionEvent, Tag: "alice", Text: "waves", Time: at.Add(20 * time.Second), Imported: true},
+		{Type: model.TopicEvent, Tag: "alice", Text: "new", Time: at.Add(30 * time.Second), Imported: true},
+		{Type: model.NickEvent, Tag: "alice", Target: "alicia", Time: at.Add(40 * time.Second), Imported: true},
+		{Type: model.LeaveEvent, Tag: "alicia", Text: "bye", Time: at.Add(50 * time.Second), Imported: true},
+	}, messages)
+	assert.Equal(t, 1, skipped)
+}
+
+func TestParseRoundTrip(t *testing.T) {
+	for _, format := range []Format{IRCLog, JSON} {
+		t.Run(string(format), func(t *testing.T) {
+			messages, skipped := parse(t, format, write(t, format))
+			assert.Equal(t, 0, skipped)
+			assert.Len(t, messages, len(history))
+			for i, m := range messages {
+				expected := history[i]
+				expected.Id, expected.Imported = 0, true
+				assert.Equal(t, expected, m)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, _, err := Parse(IRCLog, strings.NewReader("12:00 <alice> when was this?"))
+	assert.EqualError(t, err, `line 1: time stamp without a date; irssi logs must start with a "--- Log opened" line`)
+
+	_, _, err = Parse(JSON, strings.NewReader(`{"type":"message"}`))
+	assert.NotNil(t, err)
+
+	_, _, err = Parse(HTML, &bytes.Buffer{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,250 @@
+// Package transcript writes room histories out as transcripts, in formats
+// that people and other tools read.
+package transcript
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"irc/server/model"
+	"strings"
+	"time"
+)
+
+// Format selects how a transcript is written.
+type Format string
+
+const (
+	// JSON writes each model.Message as a line of JSON.
+	JSON Format = "json"
+	// IRCLog writes "[HH:MM] <tag> text" lines, as IRC clients log channels.
+	IRCLog Format = "irclog"
+	// HTML writes a self-contained web page.
+	HTML Format = "html"
+)
+
+// ParseFormat parses the name of a Format.
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(name); format {
+	case JSON, IRCLog, HTML:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown transcript format %q", name)
+	}
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case IRCLog:
+		return "text/plain; charset=utf-8"
+	case HTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/x-ndjson"
+	}
+}
+
+// Extension is the file name extension of transcripts in the format.
+func (f Format) Extension() string {
+	switch f {
+	case IRCLog:
+		return ".log"
+	case HTML:
+		return ".html"
+	default:
+		return ".jsonl"
+	}
+}
+
+// Writer writes a room's history one entry at a time, oldest first, so a
+// transcript needn't be held in memory.
+type Writer interface {
+	Write(m model.Message) error
+	// Close writes whatever ends the transcript. It doesn't close the
+	// underlying io.Writer.
+	Close() error
+}
+
+// NewWriter returns a Writer of the transcript of the named room to w.
+func NewWriter(format Format, w io.Writer, room string) (Writer, error) {
+	room = channel(room)
+	switch format {
+	case JSON:
+		return jsonWriter{json.NewEncoder(w)}, nil
+	case IRCLog:
+		return &logWriter{w: w, room: room}, nil
+	case HTML:
+		hw := &htmlWriter{w: w, room: room}
+		return hw, htmlTemplate.ExecuteTemplate(w, "header", room)
+	default:
+		return nil, fmt.Errorf("unknown transcript format %q", format)
+	}
+}
+
+// channel names a room as IRC does, with a '#' prefix.
+func channel(room string) string {
+	return string(model.ChatRoomNamePrefix) + strings.TrimPrefix(room, string(model.ChatRoomNamePrefix))
+}
+
+type jsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (w jsonWriter) Write(m model.Message) error {
+	return w.encoder.Encode(m)
+}
+
+func (w jsonWriter) Close() error {
+	return nil
+}
+
+// Layouts of the lines irssi writes when a log is opened or closed, and when
+// the day changes.
+const (
+	logOpened  = "--- Log opened Mon Jan 02 15:04:05 2006"
+	logClosed  = "--- Log closed Mon Jan 02 15:04:05 2006"
+	dayChanged = "--- Day changed Mon Jan 02 2006"
+	timeStamp  = "[15:04]"
+)
+
+// logWriter writes IRC logs, in UTC.
+type logWriter struct {
+	w    io.Writer
+	room string
+	last time.Time
+}
+
+func (w *logWriter) Write(m model.Message) error {
+	t := m.Time.UTC()
+	switch {
+	case w.last.IsZero():
+		if _, err := fmt.Fprintln(w.w, t.Format(logOpened)); err != nil {
+			return err
+		}
+	case !sameDay(w.last, t):
+		if _, err := fmt.Fprintln(w.w, t.Format(dayChanged)); err != nil {
+			return err
+		}
+	}
+	w.last = t
+
+	_, err := fmt.Fprintf(w.w, "%s %s\n", t.Format(timeStamp), Line(m, w.room))
+	return err
+}
+
+func (w *logWriter) Close() error {
+	if w.last.IsZero() {
+		return nil
+	}
+	_, err := fmt.Fprintln(w.w, w.last.Format(logClosed))
+	return err
+}
+
+func sameDay(a time.Time, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
+
+// Line describes m as IRC clients log it, without the time stamp, e.g.
+// "<alice> hello" or "-!- bob has joined #general".
+func Line(m model.Message, room string) string {
+	room = channel(room)
+	switch m.Type {
+	case model.MessageEvent:
+		return fmt.Sprintf("<%s> %s", m.Tag, m.Text)
+	case model.ActionEvent:
+		return fmt.Sprintf(" * %s %s", m.Tag, m.Text)
+	case model.NoticeEvent:
+		return fmt.Sprintf("-server- %s", m.Text)
+	case model.JoinEvent:
+		return fmt.Sprintf("-!- %s has joined %s", m.Tag, room)
+	case model.LeaveEvent:
+		if m.Text != "" {
+			return fmt.Sprintf("-!- %s has left %s [%s]", m.Tag, room, m.Text)
+		}
+		return fmt.Sprintf("-!- %s has left %s", m.Tag, room)
+	case model.TopicEvent:
+		return fmt.Sprintf("-!- %s changed the topic of %s to: %s", m.Tag, room, m.Text)
+	case model.NickEvent:
+		return fmt.Sprintf("-!- %s is now known as %s", m.Tag, m.Target)
+	case model.ModeEvent:
+		if m.Target != "" {
+			return fmt.Sprintf("-!- mode/%s [%s %s] by %s", room, m.Modes, m.Target, m.Tag)
+		}
+		return fmt.Sprintf("-!- mode/%s [+%s] by %s", room, m.Modes, m.Tag)
+	case model.InviteEvent:
+		return fmt.Sprintf("-!- %s invited %s to %s", m.Tag, m.Target, room)
+	default:
+		return fmt.Sprintf("-!- %s %s", m.Type, m.Text)
+	}
+}
+
+var htmlTemplate = template.Must(template.New("transcript").Parse(`
+{{- define "header" -}}
+<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}}</title>
+<style>
+body { font-family: monospace; margin: 2em; }
+h2 { font-size: 1em; color: #666; margin: 1em 0 0.5em; }
+.line { white-space: pre-wrap; }
+time { color: #999; }
+.event { color: #666; }
+.action { font-style: italic; }
+</style>
+</head>
+<body>
+<h1>{{.}}</h1>
+{{end -}}
+{{- define "day" -}}
+<h2>{{.Format "Monday, January 2 2006"}}</h2>
+{{end -}}
+{{- define "line" -}}
+<div class="line {{.Class}}"{{if .Id}} id="{{.Id}}"{{end}}><time datetime="{{.Time.Format "2006-01-02T15:04:05Z07:00"}}">{{.Time.Format "15:04"}}</time> {{.Text}}</div>
+{{end -}}
+{{- define "footer" -}}
+</body>
+</html>
+{{end -}}
+`))
+
+// htmlWriter writes a web page, in UTC, with a heading for each day.
+type htmlWriter struct {
+	w    io.Writer
+	room string
+	last time.Time
+}
+
+func (w *htmlWriter) Write(m model.Message) error {
+	t := m.Time.UTC()
+	if w.last.IsZero() || !sameDay(w.last, t) {
+		if err := htmlTemplate.ExecuteTemplate(w.w, "day", t); err != nil {
+			return err
+		}
+	}
+	w.last = t
+
+	class := "event"
+	switch m.Type {
+	case model.MessageEvent, model.ActionEvent:
+		class = m.Type
+	}
+	id := ""
+	if m.Id != 0 {
+		id = fmt.Sprintf("m%d", m.Id)
+	}
+	return htmlTemplate.ExecuteTemplate(w.w, "line", struct {
+		Class string
+		Id    string
+		Time  time.Time
+		Text  string
+	}{class, id, t, strings.TrimSpace(Line(m, w.room))})
+}
+
+func (w *htmlWriter) Close() error {
+	return htmlTemplate.ExecuteTemplate(w.w, "footer", nil)
+}
@@ -0,0 +1,82 @@
+package transcript
+
+import (
+	"bytes"
+	"irc/server/model"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var at = time.Date(2021, 1, 1, 23, 58, 0, 0, time.UTC)
+
+var history = []model.Message{
+	{Type: model.JoinEvent, Tag: "alice", Time: at},
+	{Id: 1, Type: model.MessageEvent, Tag: "alice", Text: "hello <world>", Time: at},
+	{Type: model.TopicEvent, Tag: "alice", Text: "new year", Time: at.Add(time.Minute)},
+	{Id: 2, Type: model.ActionEvent, Tag: "alice", Text: "celebrates", Time: at.Add(2 * time.Minute)},
+	{Type: model.ModeEvent, Tag: "alice", Modes: "+o", Target: "bob", Time: at.Add(3 * time.Minute)},
+	{Type: model.LeaveEvent, Tag: "bob", Text: "kicked by alice", Time: at.Add(3 * time.Minute)},
+}
+
+func write(t *testing.T, format Format) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := NewWriter(format, &buf, "general")
+	assert.Nil(t, err)
+	for _, m := range history {
+		assert.Nil(t, w.Write(m))
+	}
+	assert.Nil(t, w.Close())
+	return buf.String()
+}
+
+func TestWriters(t *testing.T) {
+	t.Run("irclog", func(t *testing.T) {
+		assert.Equal(t, strings.Join([]string{
+			"--- Log opened Fri Jan 01 23:58:00 2021",
+			"[23:58] -!- alice has joined #general",
+			"[23:58] <alice> hello <world>",
+			"[23:59] -!- alice changed the topic of #general to: new year",
+			"--- Day changed Sat Jan 02 2021",
+			"[00:00]  * alice celebrates",
+			"[00:01] -!- mode/#general [+o bob] by alice",
+			"[00:01] -!- bob has left #general [kicked by alice]",
+			"--- Log closed Sat Jan 02 00:01:00 2021",
+			"",
+		}, "\n"), write(t, IRCLog))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSpace(write(t, JSON)), "\n")
+		assert.Len(t, lines, len(history))
+		assert.JSONEq(t, `{"id":1,"type":"message","tag":"alice","text":"hello <world>","time":"2021-01-01T23:58:00Z"}`, lines[1])
+	})
+
+	t.Run("html", func(t *testing.T) {
+		page := write(t, HTML)
+		assert.True(t, strings.HasPrefix(page, "<!DOCTYPE html>"))
+		assert.Contains(t, page, "<title>#general</title>")
+		assert.Contains(t, page, `<div class="line message" id="m1"><time datetime="2021-01-01T23:58:00Z">23:58</time> &lt;alice&gt; hello &lt;world&gt;</div>`)
+		assert.Contains(t, page, "<h2>Saturday, January 2 2021</h2>")
+		assert.True(t, strings.HasSuffix(page, "</html>\n"))
+	})
+
+	t.Run("empty irclog", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, _ := NewWriter(IRCLog, &buf, "general")
+		assert.Nil(t, w.Close())
+		assert.Empty(t, buf.String())
+	})
+}
+
+func TestParseFormat(t *testing.T) {
+	format, err := ParseFormat("irclog")
+	assert.Nil(t, err)
+	assert.Equal(t, IRCLog, format)
+
+	_, err = ParseFormat("pdf")
+	assert.NotNil(t, err)
+}
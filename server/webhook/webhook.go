@@ -0,0 +1,156 @@
+// Package webhook renders room events as the payloads of outgoing webhooks,
+// in the formats other chat tools accept.
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Format selects the payload a webhook is sent.
+type Format string
+
+const (
+	// Slack is the payload of a Slack incoming webhook.
+	Slack Format = "slack"
+	// Discord is the payload of a Discord webhook.
+	Discord Format = "discord"
+	// JSON is the Event itself, as JSON.
+	JSON Format = "json"
+	// Template executes a user supplied text/template with the Event. The
+	// template can use the json function to quote values, e.g.
+	// {"text": {{json .Message}}}.
+	Template Format = "template"
+)
+
+// Event is a room event to be sent to webhooks.
+type Event struct {
+	Type    string    `json:"type"`
+	Room    string    `json:"room"`
+	Tag     string    `json:"tag,omitempty"`
+	Message string    `json:"message,omitempty"`
+	Topic   string    `json:"topic,omitempty"`
+	Time    time.Time `json:"time"`
+	Part    int       `json:"part,omitempty"`
+	Parts   int       `json:"parts,omitempty"`
+}
+
+// Text describes the event in a line of text, for formats that take one.
+func (e Event) Text() string {
+	switch {
+	case e.Topic != "":
+		return fmt.Sprintf("%s set the topic of #%s to: %s", e.Tag, e.Room, e.Topic)
+	case e.Type == "action":
+		return fmt.Sprintf("* %s %s", e.Tag, e.Message)
+	case e.Tag == "":
+		return e.Message
+	default:
+		return fmt.Sprintf("<%s> %s", e.Tag, e.Message)
+	}
+}
+
+// Formatter renders events as webhook payloads.
+type Formatter interface {
+	Format(e Event) ([]byte, error)
+}
+
+// NewFormatter returns the Formatter for format. text is the template for
+// the Template format, and must be empty for the others.
+func NewFormatter(format Format, text string) (Formatter, error) {
+	if format != Template && text != "" {
+		return nil, fmt.Errorf("a template can only be given with the %q format", Template)
+	}
+
+	switch format {
+	case Slack:
+		return formatFunc(slack), nil
+	case Discord:
+		return formatFunc(discord), nil
+	case JSON, "":
+		return formatFunc(generic), nil
+	case Template:
+		if text == "" {
+			return nil, fmt.Errorf("the %q format needs a template", Template)
+		}
+		t, err := template.New("webhook").Funcs(template.FuncMap{"json": quote}).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		return templateFormatter{t}, nil
+	default:
+		return nil, fmt.Errorf("unknown webhook format %q", format)
+	}
+}
+
+type formatFunc func(e Event) ([]byte, error)
+
+func (f formatFunc) Format(e Event) ([]byte, error) {
+	return f(e)
+}
+
+// slackEscaper escapes the characters Slack treats as markup
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func slack(e Event) ([]byte, error) {
+	text := slackEscaper.Replace(e.Message)
+	switch {
+	case e.Topic != "":
+		text = fmt.Sprintf("*%s* set the topic of #%s to: %s", e.Tag, e.Room, slackEscaper.Replace(e.Topic))
+	case e.Type == "action":
+		text = fmt.Sprintf("_%s %s_", e.Tag, text)
+	case e.Tag != "":
+		text = fmt.Sprintf("*%s*: %s", e.Tag, text)
+	}
+	return marshal(struct {
+		Text string `json:"text"`
+	}{text})
+}
+
+func discord(e Event) ([]byte, error) {
+	// Discord shows username as the author, so the tag needn't be repeated
+	content := e.Text()
+	if e.Type == "message" && e.Tag != "" {
+		content = e.Message
+	}
+	return marshal(struct {
+		Content  string `json:"content"`
+		Username string `json:"username,omitempty"`
+	}{content, e.Tag})
+}
+
+func generic(e Event) ([]byte, error) {
+	return marshal(e)
+}
+
+func quote(v interface{}) (string, error) {
+	bs, err := marshal(v)
+	return string(bs), err
+}
+
+// marshal is json.Marshal without escaping <, > and &, which other tools
+// would show as is.
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+type templateFormatter struct {
+	t *template.Template
+}
+
+func (f templateFormatter) Format(e Event) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := f.t.Execute(&buf, e); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	at      = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	message = Event{Type: "message", Room: "general", Tag: "alice", Message: `say "hi"`, Time: at}
+	topic   = Event{Type: "topic", Room: "general", Tag: "bob", Topic: "news", Time: at}
+	notice  = Event{Type: "notice", Room: "general", Message: "server shutting down", Time: at}
+	action  = Event{Type: "action", Room: "general", Tag: "alice", Message: "waves", Time: at}
+)
+
+func format(t *testing.T, format Format, template string, e Event) string {
+	t.Helper()
+	f, err := NewFormatter(format, template)
+	assert.Nil(t, err)
+	bs, err := f.Format(e)
+	assert.Nil(t, err)
+	return string(bs)
+}
+
+func TestFormats(t *testing.T) {
+	t.Run("slack", func(t *testing.T) {
+		assert.JSONEq(t, `{"text":"*alice*: say \"hi\""}`, format(t, Slack, "", message))
+		assert.JSONEq(t, `{"text":"*bob* set the topic of #general to: news"}`, format(t, Slack, "", topic))
+		assert.JSONEq(t, `{"text":"*alice*: 1 &lt; 2 &amp;&amp; &lt;@U123&gt;"}`,
+			format(t, Slack, "", Event{Type: "message", Room: "general", Tag: "alice", Message: "1 < 2 && <@U123>"}))
+		assert.JSONEq(t, `{"text":"server shutting down"}`, format(t, Slack, "", notice))
+		assert.JSONEq(t, `{"text":"_alice waves_"}`, format(t, Slack, "", action))
+	})
+
+	t.Run("discord", func(t *testing.T) {
+		assert.JSONEq(t, `{"content":"say \"hi\"","username":"alice"}`, format(t, Discord, "", message))
+		assert.JSONEq(t, `{"content":"bob set the topic of #general to: news","username":"bob"}`, format(t, Discord, "", topic))
+		assert.JSONEq(t, `{"content":"server shutting down"}`, format(t, Discord, "", notice))
+		assert.JSONEq(t, `{"content":"* alice waves","username":"alice"}`, format(t, Discord, "", action))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		assert.JSONEq(t, `{"type":"message","room":"general","tag":"alice","message":"say \"hi\"","time":"2021-01-01T00:00:00Z"}`,
+			format(t, JSON, "", message))
+	})
+
+	t.Run("template", func(t *testing.T) {
+		assert.Equal(t, `{"room":"general","who":"alice","said":"say \"hi\""}`,
+			format(t, Template, `{"room":{{json .Room}},"who":{{json .Tag}},"said":{{json .Message}}}`, message))
+		assert.Equal(t, "#general: <alice> say \"hi\"", format(t, Template, "#{{.Room}}: {{.Text}}", message))
+	})
+}
+
+func TestNewFormatter(t *testing.T) {
+	invalid := []struct {
+		format   Format
+		template string
+	}{
+		{"xml", ""},
+		{Template, ""},
+		{Template, "{{.Unclosed"},
+		{Slack, "{{.Room}}"},
+	}
+	for _, args := range invalid {
+		_, err := NewFormatter(args.format, args.template)
+		assert.NotNil(t, err, args)
+	}
+}